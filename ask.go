@@ -0,0 +1,115 @@
+package redisc
+
+import (
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// maxAskCache is the maximum number of keys for which the target of an
+// ASK redirection is cached, see AskCacheTTL.
+const maxAskCache = 1024
+
+// askTarget is the target of an ASK redirection cached for a key.
+type askTarget struct {
+	re      *RedirError
+	expires time.Time
+}
+
+// cmdKey returns the key of the command, or false if the command has no
+// key or more than one key.
+func (c *Cluster) cmdKey(cmd string, args []interface{}) (string, bool) {
+	name := strings.ToUpper(cmd)
+	if keylessCmds[name] {
+		return "", false
+	}
+	if keys, ok := c.cmdKeys(name, args); ok {
+		if len(keys) != 1 {
+			return "", false
+		}
+		return keyString(keys[0]), true
+	}
+	if len(args) > 0 {
+		return keyString(args[0]), true
+	}
+	return "", false
+}
+
+// cachedAsk returns the cached target of an ASK redirection for the key
+// of the command, or nil if there is none.
+func (c *Cluster) cachedAsk(cmd string, args []interface{}) *RedirError {
+	if c.AskCacheTTL <= 0 {
+		return nil
+	}
+	key, ok := c.cmdKey(cmd, args)
+	if !ok {
+		return nil
+	}
+
+	c.askMu.Lock()
+	defer c.askMu.Unlock()
+	t, ok := c.asks[key]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(t.expires) {
+		delete(c.asks, key)
+		return nil
+	}
+	return t.re
+}
+
+// updateAskCache updates the cached target of the ASK redirection re for
+// the key of the command, given the result err of the command on that
+// target: it is cached if it is not already and if the command
+// succeeded, and it is forgotten if the command failed with a
+// redirection, a TRYAGAIN or a connection error.
+func (c *Cluster) updateAskCache(re *RedirError, cmd string, args []interface{}, err error) {
+	if c.AskCacheTTL <= 0 {
+		return
+	}
+	key, ok := c.cmdKey(cmd, args)
+	if !ok {
+		return
+	}
+
+	c.askMu.Lock()
+	defer c.askMu.Unlock()
+	if askFailed(err) {
+		delete(c.asks, key)
+		return
+	}
+	if _, ok := c.asks[key]; ok {
+		// the TTL starts at the first redirection
+		return
+	}
+
+	now := time.Now()
+	if len(c.asks) >= maxAskCache {
+		for k, t := range c.asks {
+			if now.After(t.expires) {
+				delete(c.asks, k)
+			}
+		}
+		if len(c.asks) >= maxAskCache {
+			return
+		}
+	}
+	if c.asks == nil {
+		c.asks = make(map[string]askTarget)
+	}
+	c.asks[key] = askTarget{re: re, expires: now.Add(c.AskCacheTTL)}
+}
+
+// askFailed returns true if err, returned by a command sent to the target
+// of an ASK redirection, means that the target did not execute it.
+func askFailed(err error) bool {
+	if err == nil {
+		return false
+	}
+	if _, ok := err.(redis.Error); !ok {
+		return true
+	}
+	return ParseRedir(err) != nil || IsTryAgain(err)
+}
@@ -0,0 +1,126 @@
+package redisc
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/mna/redisc/redistest"
+	"github.com/mna/redisc/redistest/resp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClusterAskCache(t *testing.T) {
+	var src, dst *redistest.MockServer
+	var srcGets, asking, aborted int32
+
+	// src owns all slots and migrates the keys to dst
+	src = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return mockClusterSlots(src.Addr)
+		case "GET":
+			atomic.AddInt32(&srcGets, 1)
+			if atomic.LoadInt32(&aborted) == 1 {
+				return "src"
+			}
+			return resp.Error("ASK " + strconv.Itoa(Slot(args[0])) + " " + dst.Addr)
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer src.Close()
+	dst = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "ASKING":
+			atomic.StoreInt32(&asking, 1)
+			return resp.OK{}
+		case "GET":
+			if atomic.SwapInt32(&asking, 0) == 0 || atomic.LoadInt32(&aborted) == 1 {
+				return resp.Error("MOVED " + strconv.Itoa(Slot(args[0])) + " " + src.Addr)
+			}
+			return "dst"
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer dst.Close()
+
+	c := &Cluster{
+		StartupNodes:    []string{src.Addr},
+		AskCacheTTL:     100 * time.Millisecond,
+		RefreshCooldown: time.Minute,
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	get := func(key string) (string, error) {
+		conn, err := RetryConn(c.Get(), 3, time.Millisecond)
+		require.NoError(t, err, "RetryConn")
+		defer conn.Close()
+		return redis.String(conn.Do("GET", key))
+	}
+
+	v, err := get("a")
+	require.NoError(t, err, "GET a")
+	assert.Equal(t, "dst", v, "GET a redirected")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&srcGets), "redirected by src")
+
+	// the target of a is cached, not the one of b
+	v, err = get("a")
+	require.NoError(t, err, "GET a cached")
+	assert.Equal(t, "dst", v, "GET a cached")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&srcGets), "sent to dst directly")
+	_, err = get("b")
+	require.NoError(t, err, "GET b")
+	assert.Equal(t, int32(2), atomic.LoadInt32(&srcGets), "b redirected by src")
+
+	// with FollowRedirects, the connections use the cache too
+	c.FollowRedirects = true
+	conn := c.Get()
+	v, err = redis.String(conn.Do("GET", "b"))
+	conn.Close()
+	require.NoError(t, err, "GET b with FollowRedirects")
+	assert.Equal(t, "dst", v, "GET b with FollowRedirects")
+	assert.Equal(t, int32(2), atomic.LoadInt32(&srcGets), "b sent to dst directly")
+	c.FollowRedirects = false
+
+	// the cached target expires
+	time.Sleep(150 * time.Millisecond)
+	_, err = get("a")
+	require.NoError(t, err, "GET a after TTL")
+	assert.Equal(t, int32(3), atomic.LoadInt32(&srcGets), "a redirected by src after TTL")
+
+	// the cached target is forgotten when it fails
+	atomic.StoreInt32(&aborted, 1)
+	v, err = get("a")
+	require.NoError(t, err, "GET a after abort")
+	assert.Equal(t, "src", v, "GET a after abort")
+	assert.Equal(t, int32(4), atomic.LoadInt32(&srcGets), "a sent to src")
+	c.askMu.Lock()
+	assert.NotContains(t, c.asks, "a", "forgotten")
+	c.askMu.Unlock()
+}
+
+func TestClusterCmdKey(t *testing.T) {
+	c := &Cluster{}
+	cases := []struct {
+		cmd  string
+		args []interface{}
+		key  string
+		ok   bool
+	}{
+		{"GET", []interface{}{"a"}, "a", true},
+		{"PING", nil, "", false},
+		{"MGET", []interface{}{"a"}, "a", true},
+		{"MGET", []interface{}{"a", "b"}, "", false},
+		{"EVAL", []interface{}{"return 1", 1, "a"}, "a", true},
+		{"EVAL", []interface{}{"return 1", 0}, "", false},
+	}
+	for _, tc := range cases {
+		key, ok := c.cmdKey(tc.cmd, tc.args)
+		assert.Equal(t, tc.ok, ok, "%s %v", tc.cmd, tc.args)
+		assert.Equal(t, tc.key, key, "%s %v", tc.cmd, tc.args)
+	}
+}
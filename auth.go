@@ -0,0 +1,35 @@
+package redisc
+
+import (
+	"github.com/garyburd/redigo/redis"
+)
+
+// authConn authenticates conn with the cluster's Username and Password,
+// if Username is set. The connection is closed if it fails.
+func (c *Cluster) authConn(conn redis.Conn) (redis.Conn, error) {
+	if c.Username == "" {
+		return conn, nil
+	}
+	if _, err := conn.Do("AUTH", c.Username, c.Password); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// authPool makes the pool authenticate the connections it dials with the
+// cluster's Username and Password, if Username is set.
+func (c *Cluster) authPool(p *redis.Pool) {
+	if c.Username == "" {
+		return
+	}
+	if dial := p.Dial; dial != nil {
+		p.Dial = func() (redis.Conn, error) {
+			conn, err := dial()
+			if err != nil {
+				return nil, err
+			}
+			return c.authConn(conn)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package redisc
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/mna/redisc/redistest"
+	"github.com/mna/redisc/redistest/resp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClusterUsername(t *testing.T) {
+	var s *redistest.MockServer
+	var mu sync.Mutex
+	var auths [][]string
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "AUTH":
+			mu.Lock()
+			auths = append(auths, args)
+			mu.Unlock()
+			if args[1] != "pwd" {
+				return resp.Error("WRONGPASS invalid username-password pair")
+			}
+			return resp.OK{}
+		case "CLUSTER":
+			return mockClusterSlots(s.Addr)
+		case "GET":
+			return args[0]
+		case "PING":
+			return "PONG"
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	var dials int32
+	c := &Cluster{
+		StartupNodes: []string{s.Addr},
+		DialOptions: []redis.DialOption{
+			redis.DialNetDial(func(network, addr string) (net.Conn, error) {
+				atomic.AddInt32(&dials, 1)
+				return net.Dial(network, addr)
+			}),
+		},
+		CreatePool: createPool,
+		Username:   "user",
+		Password:   "pwd",
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	// a pooled connection and a dialed connection
+	conn := c.Get()
+	_, err := conn.Do("GET", "a")
+	require.NoError(t, err, "GET on pooled connection")
+	conn.Close()
+	conn, err = c.Dial()
+	require.NoError(t, err, "Dial")
+	_, err = conn.Do("GET", "a")
+	require.NoError(t, err, "GET on dialed connection")
+	conn.Close()
+
+	mu.Lock()
+	assert.Equal(t, int(atomic.LoadInt32(&dials)), len(auths), "each connection is authenticated")
+	for _, args := range auths {
+		assert.Equal(t, []string{"user", "pwd"}, args, "AUTH arguments")
+	}
+	mu.Unlock()
+
+	// authentication failures are returned
+	c2 := &Cluster{
+		StartupNodes: []string{s.Addr},
+		Username:     "user",
+		Password:     "bad",
+	}
+	defer c2.Close()
+	_, err = c2.getConnForAddr(context.Background(), s.Addr, true)
+	if assert.Error(t, err, "connection with bad password") {
+		assert.Contains(t, err.Error(), "WRONGPASS", "expected message")
+	}
+}
+
+func TestClusterACLUser(t *testing.T) {
+	fn, ports := redistest.StartClusterWithOptions(t, nil, redistest.ClusterOptions{
+		Username: "app",
+		Password: "secret",
+		Replicas: true,
+	})
+	defer fn()
+
+	for i, p := range ports {
+		ports[i] = ":" + p
+	}
+	c := &Cluster{
+		StartupNodes: ports[:redistest.NumClusterNodes],
+		CreatePool:   createPool,
+		Username:     "app",
+		Password:     "secret",
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	conn, err := RetryConn(c.Get(), 3, 0)
+	require.NoError(t, err, "RetryConn")
+	defer conn.Close()
+	for _, k := range []string{"a", "b", "c"} {
+		_, err := conn.Do("SET", k, k)
+		require.NoError(t, err, "SET %s", k)
+	}
+
+	// read from the replicas, on connections created after the refresh
+	require.NoError(t, c.Refresh(), "Refresh")
+	for _, k := range []string{"a", "b", "c"} {
+		rc := c.Get()
+		require.NoError(t, ReadOnlyConn(rc), "ReadOnly")
+		_, err := rc.Do("GET", k)
+		assert.NoError(t, err, "GET %s from replica", k)
+		rc.Close()
+	}
+}
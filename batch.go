@@ -0,0 +1,241 @@
+package redisc
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// SlotError records the failure of a command executed for a group of
+// keys that belong to the same slot.
+type SlotError struct {
+	// Slot is the hash slot of the keys.
+	Slot int
+	// Keys is the list of keys for which the command failed.
+	Keys []string
+	// Err is the error returned for that slot.
+	Err error
+}
+
+// BatchError is the error returned by the Cluster's multi-key helper
+// methods such as MGet, MSet and Del when the command failed for some of the
+// slots. The keys that are not listed in Errors were processed
+// successfully.
+type BatchError struct {
+	// Errors is the list of failures, ordered by slot.
+	Errors []SlotError
+}
+
+// Error returns the error message of a BatchError.
+func (e *BatchError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, se := range e.Errors {
+		msgs = append(msgs, fmt.Sprintf("slot %d: %v", se.Slot, se.Err))
+	}
+	return fmt.Sprintf("redisc: failed for %d slot(s): %s", len(e.Errors), strings.Join(msgs, "; "))
+}
+
+// FailedKeys returns the list of all keys for which the command failed.
+func (e *BatchError) FailedKeys() []string {
+	var keys []string
+	for _, se := range e.Errors {
+		keys = append(keys, se.Keys...)
+	}
+	return keys
+}
+
+// MGet returns the values of all specified keys, regardless of the
+// slots they belong to. The keys are grouped by slot, and an MGET
+// command is executed concurrently for each group on a connection
+// bound to that slot. The values are returned in the same order as
+// the keys.
+//
+// If the command fails for some of the slots, the values of the
+// keys in those slots are nil and a *BatchError is returned that
+// identifies the failed keys.
+func (c *Cluster) MGet(keys ...string) ([]interface{}, error) {
+	vals := make([]interface{}, len(keys))
+	err := c.runBySlot(keys, func(conn redis.Conn, ixs []int) error {
+		args := make(redis.Args, 0, len(ixs))
+		for _, ix := range ixs {
+			args = append(args, keys[ix])
+		}
+		res, err := redis.Values(conn.Do("MGET", args...))
+		if err != nil {
+			return err
+		}
+		if len(res) != len(ixs) {
+			return errors.New("redisc: unexpected number of values")
+		}
+		for i, ix := range ixs {
+			vals[ix] = res[i]
+		}
+		return nil
+	})
+	return vals, err
+}
+
+// MSet sets the specified key-value pairs, regardless of the slots the
+// keys belong to. The pairs must be provided as alternating keys and
+// values, as for the MSET command. The keys are grouped by slot, and an
+// MSET command is executed concurrently for each group on a connection
+// bound to that slot.
+//
+// If the command fails for some of the slots, a *BatchError is returned
+// that identifies the failed keys. The keys in the other slots are set.
+func (c *Cluster) MSet(pairs ...interface{}) error {
+	if len(pairs)%2 != 0 {
+		return errors.New("redisc: odd number of arguments for MSet")
+	}
+
+	// the string form of the keys is only used to compute their slot,
+	// the keys are sent as provided.
+	keys := make([]string, 0, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		keys = append(keys, keyString(pairs[i]))
+	}
+	return c.runBySlot(keys, func(conn redis.Conn, ixs []int) error {
+		args := make(redis.Args, 0, len(ixs)*2)
+		for _, ix := range ixs {
+			args = append(args, pairs[ix*2], pairs[ix*2+1])
+		}
+		_, err := conn.Do("MSET", args...)
+		return err
+	})
+}
+
+// Del deletes all specified keys, regardless of the slots they belong
+// to, and returns the number of keys that were deleted. The keys are
+// grouped by slot, and a DEL command is executed concurrently for each
+// group on a connection bound to that slot.
+//
+// If the command fails for some of the slots, the returned count only
+// includes the keys deleted in the other slots, and a *BatchError is
+// returned that identifies the failed keys, so that only those can be
+// deleted again.
+func (c *Cluster) Del(keys ...string) (int, error) {
+	return c.countBySlot("DEL", keys)
+}
+
+// Unlink is like Del, but it executes the UNLINK command, so that the
+// memory of the keys is reclaimed in the background by redis.
+func (c *Cluster) Unlink(keys ...string) (int, error) {
+	return c.countBySlot("UNLINK", keys)
+}
+
+// Exists returns the number of specified keys that exist, regardless of
+// the slots they belong to. As for the EXISTS command, a key specified
+// multiple times is counted multiple times. The keys are grouped by
+// slot, and an EXISTS command is executed concurrently for each group
+// on a connection bound to that slot.
+//
+// If the command fails for some of the slots, the returned count only
+// includes the keys of the other slots, and a *BatchError is returned
+// that identifies the failed keys.
+func (c *Cluster) Exists(keys ...string) (int, error) {
+	return c.countBySlot("EXISTS", keys)
+}
+
+// countBySlot executes cmd for each group of keys of the same slot and
+// returns the sum of the integer replies.
+func (c *Cluster) countBySlot(cmd string, keys []string) (int, error) {
+	var (
+		mu    sync.Mutex
+		total int
+	)
+	err := c.runBySlot(keys, func(conn redis.Conn, ixs []int) error {
+		args := make(redis.Args, 0, len(ixs))
+		for _, ix := range ixs {
+			args = append(args, keys[ix])
+		}
+		n, err := redis.Int(conn.Do(cmd, args...))
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		total += n
+		mu.Unlock()
+		return nil
+	})
+	return total, err
+}
+
+// runBySlot groups the keys by slot and calls fn concurrently for each
+// group, with a connection bound to that slot and the indices of the
+// keys of the group. It returns a *BatchError if fn failed for any group.
+func (c *Cluster) runBySlot(keys []string, fn func(conn redis.Conn, ixs []int) error) error {
+	var slots []int
+	bySlot := make(map[int][]int)
+	for i, k := range keys {
+		slot := c.slot(k)
+		if _, ok := bySlot[slot]; !ok {
+			slots = append(slots, slot)
+		}
+		bySlot[slot] = append(bySlot[slot], i)
+	}
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []SlotError
+	)
+	wg.Add(len(slots))
+	for _, slot := range slots {
+		go func(slot int, ixs []int) {
+			defer wg.Done()
+
+			groupKeys := make([]string, 0, len(ixs))
+			for _, ix := range ixs {
+				groupKeys = append(groupKeys, keys[ix])
+			}
+
+			conn := c.Get()
+			defer conn.Close()
+
+			err := BindConn(conn, groupKeys...)
+			if err == nil {
+				err = fn(conn, ixs)
+			}
+			if err != nil {
+				mu.Lock()
+				errs = append(errs, SlotError{Slot: slot, Keys: groupKeys, Err: err})
+				mu.Unlock()
+			}
+		}(slot, bySlot[slot])
+	}
+	wg.Wait()
+
+	if len(errs) == 0 {
+		return nil
+	}
+	sort.Slice(errs, func(i, j int) bool {
+		return errs[i].Slot < errs[j].Slot
+	})
+	return &BatchError{Errors: errs}
+}
+
+// keyString returns the string representation of a key as sent by
+// redigo, so that its slot can be computed.
+func keyString(key interface{}) string {
+	switch k := key.(type) {
+	case string:
+		return k
+	case []byte:
+		return string(k)
+	case bool:
+		if k {
+			return "1"
+		}
+		return "0"
+	case nil:
+		return ""
+	case redis.Argument:
+		return keyString(k.RedisArg())
+	default:
+		return fmt.Sprint(k)
+	}
+}
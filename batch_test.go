@@ -0,0 +1,162 @@
+package redisc
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/mna/redisc/redistest"
+	"github.com/mna/redisc/redistest/resp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClusterMGetMSet(t *testing.T) {
+	var s *redistest.MockServer
+	var mu sync.Mutex
+	var msetKeys []string
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return mockClusterSlots(s.Addr)
+		case "MGET":
+			vals := make(resp.Array, len(args))
+			for i, arg := range args {
+				if arg == "bad" {
+					return resp.Error("ERR bad key")
+				}
+				vals[i] = "v" + arg
+			}
+			return vals
+		case "MSET":
+			mu.Lock()
+			for i := 0; i < len(args); i += 2 {
+				msetKeys = append(msetKeys, args[i])
+			}
+			mu.Unlock()
+			for _, arg := range args {
+				if arg == "bad" {
+					return resp.Error("ERR bad key")
+				}
+			}
+			return resp.OK{}
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s.Addr},
+		CreatePool:   createPool,
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	vals, err := redis.Strings(c.MGet("a", "b", "{a}c", "d"))
+	if assert.NoError(t, err, "MGet") {
+		assert.Equal(t, []string{"va", "vb", "v{a}c", "vd"}, vals, "MGet values")
+	}
+
+	res, err := c.MGet("a", "bad", "{a}c", "{bad}x")
+	if assert.Error(t, err, "MGet with failure") {
+		be, ok := err.(*BatchError)
+		if assert.True(t, ok, "BatchError") {
+			assert.Equal(t, 1, len(be.Errors), "failed slots")
+			assert.Equal(t, Slot("bad"), be.Errors[0].Slot, "failed slot")
+			assert.Equal(t, []string{"bad", "{bad}x"}, be.FailedKeys(), "failed keys")
+		}
+		assert.Equal(t, []byte("va"), res[0], "MGet a")
+		assert.Nil(t, res[1], "MGet bad")
+		assert.Equal(t, []byte("v{a}c"), res[2], "MGet {a}c")
+	}
+
+	assert.NoError(t, c.MSet("a", 1, "b", 2, "{a}c", 3), "MSet")
+	if err := c.MSet("a", 1, "bad", 2); assert.Error(t, err, "MSet with failure") {
+		if be, ok := err.(*BatchError); assert.True(t, ok, "BatchError") {
+			assert.Equal(t, []string{"bad"}, be.FailedKeys(), "failed keys")
+		}
+	}
+	assert.Error(t, c.MSet("a"), "MSet with odd number of arguments")
+
+	// non-string keys are sent as-is
+	if err := c.MSet(1, "x", []byte("bad"), "y"); assert.Error(t, err, "MSet with non-string keys") {
+		if be, ok := err.(*BatchError); assert.True(t, ok, "BatchError") {
+			assert.Equal(t, []string{"bad"}, be.FailedKeys(), "failed keys")
+			assert.Equal(t, Slot("bad"), be.Errors[0].Slot, "failed slot")
+		}
+	}
+	mu.Lock()
+	assert.Contains(t, msetKeys, "1", "int key")
+	assert.NotContains(t, msetKeys, "%!s(int=1)", "int key")
+	mu.Unlock()
+}
+
+func TestClusterDelExistsUnlink(t *testing.T) {
+	var s *redistest.MockServer
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return mockClusterSlots(s.Addr)
+		case "DEL", "UNLINK", "EXISTS":
+			var n int64
+			for _, arg := range args {
+				if arg == "bad" {
+					return resp.Error("ERR bad key")
+				}
+				// only the keys starting with "x" exist
+				if arg[0] == 'x' {
+					n++
+				}
+			}
+			return n
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s.Addr},
+		CreatePool:   createPool,
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	fns := map[string]func(...string) (int, error){
+		"Del":    c.Del,
+		"Unlink": c.Unlink,
+		"Exists": c.Exists,
+	}
+	for name, fn := range fns {
+		n, err := fn("xa", "b", "xb", "{xa}c", "{xa}d")
+		if assert.NoError(t, err, name) {
+			assert.Equal(t, 2, n, "%s count", name)
+		}
+
+		n, err = fn("xa", "bad", "{bad}x", "xb")
+		if assert.Error(t, err, "%s with failure", name) {
+			if be, ok := err.(*BatchError); assert.True(t, ok, "BatchError") {
+				assert.Equal(t, []string{"bad", "{bad}x"}, be.FailedKeys(), "failed keys")
+			}
+		}
+		assert.Equal(t, 2, n, "%s partial count", name)
+	}
+}
+
+func TestKeyString(t *testing.T) {
+	cases := []struct {
+		in  interface{}
+		out string
+	}{
+		{"a", "a"},
+		{[]byte("b"), "b"},
+		{1, "1"},
+		{int64(-2), "-2"},
+		{1.5, "1.5"},
+		{true, "1"},
+		{false, "0"},
+		{nil, ""},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.out, keyString(c.in), "%#v", c.in)
+	}
+}
@@ -0,0 +1,88 @@
+package redisc
+
+import (
+	"strconv"
+	"time"
+)
+
+// blockingReadMargin is added to the timeout of a blocking command to
+// compute the read timeout of the connection, so that the client does
+// not time out before the server replies.
+const blockingReadMargin = time.Second
+
+// BlockingConn is a connection to run blocking commands such as BLPOP,
+// BRPOP, BRPOPLPUSH, BLMOVE, BZPOPMIN or BZPOPMAX, or to read streams with
+// XRead and XReadGroup. It is bound to the node that serves the slot of
+// its keys, and it holds its node connection (and keeps it out of its
+// pool) until it is closed, so that a blocked command never shares its
+// connection. It is created with Cluster.BlockingConn.
+//
+// A BlockingConn is not safe for concurrent use, and it must be closed
+// only after the blocking command returned.
+type BlockingConn struct {
+	conn *Conn
+	slot int // slot of the keys, -1 if bound without key
+}
+
+// BlockingConn returns a connection bound to the node that serves the
+// slot of the keys, which must all belong to the same slot, to run
+// blocking commands on those keys. The caller must call Close on the
+// returned connection when done.
+func (c *Cluster) BlockingConn(keys ...string) (*BlockingConn, error) {
+	conn := c.Get().(*Conn)
+	if err := conn.Bind(keys...); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	slot := -1
+	if len(keys) > 0 {
+		slot = c.slot(keys[0])
+	}
+	return &BlockingConn{conn: conn, slot: slot}, nil
+}
+
+// Do executes the blocking command cmd with the server-side timeout
+// appended as last argument (in seconds, as expected by the blocking
+// list and sorted set commands), so args must not include it. A timeout
+// of 0 blocks indefinitely. The read timeout of the connection is set to
+// that timeout plus a margin for that command, so that it does not fail
+// before the server replies, regardless of the redis.DialReadTimeout of
+// the cluster; a timeout of 0 disables the read timeout.
+//
+// When the timeout expires without a value, the server returns a nil
+// reply and Do returns nil, nil (or redis.ErrNil if used with a helper
+// such as redis.Strings).
+func (b *BlockingConn) Do(timeout time.Duration, cmd string, args ...interface{}) (interface{}, error) {
+	args = append(args[:len(args):len(args)], formatTimeout(timeout))
+	return b.conn.DoWithTimeout(blockingReadTimeout(timeout), cmd, args...)
+}
+
+// Conn returns the underlying cluster connection, e.g. to run other
+// commands on the same node.
+func (b *BlockingConn) Conn() *Conn {
+	return b.conn
+}
+
+// Close releases the node connection.
+func (b *BlockingConn) Close() error {
+	return b.conn.Close()
+}
+
+// blockingReadTimeout returns the read timeout of the connection for a
+// blocking command with the server-side timeout, 0 (no read timeout) if
+// it blocks indefinitely.
+func blockingReadTimeout(timeout time.Duration) time.Duration {
+	if timeout > 0 {
+		return timeout + blockingReadMargin
+	}
+	return 0
+}
+
+// formatTimeout formats the timeout in seconds for a blocking command,
+// using a decimal value only if the timeout is not in whole seconds.
+func formatTimeout(timeout time.Duration) string {
+	if timeout%time.Second == 0 {
+		return strconv.FormatInt(int64(timeout/time.Second), 10)
+	}
+	return strconv.FormatFloat(timeout.Seconds(), 'f', -1, 64)
+}
@@ -0,0 +1,80 @@
+package redisc
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/mna/redisc/redistest"
+	"github.com/mna/redisc/redistest/resp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClusterBlockingConn(t *testing.T) {
+	var s *redistest.MockServer
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch strings.ToUpper(cmd) {
+		case "CLUSTER":
+			return mockClusterSlots(s.Addr)
+		case "BLPOP":
+			// block for the timeout, then return a nil reply
+			secs, err := strconv.ParseFloat(args[len(args)-1], 64)
+			if err != nil {
+				return resp.Error("ERR timeout is not a float")
+			}
+			time.Sleep(time.Duration(secs * float64(time.Second)))
+			return nil
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s.Addr},
+		DialOptions:  []redis.DialOption{redis.DialReadTimeout(20 * time.Millisecond)},
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	// a regular connection times out before the server replies
+	conn := c.Get()
+	_, err := conn.Do("BLPOP", "a", "0.1")
+	assert.Error(t, err, "BLPOP with read timeout")
+	conn.Close()
+
+	bc, err := c.BlockingConn("a")
+	require.NoError(t, err, "BlockingConn")
+	defer bc.Close()
+
+	addr, ok := bc.Conn().BoundAddr()
+	assert.True(t, ok, "bound")
+	assert.Equal(t, s.Addr, addr, "bound address")
+
+	v, err := bc.Do(100*time.Millisecond, "BLPOP", "a")
+	if assert.NoError(t, err, "BLPOP") {
+		assert.Nil(t, v, "timed out")
+	}
+
+	_, err = c.BlockingConn("a", "b")
+	assert.True(t, errors.Is(err, ErrCrossSlot), "BlockingConn with different slots")
+}
+
+func TestFormatTimeout(t *testing.T) {
+	cases := []struct {
+		in   time.Duration
+		want string
+	}{
+		{0, "0"},
+		{time.Second, "1"},
+		{5 * time.Second, "5"},
+		{100 * time.Millisecond, "0.1"},
+		{1500 * time.Millisecond, "1.5"},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.want, formatTimeout(c.in), "%s", c.in)
+	}
+}
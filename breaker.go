@@ -0,0 +1,177 @@
+package redisc
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// defaultBreakerCooldown is the duration a circuit breaker stays open, if
+// the cluster's BreakerCooldown field is not set.
+const defaultBreakerCooldown = 5 * time.Second
+
+// ErrCircuitOpen is the error returned when a connection to a node is
+// requested while the circuit breaker of that node is open. See the
+// BreakerFailures field of the Cluster.
+var ErrCircuitOpen = errors.New("redisc: circuit breaker open")
+
+// BreakerState is the state of the circuit breaker of a node.
+type BreakerState int
+
+// List of circuit breaker states.
+const (
+	// BreakerClosed means that connections to the node are allowed.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen means that connections to the node fail immediately
+	// with ErrCircuitOpen.
+	BreakerOpen
+	// BreakerHalfOpen means that the cooldown of the open breaker
+	// expired, the next connection to the node is allowed as a probe.
+	BreakerHalfOpen
+)
+
+// String returns the name of the state.
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+type breaker struct {
+	failures     int       // consecutive failures
+	firstFailure time.Time // time of the first of the consecutive failures
+	openedAt     time.Time // zero if the breaker is closed
+	probing      bool      // a probe connection is in progress
+}
+
+func (c *Cluster) breakerCooldown() time.Duration {
+	if c.BreakerCooldown > 0 {
+		return c.BreakerCooldown
+	}
+	return defaultBreakerCooldown
+}
+
+// breakerAllow returns ErrCircuitOpen if the circuit breaker of the node at
+// addr is open. If its cooldown expired, a single caller is allowed to
+// connect to the node as a probe, the others still get ErrCircuitOpen
+// until the probe completes.
+func (c *Cluster) breakerAllow(addr string) error {
+	if c.BreakerFailures <= 0 {
+		return nil
+	}
+
+	c.breakerMu.Lock()
+	defer c.breakerMu.Unlock()
+
+	b := c.breakers[addr]
+	if b == nil || b.openedAt.IsZero() {
+		return nil
+	}
+	if b.probing || time.Since(b.openedAt) < c.breakerCooldown() {
+		return ErrCircuitOpen
+	}
+	b.probing = true
+	return nil
+}
+
+// breakerRecord records the result of a connection to the node at addr.
+// A success closes its circuit breaker, while enough consecutive failures
+// open it. Errors that are not caused by the node (e.g. an exhausted pool
+// or a cancelled context) are ignored.
+func (c *Cluster) breakerRecord(addr string, err error) {
+	if c.BreakerFailures <= 0 {
+		return
+	}
+
+	c.breakerMu.Lock()
+	defer c.breakerMu.Unlock()
+
+	b := c.breakers[addr]
+	if err == nil {
+		delete(c.breakers, addr)
+		return
+	}
+	if err == redis.ErrPoolExhausted || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		if b != nil {
+			// allow another probe
+			b.probing = false
+		}
+		return
+	}
+
+	now := time.Now()
+	if b == nil {
+		if c.breakers == nil {
+			c.breakers = make(map[string]*breaker)
+		}
+		b = &breaker{}
+		c.breakers[addr] = b
+	}
+	if b.probing {
+		// the probe failed, the breaker stays open for another cooldown
+		b.probing = false
+		b.openedAt = now
+		return
+	}
+	if !b.openedAt.IsZero() {
+		return
+	}
+
+	if c.BreakerWindow > 0 && b.failures > 0 && now.Sub(b.firstFailure) > c.BreakerWindow {
+		b.failures = 0
+	}
+	if b.failures == 0 {
+		b.firstFailure = now
+	}
+	b.failures++
+	if b.failures >= c.BreakerFailures {
+		b.openedAt = now
+		c.logf("redisc: circuit breaker open for %s after %d failures: %v", addr, b.failures, err)
+	}
+}
+
+// breakerOpen returns true if the circuit breaker of the node at addr is
+// open and a connection would fail immediately.
+func (c *Cluster) breakerOpen(addr string) bool {
+	if c.BreakerFailures <= 0 {
+		return false
+	}
+
+	c.breakerMu.Lock()
+	defer c.breakerMu.Unlock()
+
+	b := c.breakers[addr]
+	return b != nil && !b.openedAt.IsZero() && (b.probing || time.Since(b.openedAt) < c.breakerCooldown())
+}
+
+// NodeBreakerStates returns the state of the circuit breaker of the nodes
+// that had connection failures since their last successful connection.
+// Keys are the nodes' addresses. It returns an empty map if the circuit
+// breakers are disabled (see the BreakerFailures field).
+func (c *Cluster) NodeBreakerStates() map[string]BreakerState {
+	c.breakerMu.Lock()
+	defer c.breakerMu.Unlock()
+
+	cooldown := c.breakerCooldown()
+	states := make(map[string]BreakerState, len(c.breakers))
+	for addr, b := range c.breakers {
+		switch {
+		case b.openedAt.IsZero():
+			states[addr] = BreakerClosed
+		case time.Since(b.openedAt) < cooldown:
+			states[addr] = BreakerOpen
+		default:
+			states[addr] = BreakerHalfOpen
+		}
+	}
+	return states
+}
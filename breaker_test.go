@@ -0,0 +1,105 @@
+package redisc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/mna/redisc/redistest"
+	"github.com/mna/redisc/redistest/resp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClusterBreaker(t *testing.T) {
+	s := redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "GET":
+			return args[0]
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	var fail, dials int32
+	c := &Cluster{
+		DialOptions: []redis.DialOption{
+			redis.DialNetDial(func(network, addr string) (net.Conn, error) {
+				atomic.AddInt32(&dials, 1)
+				if atomic.LoadInt32(&fail) == 1 {
+					return nil, errors.New("dial failure")
+				}
+				return net.Dial(network, addr)
+			}),
+		},
+		BreakerFailures: 2,
+		BreakerCooldown: 50 * time.Millisecond,
+	}
+	defer c.Close()
+
+	getConn := func() error {
+		conn, err := c.getConnForAddr(context.Background(), s.Addr, false)
+		if err == nil {
+			conn.Close()
+		}
+		return err
+	}
+
+	require.NoError(t, getConn(), "success")
+	assert.Empty(t, c.NodeBreakerStates(), "no failure")
+
+	atomic.StoreInt32(&fail, 1)
+	assert.Error(t, getConn(), "first failure")
+	assert.Equal(t, map[string]BreakerState{s.Addr: BreakerClosed}, c.NodeBreakerStates(), "closed after first failure")
+	assert.Error(t, getConn(), "second failure")
+	assert.Equal(t, map[string]BreakerState{s.Addr: BreakerOpen}, c.NodeBreakerStates(), "open after second failure")
+
+	// fails fast while open
+	atomic.StoreInt32(&dials, 0)
+	assert.Equal(t, ErrCircuitOpen, getConn(), "open")
+	assert.Equal(t, int32(0), atomic.LoadInt32(&dials), "no dial while open")
+
+	// the probe fails, the breaker stays open
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, map[string]BreakerState{s.Addr: BreakerHalfOpen}, c.NodeBreakerStates(), "half-open after cooldown")
+	assert.NotEqual(t, ErrCircuitOpen, getConn(), "failed probe")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&dials), "probe dialed")
+	assert.Equal(t, ErrCircuitOpen, getConn(), "open after failed probe")
+
+	// the probe succeeds, the breaker closes
+	time.Sleep(60 * time.Millisecond)
+	atomic.StoreInt32(&fail, 0)
+	require.NoError(t, getConn(), "successful probe")
+	assert.Empty(t, c.NodeBreakerStates(), "closed after successful probe")
+}
+
+func TestClusterBreakerReplica(t *testing.T) {
+	s := redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "READONLY":
+			return resp.OK{}
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	master, bad, good := "127.0.0.1"+s.Addr, "127.0.0.1:1", "localhost"+s.Addr
+	c := &Cluster{
+		BreakerFailures: 1,
+		ReplicaSelector: func(replicas []string) string { return bad },
+	}
+	defer c.Close()
+	c.mapping[0] = []string{master, bad, good}
+
+	c.breakerRecord(bad, errors.New("failure"))
+	require.Equal(t, BreakerOpen, c.NodeBreakerStates()[bad], "replica breaker open")
+
+	conn, addr, err := c.getConnForSlot(context.Background(), 0, false, true)
+	require.NoError(t, err, "getConnForSlot")
+	defer conn.Close()
+	assert.Equal(t, good, addr, "other replica selected")
+}
@@ -1,9 +1,15 @@
 package redisc
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
+	"fmt"
 	"math/rand"
+	"net"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -12,6 +18,17 @@ import (
 
 const hashSlots = 16384
 
+// ErrClusterNotReady is the error returned by Refresh when the nodes
+// report that no slot is assigned, e.g. while the cluster is being
+// created. The mapping is left unchanged, Refresh can be called again
+// once the slots are assigned.
+var ErrClusterNotReady = errors.New("redisc: cluster not ready, no slot assigned")
+
+// ErrNoConnection is the error returned when no connection could be
+// obtained to any of the known nodes of the cluster, e.g. because they
+// are all unreachable.
+var ErrNoConnection = errors.New("redisc: failed to get a connection")
+
 // Cluster manages a redis cluster. If the CreatePool field is not nil,
 // a redis.Pool is used for each node in the cluster to get connections
 // via Get. If it is nil or if Dial is called, redis.Dial
@@ -20,112 +37,852 @@ type Cluster struct {
 	// StartupNodes is the list of initial nodes that make up
 	// the cluster. The values are expected as "address:port"
 	// (e.g.: "127.0.0.1:6379"). Only master nodes should be
-	// specified.
+	// specified. Duplicate addresses are used only once, including
+	// different spellings of the same IP address.
 	StartupNodes []string
 
+	// TopologyProvider, if not nil, is the source of the mapping of slots
+	// to nodes used by the refreshes of the mapping, instead of the CLUSTER
+	// SHARDS or CLUSTER SLOTS commands sent to the nodes. The StartupNodes
+	// are then not needed. The RefreshQuorum, FetchConfigEpochs and
+	// RejectStaleMapping fields are ignored, as the provider is trusted.
+	TopologyProvider TopologyProvider
+
+	// AddressRewriter, if not nil, is called to get the address to use to
+	// connect to a node, given the address announced by the cluster (in
+	// the replies to CLUSTER SLOTS and CLUSTER SHARDS and in MOVED and ASK
+	// redirections), e.g. to map the internal addresses of the nodes in a
+	// Docker or Kubernetes network to addresses reachable by the client.
+	// The rewritten addresses are the ones used in the mapping and in all
+	// the methods that return node addresses, such as Nodes or Stats. The
+	// StartupNodes are used as-is. The RedirError values returned to the
+	// caller hold the address announced by the cluster. It may be called
+	// concurrently.
+	AddressRewriter func(addr string) string
+
 	// DialOptions is the list of options to set on each new connection.
 	DialOptions []redis.DialOption
 
+	// ReadTimeout, if not 0, is the default read timeout of the new
+	// connections, i.e. the maximum time to wait for the reply of a
+	// command once it is sent. It is applied with the
+	// redis.DialReadTimeout option, before the DialOptions, so a
+	// redis.DialReadTimeout option takes precedence. It can be overridden
+	// for a single command with Conn.DoWithTimeout (or redis.DoWithTimeout),
+	// or bounded by the context with Conn.DoContext.
+	ReadTimeout time.Duration
+
+	// WriteTimeout, if not 0, is the default write timeout of the new
+	// connections, applied with the redis.DialWriteTimeout option before
+	// the DialOptions, as for ReadTimeout.
+	WriteTimeout time.Duration
+
+	// Username, if set, is the name of the ACL user (redis 6+) used to
+	// authenticate each new connection to the nodes, with the AUTH
+	// command and the Password field. It applies to the connections
+	// dialed by the cluster and by the pools returned by CreatePool (their
+	// Dial function is wrapped when the pool is created). The redigo
+	// package doesn't support ACL users in its dial options, so this must
+	// be used instead of the redis.DialPassword option for those users.
+	Username string
+
+	// Password is the password of the ACL user set in Username. It is
+	// ignored if Username is not set.
+	Password string
+
+	// NodeDialOptions, if not nil, is called to get additional options to
+	// set on new connections to the node at the specified address. Those
+	// options are applied after the DialOptions, so they take precedence
+	// (e.g. to use a different password or connect timeout for some
+	// nodes). It may be called concurrently.
+	NodeDialOptions func(address string) []redis.DialOption
+
+	// HostnameTLSConfig, if not nil, is the TLS configuration to use to
+	// connect to the nodes that announce a hostname (the
+	// cluster-announce-hostname configuration of redis 7+). The connection
+	// is still made to the node's address, but a copy of this configuration
+	// is used with its ServerName set to the announced hostname, so that
+	// the certificate is validated against that hostname (and sent as SNI).
+	// TLS must still be enabled with the redis.DialUseTLS option. Because
+	// the hostnames are learned by Refresh, the startup nodes should be
+	// specified by hostname.
+	HostnameTLSConfig *tls.Config
+
 	// CreatePool is the function to call to create a redis.Pool for
-	// the specified TCP address, using the provided options
-	// as set in DialOptions. If this field is not nil, a
-	// redis.Pool is created for each node in the cluster and the
-	// pool is used to manage the connections returned by Get.
+	// the specified TCP address, using the provided options as set
+	// in DialOptions (and NodeDialOptions for that address). If this
+	// field is not nil, a redis.Pool is created for each node in the
+	// cluster and the pool is used to manage the connections returned
+	// by Get.
+	//
+	// The Dial function of the pool may return a redis.Conn that wraps
+	// the connection returned by redis.Dial, e.g. to log the commands or
+	// to implement a circuit breaker. The connections returned by Get only
+	// rely on the methods of the redis.Conn interface of the pooled
+	// connections, so such interceptors are called for all commands,
+	// including the READONLY and READWRITE commands sent for read-only
+	// connections. The connections used to refresh the mapping are not
+	// taken from the pools, they are not intercepted.
 	CreatePool func(address string, options ...redis.DialOption) (*redis.Pool, error)
 
-	mu         sync.RWMutex           // protects following fields
-	err        error                  // broken connection error
-	pools      map[string]*redis.Pool // created pools per node
-	masters    map[string]bool        // set of known active master nodes, kept up-to-date
-	replicas   map[string]bool        // set of known active replica nodes, kept up-to-date
-	mapping    [hashSlots][]string    // hash slot number to master and replica(s) server addresses, master is always at [0]
-	refreshing bool                   // indicates if there's a refresh in progress
+	// CreatePoolContext is like CreatePool, but it also receives the
+	// context of the call that causes the pool to be created, e.g. the
+	// context passed to RefreshContext (see NodeErrors), GetContext or
+	// DoContext (or context.Background() if there is none), so that the
+	// creation of the pool can be traced or cancelled. If it is not nil,
+	// it is used instead of CreatePool. Because the pool outlives that
+	// call, the context should not be used by the pool to dial its
+	// connections unless its lifetime is that of the cluster.
+	CreatePoolContext func(ctx context.Context, address string, options ...redis.DialOption) (*redis.Pool, error)
+
+	// MaxConnLifetime, if not 0, is the maximum duration a pooled
+	// connection to a node is reused: older connections are closed when
+	// they are returned to the pool (or when they are taken from it), so
+	// that connections are rotated regularly, e.g. to release server-side
+	// state or to move away from a stale network path. It replaces the
+	// MaxConnLifetime of the pools returned by CreatePool (or
+	// CreatePoolContext). See DefaultCreatePool for a CreatePool function
+	// that sets a default lifetime.
+	MaxConnLifetime time.Duration
+
+	// Logger, if not nil, is used to log the cluster's internal events,
+	// such as the refreshes of the mapping, the redirections and the
+	// creation of pools. A *log.Logger can be used. If it is nil, nothing
+	// is logged.
+	Logger Logger
+
+	// WarnCrossSlot makes the connections log a warning (with the Logger)
+	// when a known multi-key command such as MGET, MSET or DEL is executed
+	// with keys that don't belong to the same slot, e.g. because of missing
+	// hash tags, before the server rejects it with a CROSSSLOT error. The
+	// command is still sent to the server. It is meant for debugging, as it
+	// computes the slot of all keys of those commands.
+	WarnCrossSlot bool
+
+	// GuardSelect makes the connections handle the SELECT command without
+	// sending it to a node, for code written for a single redis server: a
+	// SELECT of the database 0 (the only database of a cluster) does
+	// nothing and returns "OK", and a SELECT of another database fails
+	// with ErrSelect. This applies to the Do methods of the connections
+	// (including the connections returned by RetryConn), the commands
+	// pipelined with Send are sent to the node.
+	GuardSelect bool
+
+	// VerifySlotsRate is the fraction (between 0 and 1) of the commands
+	// executed with Do for which the slot computed for the key is verified
+	// against the slot returned by the server for that key with CLUSTER
+	// KEYSLOT, e.g. to detect a KeyToSlot function that doesn't match the
+	// server's algorithm. On mismatch, it is logged and Do returns an error
+	// instead of the command's reply (the command was executed). It is
+	// meant for tests, as it adds a round-trip to the verified commands.
+	// If it is 0, no command is verified.
+	VerifySlotsRate float64
+
+	// Observer, if not nil, is notified of the commands executed on the
+	// cluster and of their redirections and retries, e.g. to record
+	// metrics. See the Observer interface for details. If it also
+	// implements PoolWaitObserver, it is notified of the time spent to get
+	// the pooled connections.
+	Observer Observer
+
+	// KeyToSlot, if not nil, is the function called to compute the hash slot
+	// of a key, for all the routing decisions of the cluster and its
+	// connections (e.g. Bind, Do, MGet). It must return a value between 0
+	// and 16383, other values are handled as if the command had no key (a
+	// random node is used). It is meant for redis-cluster-compatible
+	// proxies that use a different hash algorithm: if it doesn't match the
+	// algorithm of the servers, commands are sent to the wrong nodes and
+	// fail with MOVED errors (or, with a RetryConn, are redirected on each
+	// call). If it is nil, the standard algorithm of the Slot function is
+	// used. The package-level Slot, SlotEqual and SplitBySlot functions
+	// always use the standard algorithm.
+	KeyToSlot func(key []byte) int
+
+	// CommandKeys, if not nil, adds to (or overrides) the built-in table
+	// of the commands whose keys are not only their first argument (e.g.
+	// EVAL, XREAD, ZUNIONSTORE or MSET), e.g. for the commands of a redis
+	// module. It maps the upper-case name of a command to the function
+	// that returns its keys, given its arguments. The first key
+	// determines the slot used to route the command, and all its keys
+	// are checked by WarnCrossSlot. The commands that are in neither
+	// table are routed using their first argument as key.
+	CommandKeys map[string]KeysFunc
+
+	// UseServerKeyExtraction makes the cluster ask the nodes which
+	// arguments of a command are keys, instead of using the built-in table
+	// of command keys, so that the commands of redis modules and of newer
+	// redis versions are routed correctly. The key positions of a command
+	// are queried with COMMAND INFO the first time the command is used,
+	// and cached. For the commands whose key positions depend on the value
+	// of their arguments (e.g. EVAL or XREAD), the built-in table is used
+	// if the command is in it, otherwise the keys are queried with COMMAND
+	// GETKEYS on each call. The CommandKeys field still takes precedence.
+	// If a query fails, the command is routed as if this was false.
+	UseServerKeyExtraction bool
+
+	// Protocol is the RESP protocol version to negotiate with each node
+	// using the HELLO command. If it is 0, no HELLO command is sent. The
+	// redigo package only supports the RESP2 protocol, so the only other
+	// valid value is 2; connections fail with an error for any other
+	// value. The server metadata returned by HELLO is available via the
+	// NodeHello method. Nodes that don't support the HELLO command (before
+	// redis 6) are used as-is, with the RESP2 protocol.
+	Protocol int
+
+	// ReplicaSelector is the function called to select the replica to use
+	// for a read-only connection when the master of its slot has more
+	// than one replica. It receives the addresses of the replicas and
+	// must return one of them. If it is nil, a random replica is selected.
+	// It may be called concurrently.
+	ReplicaSelector func(replicas []string) string
+
+	// LatencyAwareReplicas makes read-only connections prefer the replica
+	// with the lowest latency when the master of their slot has more than
+	// one replica, unless ReplicaSelector is set. The latency of each node
+	// is measured on the commands executed with Do, as an exponentially
+	// weighted moving average, and is available via the NodeLatencies
+	// method. A replica that has no recent measure (e.g. because it was
+	// slower and was not selected recently) is selected again from time to
+	// time, so that its latency is measured again.
+	LatencyAwareReplicas bool
+
+	// MaxReplicaLag, if not 0, is the maximum replication lag of a replica
+	// used by a read-only connection, in bytes of the replication stream
+	// (the difference between the replication offset of the master and
+	// the one of the replica). The lag of the replicas is read from the
+	// INFO replication command of their master, and is cached for a
+	// second. If the selected replica lags behind by more than that (or if
+	// its lag is unknown), another replica of the slot is used, or the
+	// master if all its replicas lag behind.
+	MaxReplicaLag int64
+
+	// RouteReadsToReplicas makes Do execute the read commands (see
+	// IsReadCommand) on a read-only connection, so that they are served by
+	// a replica of the command's slot if it has any, while the other
+	// commands are still executed on the master. Because replication is
+	// asynchronous, a read may not see the result of a write made just
+	// before. Connections returned by Get and Dial are not affected, call
+	// ReadOnly on them instead.
+	RouteReadsToReplicas bool
+
+	// IsReadCommand, if not nil, is the function called to decide if a
+	// command is a read command when RouteReadsToReplicas is set. It
+	// receives the command name as passed to Do. If it is nil, a default
+	// list of common read commands (e.g. GET, MGET, HGETALL, SMEMBERS,
+	// ZRANGE) is used.
+	IsReadCommand func(cmd string) bool
+
+	// OnRefresh, if not nil, is called after a successful refresh of the
+	// mapping of slots to nodes, if the mapping changed. It receives a
+	// snapshot of the mapping as of the previous call (an empty mapping
+	// on the first call) and of the refreshed mapping; the ChangedSlots
+	// method returns the slots that moved. Slots updated in between by
+	// MOVED replies are not reflected in the old mapping. Calls are
+	// serialized and made in the order of the refreshes, a refresh that
+	// completes after a more recent one is not reported. It is called
+	// synchronously by the goroutine that refreshed the mapping, so it
+	// should return quickly.
+	OnRefresh func(old, new *Mapping)
+
+	// DisableAutoRefreshOnMoved disables the update of the mapping when a
+	// command returns a MOVED redirection, so that the mapping is only
+	// updated by calls to Refresh (and by the other automatic refreshes,
+	// e.g. by the health-checker). The MOVED errors are returned unchanged
+	// to the caller, including by connections returned by RetryConn,
+	// which don't follow them. It is meant for tools that need to observe
+	// the redirections, e.g. to debug a migration of slots.
+	DisableAutoRefreshOnMoved bool
+
+	// RefreshCooldown is the minimum interval between two refreshes of the
+	// mapping triggered automatically (e.g. by MOVED replies). If such a
+	// refresh is requested within that interval after the last successful
+	// refresh, it is delayed until the end of the interval, and all
+	// requests received in the meantime are coalesced into that single
+	// refresh. Calls to Refresh are not affected. If it is 0, there is
+	// no minimum interval.
+	RefreshCooldown time.Duration
+
+	// RefreshJitter is the maximum random delay added before a refresh of
+	// the mapping triggered automatically (e.g. by MOVED replies or by the
+	// health-checker), after the RefreshCooldown delay if any. When many
+	// clients see the same cluster event, this spreads their refreshes
+	// over that window instead of sending all the requests for the mapping
+	// at the same time. Requests received during the delay are coalesced
+	// into that single refresh. Calls to Refresh are not affected. If it
+	// is 0, there is no random delay.
+	RefreshJitter time.Duration
+
+	// RefreshTimeout is the maximum time to wait for a node's reply to the
+	// command that returns its mapping of slots, when the mapping is
+	// refreshed. If it is 0, a default of 10 seconds is used.
+	RefreshTimeout time.Duration
+
+	// RefreshQuorum is the number of nodes queried for their mapping of
+	// slots when the mapping is refreshed. If it is greater than 1, the
+	// mapping is adopted only if a majority of those nodes return the
+	// same mapping, otherwise the refresh fails and the current mapping
+	// is kept. This avoids adopting a stale mapping returned by a single
+	// node during a failover, at the cost of additional round-trips on
+	// every refresh. Nodes that fail to reply are replaced by other nodes
+	// of the cluster, if any. If it is 0 or 1, the first mapping returned
+	// by a node is adopted.
+	RefreshQuorum int
+
+	// OrderedRefresh makes the refreshes of the mapping query the nodes in
+	// a deterministic order: the startup nodes in the order of the
+	// StartupNodes field, then the other masters and the replicas, ordered
+	// by address. Otherwise, the masters (then the replicas) are queried
+	// in a random order on each refresh, so that the load of the refreshes
+	// of many clients that share the same startup nodes is spread over
+	// the nodes. It is meant for tests.
+	OrderedRefresh bool
+
+	// FetchConfigEpochs makes each refresh of the mapping also run the
+	// CLUSTER NODES command on the node that returned the mapping, to read
+	// the config epoch of the nodes, as CLUSTER SLOTS and CLUSTER SHARDS
+	// don't report it. The config epochs are reported by the Nodes method.
+	// If it is false, the config epochs are not known.
+	FetchConfigEpochs bool
+
+	// RejectStaleMapping makes a refresh fail with ErrStaleMapping, leaving
+	// the current mapping unchanged, if the refreshed mapping assigns a
+	// slot to a master with a lower config epoch than the master currently
+	// known for that slot, e.g. because a node that did not see a failover
+	// yet replied to the refresh. It implies FetchConfigEpochs.
+	RejectStaleMapping bool
+
+	// HealthCheckInterval is the interval at which a background goroutine
+	// checks the health of each known node with a PING command. If it is
+	// 0, health checks are disabled. The goroutine is started by the first
+	// call to Refresh and stopped when the cluster is closed.
+	HealthCheckInterval time.Duration
+
+	// HealthCheckFailures is the number of consecutive failed health checks
+	// after which a node is marked unavailable and a refresh of the mapping
+	// is triggered, so that a failover is picked up without waiting for a
+	// MOVED reply. Nodes marked unavailable are tried last when a random
+	// node is needed. If it is 0, a default of 3 is used.
+	HealthCheckFailures int
+
+	// BreakerFailures is the number of consecutive failures to connect to a
+	// node (within BreakerWindow) after which the circuit breaker of that
+	// node opens: connections to that node then fail immediately with
+	// ErrCircuitOpen instead of waiting for the connect timeout, and
+	// read-only connections use another replica of their slot, if one is
+	// available. After BreakerCooldown, the breaker is half-open and the
+	// next connection to the node is made as a probe: the breaker closes
+	// if it succeeds, otherwise it stays open for another cooldown. The
+	// state of the breakers is available via the NodeBreakerStates
+	// method. If it is 0, the circuit breakers are disabled.
+	BreakerFailures int
+
+	// BreakerWindow is the maximum duration between the first and the
+	// last of the consecutive failures that open a circuit breaker. If it
+	// is 0, the failures are counted regardless of their time.
+	BreakerWindow time.Duration
+
+	// BreakerCooldown is the duration a circuit breaker stays open before
+	// a probe connection is allowed. If it is 0, a default of 5 seconds is
+	// used.
+	BreakerCooldown time.Duration
+
+	// MaxAttempts is the maximum number of attempts made by Do to execute
+	// a command. If it is greater than 0, Do wraps its connection in a
+	// RetryConn with that maximum number of attempts and the TryAgainDelay
+	// delay, so that redirections are followed automatically. Otherwise,
+	// MOVED and ASK errors are returned to the caller.
+	MaxAttempts int
+
+	// TryAgainDelay is the duration to wait before retrying a command
+	// that failed with a TRYAGAIN error in Do, if MaxAttempts is set.
+	TryAgainDelay time.Duration
+
+	// RetryBudgetRate, if greater than 0, is the number of retries per
+	// second allowed for all the RetryConn connections of the cluster
+	// (including those used by Do when MaxAttempts is set), so that
+	// retries don't amplify the load of the cluster during an incident.
+	// The retries of TRYAGAIN, LOADING, MASTERDOWN, CLUSTERDOWN and
+	// connection errors take a token from a bucket filled at that rate,
+	// and when the bucket is empty the error is returned to the caller
+	// instead of being retried. Redirections are not limited. If it is 0,
+	// retries are not limited.
+	RetryBudgetRate float64
+
+	// RetryBudgetBurst is the size of the bucket of the retry budget, i.e.
+	// the number of retries allowed at once when no retry was made
+	// recently. It is only used if RetryBudgetRate is set. If it is lower
+	// than 1, a size of 1 is used.
+	RetryBudgetBurst int
+
+	// FollowRedirects makes the connections returned by Get and Dial
+	// follow a single MOVED or ASK redirection in Do and DoContext,
+	// without the need to wrap them in a RetryConn. A MOVED redirection
+	// binds the connection to the new node of the slot and executes the
+	// command again on that node, an ASK redirection executes it on the
+	// importing node preceded by ASKING, as done by RetryConn. If the
+	// command is redirected again, that error is returned to the caller.
+	// If it is false, MOVED and ASK errors are returned to the caller.
+	FollowRedirects bool
+
+	// AskCacheTTL, if not 0, is the duration during which the target of an
+	// ASK redirection is remembered for the key of the redirected command,
+	// so that the next commands on that key are sent directly to the
+	// importing node preceded by ASKING, without the round-trip to the
+	// migrating node. This applies to the redirections followed by the
+	// connections returned by RetryConn (except in pipelines) and by the
+	// connections of a cluster with FollowRedirects set. The target is
+	// remembered per key, not per slot, as the keys of a migrating slot
+	// that are not migrated yet must still be sent to the migrating node.
+	// Only the commands with a single key use it. A target is forgotten
+	// when it fails to execute a command (e.g. with a MOVED redirection
+	// once the migration is complete), or after the TTL, which should be
+	// short (e.g. a few seconds).
+	AskCacheTTL time.Duration
+
+	// FailFastOnEmptyMapping makes Get and Dial fail immediately if the
+	// mapping of slots to nodes was never successfully refreshed and it
+	// cannot be refreshed (e.g. because no startup node is reachable),
+	// instead of returning a connection that fails on first use. In that
+	// case, they first try to refresh the mapping, then Dial returns the
+	// error and Get returns a connection that returns it for all calls.
+	FailFastOnEmptyMapping bool
+
+	// DryRun, if set, makes the cluster route the commands as usual but
+	// call DryRun with each command and the address of the node it is
+	// routed to, instead of connecting to that node. The reply returned by
+	// DryRun is returned to the caller of Do (or Receive). This includes
+	// the commands sent by the package itself, such as READONLY or the
+	// commands used to refresh the mapping, so DryRun can simulate a
+	// cluster's topology by replying to CLUSTER SLOTS. It is meant to
+	// validate the routing of the commands (e.g. a hash tag strategy)
+	// without a live cluster.
+	DryRun func(cmd DryRunCommand) (interface{}, error)
+
+	// OrphanPoolTTL is the duration after which the pool of a node that
+	// doesn't serve any slot as of the last refresh of the mapping (e.g. a
+	// node removed from the cluster by a reshard, or a startup node known
+	// under a different address) is closed, so that its idle connections
+	// are released. The pool is kept if the node serves slots again within
+	// that period. If it is 0, such pools are closed as soon as the mapping
+	// is refreshed.
+	OrphanPoolTTL time.Duration
+
+	// ResetOnReturn makes the connections send the RESET command (redis
+	// 6.2+) on their pooled node connection when it is released (e.g. when
+	// the connection is closed), so that it is returned to its pool in a
+	// clean state even if it was left in a transaction, subscribed to
+	// channels or in read-only mode. The RESET command also
+	// de-authenticates the connection, so it is authenticated again with
+	// the Username and Password fields if Username is set; connections
+	// authenticated with the redis.DialPassword option should not use it.
+	// Nodes that don't support RESET are detected on the first failure,
+	// the READWRITE command is then sent as usual for read-only
+	// connections. It is only used if CreatePool (or CreatePoolContext) is
+	// set.
+	ResetOnReturn bool
+
+	// WarmupPools is the number of connections to create in the background
+	// in the pool of each node discovered by a refresh of the mapping, so
+	// that the first requests to a new node (e.g. after a failover) don't
+	// pay the cost of dialing. It is only used if CreatePool (or
+	// CreatePoolContext) is set, and it is limited by the pool's MaxIdle
+	// and MaxActive fields. If it is 0, the pools are not warmed up.
+	WarmupPools int
+
+	mu          sync.RWMutex                      // protects following fields
+	err         error                             // broken connection error
+	pools       map[string]*redis.Pool            // created pools per node
+	hellos      map[string]map[string]interface{} // HELLO metadata per node
+	masters     map[string]bool                   // set of known active master nodes, kept up-to-date
+	replicas    map[string]bool                   // set of known active replica nodes, kept up-to-date
+	mapping     Mapping                           // hash slot number to master and replica(s) server addresses, master is always at [0]
+	refreshing  *refreshCall                      // refresh in progress, if any
+	lastRefresh time.Time                         // time of the last successful refresh
+	down        map[string]bool                   // nodes marked unavailable by the health-checker
+	healthStop  chan struct{}                     // closed to stop the health-checker
+	noShards    map[string]bool                   // nodes that don't support CLUSTER SHARDS
+	noReset     map[string]bool                   // nodes that don't support RESET
+	poolErrs    map[string]error                  // errors of the last pool creation per node
+	nodeInfos   map[string]nodeInfo               // node IDs, hostnames and health, as of the last refresh
+	refreshSeq  uint64                            // incremented on each successful refresh
+	orphans     map[string]time.Time              // pools of nodes that don't serve any slot, with the time they were orphaned
+	reapTimer   *time.Timer                       // closes the orphaned pools when their OrphanPoolTTL expires
+
+	keySpecsMu sync.Mutex          // protects following field
+	keySpecs   map[string]*keySpec // key positions per command, see UseServerKeyExtraction
+
+	latencyMu sync.Mutex             // protects following field
+	latencies map[string]nodeLatency // measured latency per node
+
+	budgetMu     sync.Mutex // protects following fields
+	budgetTokens float64    // available retries, see RetryBudgetRate
+	budgetTime   time.Time  // last time the budget was updated
+
+	lagMu sync.Mutex            // protects following field
+	lags  map[string]masterLags // replication lag of the replicas per master, see MaxReplicaLag
+
+	askMu sync.Mutex           // protects following field
+	asks  map[string]askTarget // cached ASK targets per key, see AskCacheTTL
+
+	breakerMu sync.Mutex          // protects following field
+	breakers  map[string]*breaker // circuit breaker per node, for nodes with failures
+
+	onRefreshMu sync.Mutex // serializes calls to OnRefresh, protects following fields
+	reported    Mapping    // mapping passed as new in the last call to OnRefresh
+	reportedSeq uint64     // refreshSeq of the reported mapping
 }
 
 // Refresh updates the cluster's internal mapping of hash slots
-// to redis node. It calls CLUSTER SLOTS on each known node until one
-// of them succeeds.
+// to redis node. It calls CLUSTER SHARDS (or CLUSTER SLOTS on nodes
+// older than redis 7) on the known nodes, a few of them concurrently
+// and masters first, in a random order (see OrderedRefresh), and uses
+// the first successful reply. A node that reports no slot is
+// ignored, and if no node reports any slot (e.g. while the cluster is
+// being created), it returns ErrClusterNotReady.
+//
+// If a refresh is already in progress (e.g. started by another call to
+// Refresh or automatically after a MOVED response), it waits for that
+// refresh to complete and returns its error instead of starting a new
+// one, so that concurrent calls share the same result.
 //
 // It should typically be called after creating the Cluster and before
 // using it. The cluster automatically keeps its mapping up-to-date
 // afterwards, based on the redis commands' MOVED responses.
 func (c *Cluster) Refresh() error {
+	return c.RefreshContext(context.Background())
+}
+
+// RefreshContext is like Refresh, but it returns ctx.Err() as soon as the
+// context is done, without waiting for the nodes' replies. The connections
+// to the nodes are dialed with the context, and those still waiting for a
+// reply are closed when it is done. The mapping is left unchanged if the
+// refresh did not complete. If it waits for a refresh already in
+// progress, that refresh uses the context of the call that started it,
+// so it may fail with that context's error, and it continues when ctx is
+// done.
+func (c *Cluster) RefreshContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	c.mu.Lock()
-	err := c.err
-	if err == nil {
-		c.refreshing = true
+	if err := c.err; err != nil {
+		c.mu.Unlock()
+		return err
+	}
+	c.startHealthCheckLocked()
+	call := c.refreshing
+	if call == nil {
+		call = c.startRefreshLocked()
+		c.mu.Unlock()
+		return c.runRefresh(ctx, call)
 	}
 	c.mu.Unlock()
-	if err != nil {
-		return err
+
+	// a refresh is already in progress, wait for its result
+	select {
+	case <-call.done:
+		return call.err
+	case <-ctx.Done():
+		return ctx.Err()
 	}
+}
 
-	return c.refresh()
+// refreshCall is a refresh in progress, shared by all the callers of
+// Refresh until it completes.
+type refreshCall struct {
+	done chan struct{} // closed when the refresh is done
+	err  error         // error of the refresh, set before done is closed
 }
 
-func (c *Cluster) refresh() error {
-	addrs := c.getNodeAddrs(false)
-	for _, addr := range addrs {
-		m, err := c.getClusterSlots(addr)
-		if err == nil {
-			// succeeded, save as mapping
-			c.mu.Lock()
-			// mark all current nodes as false
-			for k := range c.masters {
-				c.masters[k] = false
+// startRefreshLocked marks that a refresh is in progress and returns it.
+// The caller must hold the lock and must call runRefresh with the
+// returned call.
+func (c *Cluster) startRefreshLocked() *refreshCall {
+	call := &refreshCall{done: make(chan struct{})}
+	c.refreshing = call
+	return call
+}
+
+// runRefresh executes the refresh of call and reports its result to the
+// callers waiting for it.
+func (c *Cluster) runRefresh(ctx context.Context, call *refreshCall) error {
+	call.err = c.refresh(ctx)
+	close(call.done)
+	return call.err
+}
+
+func (c *Cluster) refresh(ctx context.Context) error {
+	var m []slotMapping
+	var err error
+	if c.TopologyProvider != nil {
+		m, err = c.getProviderSlots(ctx)
+	} else {
+		// try the masters and the replicas, the replicas may know about a
+		// failover if the masters are unreachable.
+		addrs := c.availableFirst(c.refreshAddrs())
+		if c.RefreshQuorum > 1 {
+			m, err = c.getQuorumClusterSlots(ctx, addrs, c.RefreshQuorum)
+		} else {
+			m, err = c.getFirstClusterSlots(ctx, addrs)
+		}
+	}
+	if err != nil {
+		// reset the refresh in progress
+		c.mu.Lock()
+		c.refreshing = nil
+		c.mu.Unlock()
+
+		c.logf("redisc: failed to refresh mapping: %v", err)
+		return err
+	}
+
+	if c.AddressRewriter != nil {
+		for _, sm := range m {
+			for i, node := range sm.nodes {
+				sm.nodes[i] = c.AddressRewriter(node)
 			}
-			for k := range c.replicas {
-				c.replicas[k] = false
+		}
+	}
+
+	// succeeded, save as mapping
+	c.mu.Lock()
+	if c.RejectStaleMapping && c.TopologyProvider == nil {
+		if slot, ok := c.staleSlotLocked(m); ok {
+			c.refreshing = nil
+			c.mu.Unlock()
+
+			c.logf("redisc: failed to refresh mapping: %v (slot %d)", ErrStaleMapping, slot)
+			return ErrStaleMapping
+		}
+	}
+
+	if c.masters == nil {
+		// not populated yet if the StartupNodes were not used
+		c.masters = make(map[string]bool)
+		c.replicas = make(map[string]bool)
+	}
+
+	// mark all current nodes as false
+	for k := range c.masters {
+		c.masters[k] = false
+	}
+	for k := range c.replicas {
+		c.replicas[k] = false
+	}
+
+	c.nodeInfos = make(map[string]nodeInfo)
+	// slots that are not in the refreshed mapping are not served anymore
+	c.mapping = Mapping{}
+	for _, sm := range m {
+		for i, node := range sm.nodes {
+			if i < len(sm.infos) {
+				c.nodeInfos[node] = sm.infos[i]
 			}
 
-			for _, sm := range m {
-				for i, node := range sm.nodes {
-					if node != "" {
-						target := c.masters
-						if i > 0 {
-							target = c.replicas
-						}
-						target[node] = true
-					}
-				}
-				for ix := sm.start; ix <= sm.end; ix++ {
-					c.mapping[ix] = sm.nodes
+			if node != "" {
+				target := c.masters
+				if i > 0 {
+					target = c.replicas
 				}
+				target[node] = true
 			}
+		}
+		for ix := sm.start; ix <= sm.end; ix++ {
+			c.mapping[ix] = sm.nodes
+		}
+	}
 
-			// remove all nodes that are gone from the cluster
-			for _, nodes := range []map[string]bool{c.masters, c.replicas} {
-				for k, ok := range nodes {
-					if !ok {
-						delete(nodes, k)
-
-						// close and remove all existing pools for removed nodes
-						if p := c.pools[k]; p != nil {
-							p.Close()
-							delete(c.pools, k)
-						}
-					}
-				}
+	// remove all nodes that are gone from the cluster
+	for _, nodes := range []map[string]bool{c.masters, c.replicas} {
+		for k, ok := range nodes {
+			if !ok {
+				delete(nodes, k)
+				delete(c.hellos, k)
+				delete(c.down, k)
+				delete(c.noShards, k)
+				delete(c.noReset, k)
+				delete(c.poolErrs, k)
+				c.latencyMu.Lock()
+				delete(c.latencies, k)
+				c.latencyMu.Unlock()
+				c.lagMu.Lock()
+				delete(c.lags, k)
+				c.lagMu.Unlock()
+				c.breakerMu.Lock()
+				delete(c.breakers, k)
+				c.breakerMu.Unlock()
 			}
+		}
+	}
 
-			// mark that no refresh is needed until another MOVED
-			c.refreshing = false
-			c.mu.Unlock()
+	// close the pools of the nodes that are gone from the cluster
+	c.reapPoolsLocked()
 
-			return nil
+	var newNodes []string
+	if c.pooled() {
+		for _, nodes := range []map[string]bool{c.masters, c.replicas} {
+			for k := range nodes {
+				if c.pools[k] == nil {
+					newNodes = append(newNodes, k)
+				}
+			}
 		}
 	}
 
-	// reset the refreshing flag
-	c.mu.Lock()
-	c.refreshing = false
+	// mark that no refresh is needed until another MOVED
+	c.refreshing = nil
+	c.lastRefresh = time.Now()
+	c.refreshSeq++
+	seq := c.refreshSeq
+	var cur Mapping
+	if c.OnRefresh != nil {
+		cur = c.mapping
+	}
+	masters, replicas := len(c.masters), len(c.replicas)
 	c.mu.Unlock()
 
-	return errors.New("redisc: all nodes failed")
+	c.logf("redisc: refreshed mapping: %d masters, %d replicas", masters, replicas)
+
+	// create the pools of the new nodes now, so that a failure to create
+	// them is reported by NodeErrors right after the refresh.
+	var warmup []string
+	for _, addr := range newNodes {
+		if _, err := c.getPool(ctx, addr); err != nil {
+			c.logf("redisc: failed to create pool for %s: %v", addr, err)
+			continue
+		}
+		warmup = append(warmup, addr)
+	}
+	if len(warmup) > 0 && c.WarmupPools > 0 {
+		go c.warmupPools(warmup, c.WarmupPools)
+	}
+	if c.OnRefresh != nil {
+		c.notifyRefresh(&cur, seq)
+	}
+	return nil
+}
+
+// notifyRefresh calls OnRefresh with the mapping of the refresh number
+// seq, unless a more recent refresh was already reported.
+func (c *Cluster) notifyRefresh(cur *Mapping, seq uint64) {
+	c.onRefreshMu.Lock()
+	defer c.onRefreshMu.Unlock()
+
+	if seq <= c.reportedSeq {
+		return
+	}
+	c.reportedSeq = seq
+	if len(c.reported.ChangedSlots(cur)) == 0 {
+		return
+	}
+	old := c.reported
+	c.reported = *cur
+	c.OnRefresh(&old, cur)
+}
+
+// maxRefreshQueries is the maximum number of nodes that are queried
+// concurrently for the mapping of slots when refreshing.
+const maxRefreshQueries = 3
+
+// defaultRefreshTimeout is the time to wait for a node's reply when
+// refreshing, if the cluster's RefreshTimeout field is not set.
+const defaultRefreshTimeout = 10 * time.Second
+
+// getFirstClusterSlots queries the nodes for their mapping of slots and
+// returns the first successful result, so that slow or unreachable nodes
+// don't delay the refresh. At most maxRefreshQueries nodes are queried
+// concurrently, the next node is queried when one of them fails. Once a
+// result is returned or ctx is done, the connections still waiting for a
+// reply are closed.
+func (c *Cluster) getFirstClusterSlots(ctx context.Context, addrs []string) ([]slotMapping, error) {
+	type result struct {
+		m   []slotMapping
+		err error
+	}
+
+	var (
+		mu    sync.Mutex
+		done  bool
+		conns = make(map[redis.Conn]bool)
+		ch    = make(chan result, len(addrs))
+	)
+	query := func(addr string) {
+		// the connection is not pooled, so that it can be closed safely
+		// while waiting for the reply if another node replied first.
+		conn, err := c.getConnForAddr(ctx, addr, true)
+		if err != nil {
+			ch <- result{nil, err}
+			return
+		}
+		defer conn.Close()
+
+		mu.Lock()
+		if done {
+			mu.Unlock()
+			ch <- result{nil, errors.New("redisc: refresh completed")}
+			return
+		}
+		conns[conn] = true
+		mu.Unlock()
+
+		m, err := c.getClusterSlots(conn, addr)
+
+		mu.Lock()
+		delete(conns, conn)
+		mu.Unlock()
+		ch <- result{m, err}
+	}
+
+	var next int
+	for ; next < len(addrs) && next < maxRefreshQueries; next++ {
+		go query(addrs[next])
+	}
+	stop := func() {
+		mu.Lock()
+		done = true
+		for conn := range conns {
+			conn.Close()
+		}
+		mu.Unlock()
+	}
+	var notReady bool
+	for pending := next; pending > 0; pending-- {
+		var res result
+		select {
+		case res = <-ch:
+		case <-ctx.Done():
+			stop()
+			return nil, ctx.Err()
+		}
+		if res.err == nil {
+			stop()
+			return res.m, nil
+		}
+		if res.err == ErrClusterNotReady {
+			notReady = true
+		}
+		if next < len(addrs) {
+			go query(addrs[next])
+			next++
+			pending++
+		}
+	}
+	if notReady {
+		return nil, ErrClusterNotReady
+	}
+	return nil, errors.New("redisc: all nodes failed")
 }
 
 // needsRefresh handles automatic update of the mapping.
 func (c *Cluster) needsRefresh(re *RedirError) {
+	if re != nil {
+		c.logf("redisc: %s redirection for slot %d to %s", re.Type, re.NewSlot, re.Addr)
+		if c.DisableAutoRefreshOnMoved {
+			return
+		}
+	}
+
 	c.mu.Lock()
 	if re != nil {
 		// update the mapping only if the address has changed, so that if
@@ -135,33 +892,87 @@ func (c *Cluster) needsRefresh(re *RedirError) {
 		// because the replica cannot serve that key). Same goes for a request
 		// to a random connection that gets a MOVED, should not overwrite
 		// the moved-to slot's configuration if the master's address is the same.
-		if current := c.mapping[re.NewSlot]; len(current) == 0 || current[0] != re.Addr {
-			c.mapping[re.NewSlot] = []string{re.Addr}
+		addr := c.rewriteAddr(re.Addr)
+		if current := c.mapping[re.NewSlot]; len(current) == 0 || current[0] != addr {
+			c.mapping[re.NewSlot] = []string{addr}
 		}
 	}
-	if !c.refreshing {
-		// refreshing is reset to only once the goroutine has
-		// finished updating the mapping, so a new refresh goroutine
-		// will only be started if none is running.
-		c.refreshing = true
-		go c.refresh()
+	if c.refreshing == nil {
+		// refreshing is reset only once the goroutine has finished
+		// updating the mapping, so a new refresh goroutine will only be
+		// started if none is running.
+		call := c.startRefreshLocked()
+
+		var delay time.Duration
+		if c.RefreshCooldown > 0 && !c.lastRefresh.IsZero() {
+			delay = c.RefreshCooldown - time.Since(c.lastRefresh)
+		}
+		if c.RefreshJitter > 0 {
+			if delay < 0 {
+				delay = 0
+			}
+			rnd.Lock()
+			delay += time.Duration(rnd.Int63n(int64(c.RefreshJitter)))
+			rnd.Unlock()
+		}
+		if delay > 0 {
+			go func() {
+				time.Sleep(delay)
+				c.runRefresh(context.Background(), call)
+			}()
+		} else {
+			go c.runRefresh(context.Background(), call)
+		}
 	}
 	c.mu.Unlock()
 }
 
 type slotMapping struct {
 	start, end int
-	nodes      []string // master is always at [0]
+	nodes      []string   // master is always at [0]
+	infos      []nodeInfo // additional information, same order as nodes
 }
 
-func (c *Cluster) getClusterSlots(addr string) ([]slotMapping, error) {
-	conn, err := c.getConnForAddr(addr, false)
-	if err != nil {
-		return nil, err
+// getClusterSlots returns the mapping of slots to nodes as reported by
+// the node at addr, using conn. It returns ErrClusterNotReady if the node
+// reports no slot.
+func (c *Cluster) getClusterSlots(conn redis.Conn, addr string) ([]slotMapping, error) {
+	m, err := c.queryClusterSlots(conn, addr)
+	if err == nil && len(m) == 0 {
+		return nil, ErrClusterNotReady
 	}
-	defer conn.Close()
+	if err == nil && (c.FetchConfigEpochs || c.RejectStaleMapping) {
+		err = c.getConfigEpochs(conn, m)
+	}
+	return m, err
+}
+
+func (c *Cluster) queryClusterSlots(conn redis.Conn, addr string) ([]slotMapping, error) {
+	timeout := c.RefreshTimeout
+	if timeout <= 0 {
+		timeout = defaultRefreshTimeout
+	}
+
+	c.mu.Lock()
+	noShards := c.noShards[addr]
+	c.mu.Unlock()
+	if !noShards {
+		m, err := getClusterShards(conn, timeout)
+		if err == nil || conn.Err() != nil {
+			return m, err
+		}
 
-	vals, err := redis.Values(conn.Do("CLUSTER", "SLOTS"))
+		// CLUSTER SHARDS is not supported by that node (before redis 7),
+		// use CLUSTER SLOTS from now on.
+		c.mu.Lock()
+		if c.noShards == nil {
+			c.noShards = make(map[string]bool)
+		}
+		c.noShards[addr] = true
+		c.mu.Unlock()
+	}
+
+	vals, err := redis.Values(redis.DoWithTimeout(conn, timeout, "CLUSTER", "SLOTS"))
 	if err != nil {
 		return nil, err
 	}
@@ -191,10 +1002,31 @@ func (c *Cluster) getClusterSlots(addr string) ([]slotMapping, error) {
 
 			var addr string
 			var port int
-			if _, err = redis.Scan(nodes, &addr, &port); err != nil {
+			nodes, err = redis.Scan(nodes, &addr, &port)
+			if err != nil {
 				return nil, err
 			}
+
+			// the node ID is returned since redis 4, and the metadata
+			// (e.g. the announced hostname) since redis 7.
+			var info nodeInfo
+			var meta []interface{}
+			switch {
+			case len(nodes) > 1:
+				_, err = redis.Scan(nodes, &info.id, &meta)
+			case len(nodes) > 0:
+				_, err = redis.Scan(nodes, &info.id)
+			}
+			if err != nil {
+				return nil, err
+			}
+			for i := 0; i+1 < len(meta); i += 2 {
+				if k, _ := redis.String(meta[i], nil); k == "hostname" {
+					info.hostname, _ = redis.String(meta[i+1], nil)
+				}
+			}
 			sm.nodes = append(sm.nodes, addr+":"+strconv.Itoa(port))
+			sm.infos = append(sm.infos, info)
 		}
 
 		m = append(m, sm)
@@ -203,46 +1035,235 @@ func (c *Cluster) getClusterSlots(addr string) ([]slotMapping, error) {
 	return m, nil
 }
 
-func (c *Cluster) getConnForAddr(addr string, forceDial bool) (redis.Conn, error) {
-	// non-pooled doesn't require a lock
-	if c.CreatePool == nil || forceDial {
-		return redis.Dial("tcp", addr, c.DialOptions...)
+func (c *Cluster) getConnForAddr(ctx context.Context, addr string, forceDial bool) (redis.Conn, error) {
+	if err := c.breakerAllow(addr); err != nil {
+		return nil, err
+	}
+	conn, err := c.dialOrGetConn(ctx, addr, forceDial)
+	c.breakerRecord(addr, err)
+	if err == nil && c.Protocol != 0 {
+		if err = c.hello(conn, addr); err != nil {
+			conn.Close()
+			return nil, err
+		}
 	}
+	return conn, err
+}
 
-	c.mu.Lock()
+func (c *Cluster) dialOrGetConn(ctx context.Context, addr string, forceDial bool) (redis.Conn, error) {
+	if c.DryRun != nil {
+		return &dryRunConn{cluster: c, addr: addr}, nil
+	}
 
-	p := c.pools[addr]
-	if p == nil {
-		c.mu.Unlock()
-		pool, err := c.CreatePool(addr, c.DialOptions...)
+	// non-pooled doesn't require a lock
+	if !c.pooled() || forceDial {
+		opts := c.dialOptions(addr)
+		if ctx.Done() != nil {
+			// the context applies to the dial, it replaces any DialNetDial
+			// and DialConnectTimeout options.
+			opts = append(opts[:len(opts):len(opts)], redis.DialNetDial(func(network, address string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, address)
+			}))
+		}
+		conn, err := redis.Dial("tcp", addr, opts...)
 		if err != nil {
 			return nil, err
 		}
+		return c.authConn(conn)
+	}
 
-		c.mu.Lock()
-		// check again, concurrent request may have set the pool in the meantime
-		if p = c.pools[addr]; p == nil {
-			if c.pools == nil {
-				c.pools = make(map[string]*redis.Pool, len(c.StartupNodes))
-			}
-			c.pools[addr] = pool
-			p = pool
-		} else {
-			// Don't assume CreatePool just returned the pool struct, it may have
-			// used a connection or something - always match CreatePool with Close.
-			// Do it in a defer to keep lock time short.
-			defer pool.Close()
+	p, err := c.getPool(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	if obs, ok := c.Observer.(PoolWaitObserver); ok {
+		start := time.Now()
+		defer func() {
+			obs.OnPoolWait(addr, time.Since(start))
+		}()
+	}
+	if ctx.Done() != nil {
+		return p.GetContext(ctx)
+	}
+	conn := p.Get()
+	return conn, conn.Err()
+}
+
+// getPool returns the pool of the node at addr, creating it if needed.
+// The error returned by the creation of the pool, if any, is recorded
+// for the node (see NodeErrors).
+func (c *Cluster) getPool(ctx context.Context, addr string) (*redis.Pool, error) {
+	c.mu.RLock()
+	p := c.pools[addr]
+	c.mu.RUnlock()
+	if p != nil {
+		return p, nil
+	}
+
+	pool, err := c.createPool(ctx, addr)
+	if err == nil {
+		c.authPool(pool)
+		if c.MaxConnLifetime > 0 {
+			pool.MaxConnLifetime = c.MaxConnLifetime
 		}
 	}
+
+	c.mu.Lock()
+	if err != nil {
+		if c.poolErrs == nil {
+			c.poolErrs = make(map[string]error)
+		}
+		c.poolErrs[addr] = err
+		c.mu.Unlock()
+		return nil, err
+	}
+	delete(c.poolErrs, addr)
+
+	if err := c.err; err != nil {
+		// the cluster was closed in the meantime
+		c.mu.Unlock()
+		pool.Close()
+		return nil, err
+	}
+	// check again, concurrent request may have set the pool in the meantime
+	if p = c.pools[addr]; p == nil {
+		if c.pools == nil {
+			c.pools = make(map[string]*redis.Pool, len(c.StartupNodes))
+		}
+		c.pools[addr] = pool
+		c.mu.Unlock()
+		c.logf("redisc: created pool for %s", addr)
+		return pool, nil
+	}
 	c.mu.Unlock()
 
-	conn := p.Get()
-	return conn, conn.Err()
+	// Don't assume CreatePool just returned the pool struct, it may have
+	// used a connection or something - always match CreatePool with Close.
+	pool.Close()
+	return p, nil
+}
+
+// pooled returns true if the connections to the nodes are pooled, i.e.
+// if CreatePool or CreatePoolContext is set.
+func (c *Cluster) pooled() bool {
+	return c.CreatePool != nil || c.CreatePoolContext != nil
+}
+
+// createPool creates the pool for the node at addr, using
+// CreatePoolContext if it is set, CreatePool otherwise.
+func (c *Cluster) createPool(ctx context.Context, addr string) (*redis.Pool, error) {
+	if c.CreatePoolContext != nil {
+		return c.CreatePoolContext(ctx, addr, c.dialOptions(addr)...)
+	}
+	return c.CreatePool(addr, c.dialOptions(addr)...)
+}
+
+// rewriteAddr returns the address to use to connect to the node at addr,
+// as returned by the AddressRewriter.
+func (c *Cluster) rewriteAddr(addr string) string {
+	if c.AddressRewriter == nil {
+		return addr
+	}
+	return c.AddressRewriter(addr)
+}
+
+// dialOptions returns the options to use to connect to the node at addr.
+func (c *Cluster) dialOptions(addr string) []redis.DialOption {
+	var defaults []redis.DialOption
+	if c.ReadTimeout != 0 {
+		defaults = append(defaults, redis.DialReadTimeout(c.ReadTimeout))
+	}
+	if c.WriteTimeout != 0 {
+		defaults = append(defaults, redis.DialWriteTimeout(c.WriteTimeout))
+	}
+
+	var nodeOpts []redis.DialOption
+	if c.HostnameTLSConfig != nil {
+		c.mu.RLock()
+		hostname := c.nodeInfos[addr].hostname
+		c.mu.RUnlock()
+
+		if hostname != "" {
+			cfg := c.HostnameTLSConfig.Clone()
+			cfg.ServerName = hostname
+			nodeOpts = append(nodeOpts, redis.DialTLSConfig(cfg))
+		}
+	}
+	if c.NodeDialOptions != nil {
+		nodeOpts = append(nodeOpts, c.NodeDialOptions(addr)...)
+	}
+	if len(defaults) == 0 && len(nodeOpts) == 0 {
+		return c.DialOptions
+	}
+	opts := make([]redis.DialOption, 0, len(defaults)+len(c.DialOptions)+len(nodeOpts))
+	opts = append(opts, defaults...)
+	opts = append(opts, c.DialOptions...)
+	return append(opts, nodeOpts...)
 }
 
 var errNoNodeForSlot = errors.New("redisc: no node for slot")
 
-func (c *Cluster) getConnForSlot(slot int, forceDial, readOnly bool) (redis.Conn, string, error) {
+// slot returns the hash slot of key, using KeyToSlot if it is set.
+func (c *Cluster) slot(key string) int {
+	if c.KeyToSlot == nil {
+		return Slot(key)
+	}
+	if slot := c.KeyToSlot([]byte(key)); slot >= 0 && slot < hashSlots {
+		return slot
+	}
+	return -1
+}
+
+// keylessCmds is the list of commands that have no key, even though
+// they may have parameters. They are sent to a random node when the
+// connection is not bound.
+var keylessCmds = map[string]bool{
+	"BGREWRITEAOF": true,
+	"BGSAVE":       true,
+	"CLIENT":       true,
+	"CLUSTER":      true,
+	"COMMAND":      true,
+	"CONFIG":       true,
+	"DBSIZE":       true,
+	"ECHO":         true,
+	"FLUSHALL":     true,
+	"FLUSHDB":      true,
+	"INFO":         true,
+	"LASTSAVE":     true,
+	"LATENCY":      true,
+	"PING":         true,
+	"RANDOMKEY":    true,
+	"ROLE":         true,
+	"SAVE":         true,
+	"SCRIPT":       true,
+	"SLOWLOG":      true,
+	"TIME":         true,
+}
+
+// cmdSlot returns the slot of the command's first key, as returned by
+// cmdKeys, or of its first parameter, assumed to be its key, if its keys
+// are not known. It
+// returns -1 if it has no key or if the command has no key (see
+// keylessCmds).
+func (c *Cluster) cmdSlot(cmd string, args []interface{}) int {
+	name := strings.ToUpper(cmd)
+	if keylessCmds[name] {
+		return -1
+	}
+	if keys, ok := c.cmdKeys(name, args); ok {
+		if len(keys) > 0 {
+			return c.slot(keyString(keys[0]))
+		}
+		return -1
+	}
+	if len(args) > 0 {
+		return c.slot(fmt.Sprintf("%s", args[0]))
+	}
+	return -1
+}
+
+func (c *Cluster) getConnForSlot(ctx context.Context, slot int, forceDial, readOnly bool) (redis.Conn, string, error) {
 	c.mu.Lock()
 	addrs := c.mapping[slot]
 	c.mu.Unlock()
@@ -257,16 +1278,39 @@ func (c *Cluster) getConnForSlot(slot int, forceDial, readOnly bool) (redis.Conn
 		// get the address of a replica
 		if len(addrs) == 2 {
 			addr = addrs[1]
+		} else if c.ReplicaSelector != nil {
+			addr = c.ReplicaSelector(addrs[1:])
+		} else if c.LatencyAwareReplicas {
+			addr = c.fastestReplica(addrs[1:])
 		} else {
 			rnd.Lock()
 			ix := rnd.Intn(len(addrs) - 1)
 			rnd.Unlock()
 			addr = addrs[ix+1] // +1 because 0 is the master
 		}
+		if c.breakerOpen(addr) {
+			// use another replica, if one is available
+			for _, replica := range addrs[1:] {
+				if !c.breakerOpen(replica) {
+					addr = replica
+					break
+				}
+			}
+		}
+		if c.MaxReplicaLag > 0 && c.replicaLagging(ctx, addrs[0], addr) {
+			// use another replica that is up to date, or the master
+			addr, readOnly = addrs[0], false
+			for _, replica := range addrs[1:] {
+				if !c.breakerOpen(replica) && !c.replicaLagging(ctx, addrs[0], replica) {
+					addr, readOnly = replica, true
+					break
+				}
+			}
+		}
 	} else {
 		readOnly = false
 	}
-	conn, err := c.getConnForAddr(addr, forceDial)
+	conn, err := c.getConnForAddr(ctx, addr, forceDial)
 	if err == nil && readOnly {
 		conn.Do("READONLY")
 	}
@@ -279,15 +1323,19 @@ var rnd = struct {
 	*rand.Rand
 }{Rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
 
-func (c *Cluster) getRandomConn(forceDial, readOnly bool) (redis.Conn, string, error) {
+func (c *Cluster) getRandomConn(ctx context.Context, forceDial, readOnly bool) (redis.Conn, string, error) {
 	addrs := c.getNodeAddrs(readOnly)
 	rnd.Lock()
 	perms := rnd.Perm(len(addrs))
 	rnd.Unlock()
 
-	for _, ix := range perms {
-		addr := addrs[ix]
-		conn, err := c.getConnForAddr(addr, forceDial)
+	shuffled := make([]string, len(addrs))
+	for i, ix := range perms {
+		shuffled[i] = addrs[ix]
+	}
+
+	for _, addr := range c.availableFirst(shuffled) {
+		conn, err := c.getConnForAddr(ctx, addr, forceDial)
 		if err == nil {
 			if readOnly {
 				conn.Do("READONLY")
@@ -295,22 +1343,127 @@ func (c *Cluster) getRandomConn(forceDial, readOnly bool) (redis.Conn, string, e
 			return conn, addr, nil
 		}
 	}
-	return nil, "", errors.New("redisc: failed to get a connection")
+	return nil, "", ErrNoConnection
 }
 
-func (c *Cluster) getConn(preferredSlot int, forceDial, readOnly bool) (conn redis.Conn, addr string, err error) {
+func (c *Cluster) getConn(ctx context.Context, preferredSlot int, forceDial, readOnly bool) (conn redis.Conn, addr string, err error) {
 	if preferredSlot >= 0 {
-		conn, addr, err = c.getConnForSlot(preferredSlot, forceDial, readOnly)
+		conn, addr, err = c.getConnForSlot(ctx, preferredSlot, forceDial, readOnly)
 		if err == errNoNodeForSlot {
 			c.needsRefresh(nil)
 		}
 	}
+	if err != nil && ctx.Err() != nil {
+		// no point in trying other nodes, they would fail the same way
+		return nil, "", ctx.Err()
+	}
 	if preferredSlot < 0 || err != nil {
-		conn, addr, err = c.getRandomConn(forceDial, readOnly)
+		conn, addr, err = c.getRandomConn(ctx, forceDial, readOnly)
+		if err != nil && ctx.Err() != nil {
+			err = ctx.Err()
+		}
 	}
 	return conn, addr, err
 }
 
+// refreshAddrs returns the addresses of the nodes to query for the
+// mapping of slots, masters first, ordered as documented for the
+// OrderedRefresh field.
+func (c *Cluster) refreshAddrs() []string {
+	masters, replicas := c.getNodeAddrs(false), c.getNodeAddrs(true)
+	if !c.OrderedRefresh {
+		shuffle(masters)
+		shuffle(replicas)
+		return dedupeAddrs(append(masters, replicas...))
+	}
+
+	rank := make(map[string]int, len(c.StartupNodes))
+	for i, addr := range c.StartupNodes {
+		addr = normalizeAddr(addr)
+		if _, ok := rank[addr]; !ok {
+			rank[addr] = i
+		}
+	}
+	byRank := func(addrs []string) {
+		sort.Slice(addrs, func(i, j int) bool {
+			ri, iok := rank[addrs[i]]
+			rj, jok := rank[addrs[j]]
+			if iok != jok {
+				return iok
+			}
+			if iok {
+				return ri < rj
+			}
+			return addrs[i] < addrs[j]
+		})
+	}
+	byRank(masters)
+	byRank(replicas)
+	return dedupeAddrs(append(masters, replicas...))
+}
+
+// shuffle shuffles the addresses in place.
+func shuffle(addrs []string) {
+	rnd.Lock()
+	rnd.Shuffle(len(addrs), func(i, j int) {
+		addrs[i], addrs[j] = addrs[j], addrs[i]
+	})
+	rnd.Unlock()
+}
+
+// dedupeAddrs returns the addresses with duplicates removed, in the same
+// order.
+func dedupeAddrs(addrs []string) []string {
+	seen := make(map[string]bool, len(addrs))
+	res := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		if !seen[addr] {
+			seen[addr] = true
+			res = append(res, addr)
+		}
+	}
+	return res
+}
+
+// normalizeAddr returns the canonical spelling of the address addr, so
+// that the same node listed twice in StartupNodes (e.g. with "::1" and
+// "0:0::1", or with a hostname in a different case) is only used once.
+// Different hosts that resolve to the same node (e.g. "localhost" and
+// "127.0.0.1") are not merged, as this would require a DNS lookup. The
+// address is returned unchanged if it is not a valid "host:port".
+func normalizeAddr(addr string) string {
+	host, port, err := net.SplitHostPort(strings.TrimSpace(addr))
+	if err != nil {
+		return addr
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		host = ip.String()
+	} else {
+		host = strings.ToLower(host)
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// availableFirst returns the addresses with the nodes marked unavailable
+// by the health-checker moved at the end, and duplicates removed.
+func (c *Cluster) availableFirst(addrs []string) []string {
+	addrs = dedupeAddrs(addrs)
+	res := make([]string, 0, len(addrs))
+	var down []string
+
+	c.mu.RLock()
+	for _, addr := range addrs {
+		if c.down[addr] {
+			down = append(down, addr)
+			continue
+		}
+		res = append(res, addr)
+	}
+	c.mu.RUnlock()
+
+	return append(res, down...)
+}
+
 func (c *Cluster) getNodeAddrs(preferReplicas bool) []string {
 	c.mu.Lock()
 
@@ -319,9 +1472,10 @@ func (c *Cluster) getNodeAddrs(preferReplicas bool) []string {
 		c.masters = make(map[string]bool)
 		c.replicas = make(map[string]bool)
 
-		// StartupNodes should be masters
+		// StartupNodes should be masters, the same node may be listed with
+		// different spellings of its address
 		for _, n := range c.StartupNodes {
-			c.masters[n] = true
+			c.masters[normalizeAddr(n)] = true
 		}
 	}
 
@@ -345,11 +1499,7 @@ func (c *Cluster) getNodeAddrs(preferReplicas bool) []string {
 // pool, even if CreatePool is set. The actual returned
 // type is *Conn, see its documentation for details.
 func (c *Cluster) Dial() (redis.Conn, error) {
-	c.mu.Lock()
-	err := c.err
-	c.mu.Unlock()
-
-	if err != nil {
+	if err := c.checkMapping(); err != nil {
 		return nil, err
 	}
 
@@ -364,14 +1514,73 @@ func (c *Cluster) Dial() (redis.Conn, error) {
 // returned connection. The actual returned type is *Conn,
 // see its documentation for details.
 func (c *Cluster) Get() redis.Conn {
+	err := c.checkMapping()
+	return &Conn{
+		cluster: c,
+		err:     err,
+	}
+}
+
+// GetContext is like Get, but the connection to the node is made with
+// ctx when the returned connection is bound: getting a connection from
+// a pool waits for an available connection (if the pool's Wait field is
+// set) only until ctx is done, and a new connection is dialed with ctx.
+// Once ctx is done, the calls that need to bind the connection fail with
+// ctx.Err(). The context doesn't apply to the commands executed once the
+// connection is bound, see DoContext for this. It returns an error if
+// ctx is already done or if Get would return a connection that fails.
+func (c *Cluster) GetContext(ctx context.Context) (redis.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := c.checkMapping(); err != nil {
+		return nil, err
+	}
+	return &Conn{
+		cluster: c,
+		ctx:     ctx,
+	}, nil
+}
+
+// checkMapping returns the error of the cluster, if it is closed. If
+// FailFastOnEmptyMapping is set and the mapping was never refreshed, it
+// refreshes it and returns the error if that fails.
+func (c *Cluster) checkMapping() error {
 	c.mu.Lock()
 	err := c.err
+	refresh := err == nil && c.FailFastOnEmptyMapping && c.refreshSeq == 0
 	c.mu.Unlock()
 
-	return &Conn{
-		cluster: c,
-		err:     err,
+	if refresh {
+		err = c.Refresh()
+	}
+	return err
+}
+
+// Do is a convenience method that gets a connection from the cluster,
+// executes the command on it and closes the connection. The connection
+// is bound to the node of the slot of the command's first parameter, as
+// for Conn.Do. If the cluster uses pools, the connection is returned to
+// its pool, so it respects the pool's configuration (e.g. its Wait field).
+// See the MaxAttempts field to automatically follow redirections, and
+// the RouteReadsToReplicas field to send the read commands to replicas.
+func (c *Cluster) Do(cmd string, args ...interface{}) (interface{}, error) {
+	conn := c.Get()
+	defer conn.Close()
+
+	if c.RouteReadsToReplicas && c.isReadCommand(cmd) {
+		if err := ReadOnlyConn(conn); err != nil {
+			return nil, err
+		}
+	}
+	if c.MaxAttempts > 0 {
+		rc, err := RetryConn(conn, c.MaxAttempts, c.TryAgainDelay)
+		if err != nil {
+			return nil, err
+		}
+		conn = rc
 	}
+	return conn.Do(cmd, args...)
 }
 
 // Close releases the resources used by the cluster. It closes all the
@@ -381,6 +1590,12 @@ func (c *Cluster) Close() error {
 	err := c.err
 	if err == nil {
 		c.err = errors.New("redisc: closed")
+		if c.healthStop != nil {
+			close(c.healthStop)
+		}
+		if c.reapTimer != nil {
+			c.reapTimer.Stop()
+		}
 		for _, p := range c.pools {
 			if e := p.Close(); e != nil && err == nil {
 				err = e
@@ -392,7 +1607,47 @@ func (c *Cluster) Close() error {
 	return err
 }
 
-// Stats returns the current statistics for all pools. Keys are node's addresses.
+// CloseContext is like Close, but it then waits for the connections
+// still in use to be returned to their pool (and closed), until ctx is
+// done. It returns ctx.Err() if some connections are still in use when
+// the context is done. Only the pooled connections (see CreatePool) are
+// waited for, connections obtained with Dial are not tracked.
+func (c *Cluster) CloseContext(ctx context.Context) error {
+	if err := c.Close(); err != nil {
+		return err
+	}
+
+	// no pool can be added once the cluster is closed
+	c.mu.RLock()
+	pools := make([]*redis.Pool, 0, len(c.pools))
+	for _, p := range c.pools {
+		pools = append(pools, p)
+	}
+	c.mu.RUnlock()
+
+	t := time.NewTicker(10 * time.Millisecond)
+	defer t.Stop()
+	for {
+		var active int
+		for _, p := range pools {
+			active += p.Stats().ActiveCount
+		}
+		if active == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// Stats returns the current statistics for all pools. Keys are node's
+// addresses, so that a single node exhausting its pool (e.g. because of
+// an uneven distribution of keys) can be identified. Nodes for which no
+// pool was created yet are not in the returned map.
 func (c *Cluster) Stats() map[string]redis.PoolStats {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
@@ -405,3 +1660,21 @@ func (c *Cluster) Stats() map[string]redis.PoolStats {
 
 	return stats
 }
+
+// NodeErrors returns the errors returned by CreatePool (or
+// CreatePoolContext) for the nodes for which the pool could not be
+// created. Keys are the nodes' addresses. The pools of the nodes
+// discovered by a refresh of the mapping are created by that refresh, so
+// that a misconfiguration is reported right after the call to Refresh
+// instead of on a later command. A node's error is cleared when its pool
+// is successfully created, or when it is removed from the cluster.
+func (c *Cluster) NodeErrors() map[string]error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	errs := make(map[string]error, len(c.poolErrs))
+	for addr, err := range c.poolErrs {
+		errs[addr] = err
+	}
+	return errs
+}
@@ -1,8 +1,14 @@
 package redisc
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -75,12 +81,108 @@ func TestClusterNoNode(t *testing.T) {
 	_, err := conn.Do("A")
 	if assert.Error(t, err, "Do") {
 		assert.Contains(t, err.Error(), "failed to get a connection", "expected message")
+		assert.True(t, errors.Is(err, ErrNoConnection), "ErrNoConnection")
 	}
 	if err := BindConn(conn); assert.Error(t, err, "Bind without key") {
 		assert.Contains(t, err.Error(), "failed to get a connection", "expected message")
+		assert.True(t, errors.Is(err, ErrNoConnection), "ErrNoConnection")
 	}
 	if err := BindConn(conn, "A"); assert.Error(t, err, "Bind with key") {
 		assert.Contains(t, err.Error(), "failed to get a connection", "expected message")
+		assert.True(t, errors.Is(err, ErrNoConnection), "ErrNoConnection")
+	}
+}
+
+func TestClusterFailFastOnEmptyMapping(t *testing.T) {
+	var s *redistest.MockServer
+	var up int32
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		if atomic.LoadInt32(&up) == 0 {
+			return resp.Error("nope")
+		}
+		switch cmd {
+		case "CLUSTER":
+			return mockClusterSlots(s.Addr)
+		case "GET":
+			return args[0]
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	c := &Cluster{
+		StartupNodes:           []string{s.Addr},
+		FailFastOnEmptyMapping: true,
+	}
+	defer c.Close()
+
+	conn := c.Get()
+	if err := conn.Err(); assert.Error(t, err, "Get") {
+		assert.Contains(t, err.Error(), "all nodes failed", "expected message")
+	}
+	_, err := conn.Do("GET", "a")
+	assert.Error(t, err, "Do")
+	conn.Close()
+	_, err = c.Dial()
+	assert.Error(t, err, "Dial")
+
+	atomic.StoreInt32(&up, 1)
+	conn = c.Get()
+	defer conn.Close()
+	require.NoError(t, conn.Err(), "Get")
+	v, err := redis.String(conn.Do("GET", "a"))
+	if assert.NoError(t, err, "Do") {
+		assert.Equal(t, "a", v, "expected result")
+	}
+	c.mu.Lock()
+	assert.Equal(t, []string{s.Addr}, c.mapping[0], "mapping refreshed")
+	c.mu.Unlock()
+}
+
+func TestClusterCloseContext(t *testing.T) {
+	var s *redistest.MockServer
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return mockClusterSlots(s.Addr)
+		case "GET":
+			return args[0]
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s.Addr},
+		CreatePool:   createPool,
+	}
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	conn := c.Get()
+	_, err := conn.Do("GET", "a")
+	require.NoError(t, err, "GET")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, c.CloseContext(ctx), "CloseContext with connection in use")
+	assert.Error(t, c.Get().Err(), "Get after close")
+	conn.Close()
+
+	c = &Cluster{
+		StartupNodes: []string{s.Addr},
+		CreatePool:   createPool,
+	}
+	require.NoError(t, c.Refresh(), "Refresh")
+	conn = c.Get()
+	_, err = conn.Do("GET", "a")
+	require.NoError(t, err, "GET")
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		conn.Close()
+	}()
+	assert.NoError(t, c.CloseContext(context.Background()), "CloseContext")
+	for addr, st := range c.Stats() {
+		assert.Equal(t, 0, st.ActiveCount, "active connections of %s", addr)
 	}
 }
 
@@ -112,9 +214,9 @@ func TestClusterNeedsRefresh(t *testing.T) {
 	// random node, because no mapping is known yet)
 	conn.Do("GET", "b")
 
-	// wait for refreshing to become false again
+	// wait for the refresh to complete
 	c.mu.Lock()
-	for c.refreshing {
+	for c.refreshing != nil {
 		c.mu.Unlock()
 		time.Sleep(100 * time.Millisecond)
 		c.mu.Lock()
@@ -127,6 +229,290 @@ func TestClusterNeedsRefresh(t *testing.T) {
 	c.mu.Unlock()
 }
 
+func TestClusterAuth(t *testing.T) {
+	fn, ports := redistest.StartClusterWithOptions(t, nil, redistest.ClusterOptions{Password: "secret"})
+	defer fn()
+
+	for i, p := range ports {
+		ports[i] = ":" + p
+	}
+
+	// without the password, the mapping cannot be loaded
+	c := &Cluster{StartupNodes: ports}
+	assert.Error(t, c.Refresh(), "Refresh without password")
+	c.Close()
+
+	c = &Cluster{
+		StartupNodes: ports,
+		DialOptions:  []redis.DialOption{redis.DialPassword("secret")},
+		CreatePool:   createPool,
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	// with a stale mapping, the commands are redirected
+	c.mu.Lock()
+	for i := range c.mapping {
+		c.mapping[i] = []string{ports[0]}
+	}
+	c.mu.Unlock()
+
+	conn, err := RetryConn(c.Get(), 3, 0)
+	require.NoError(t, err, "RetryConn")
+	defer conn.Close()
+
+	for _, k := range []string{"a", "b", "c"} {
+		_, err := conn.Do("SET", k, k)
+		require.NoError(t, err, "SET %s", k)
+		v, err := redis.String(conn.Do("GET", k))
+		require.NoError(t, err, "GET %s", k)
+		assert.Equal(t, k, v, "value of %s", k)
+	}
+}
+
+func TestClusterTLS(t *testing.T) {
+	certs, rmCerts := redistest.GenerateTLSCerts(t, "node.redis.test")
+	defer rmCerts()
+
+	fn, ports := redistest.StartClusterWithOptions(t, nil, redistest.ClusterOptions{
+		TLS:              certs,
+		AnnounceHostname: "node.redis.test",
+	})
+	defer fn()
+
+	for i, p := range ports {
+		ports[i] = "127.0.0.1:" + p
+	}
+	c := &Cluster{
+		StartupNodes: ports,
+		DialOptions: []redis.DialOption{
+			redis.DialUseTLS(true),
+			redis.DialTLSConfig(certs.ClientConfig()),
+		},
+		HostnameTLSConfig: certs.ClientConfig(),
+		CreatePool:        createPool,
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	for _, n := range c.Nodes() {
+		assert.Equal(t, "node.redis.test", n.Hostname, "hostname of %s", n.Addr)
+	}
+
+	conn, err := RetryConn(c.Get(), 3, 0)
+	require.NoError(t, err, "RetryConn")
+	defer conn.Close()
+
+	for _, k := range []string{"a", "b", "c"} {
+		_, err := conn.Do("SET", k, k)
+		require.NoError(t, err, "SET %s", k)
+		v, err := redis.String(conn.Do("GET", k))
+		require.NoError(t, err, "GET %s", k)
+		assert.Equal(t, k, v, "value of %s", k)
+	}
+}
+
+func TestClusterGetContext(t *testing.T) {
+	var s *redistest.MockServer
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return mockClusterSlots(s.Addr)
+		case "GET":
+			return args[0]
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s.Addr},
+		CreatePool: func(addr string, opts ...redis.DialOption) (*redis.Pool, error) {
+			p, err := createPool(addr, opts...)
+			if err != nil {
+				return nil, err
+			}
+			p.MaxActive = 1
+			p.Wait = true
+			return p, nil
+		},
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := c.GetContext(cancelled)
+	assert.Equal(t, context.Canceled, err, "GetContext with cancelled context")
+
+	// hold the only connection of the pool
+	conn1, err := c.GetContext(context.Background())
+	require.NoError(t, err, "GetContext")
+	_, err = conn1.Do("GET", "a")
+	require.NoError(t, err, "Do")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	conn2, err := c.GetContext(ctx)
+	require.NoError(t, err, "GetContext with timeout")
+	defer conn2.Close()
+	start := time.Now()
+	_, err = conn2.Do("GET", "b")
+	assert.Equal(t, context.DeadlineExceeded, err, "Do while the pool is exhausted")
+	assert.True(t, time.Since(start) < time.Second, "returned at the deadline")
+
+	// once a connection is available, a connection with a context that is
+	// not done binds and executes commands normally
+	require.NoError(t, conn1.Close(), "Close")
+	conn3, err := c.GetContext(context.Background())
+	require.NoError(t, err, "GetContext")
+	defer conn3.Close()
+	v, err := redis.String(conn3.Do("GET", "c"))
+	require.NoError(t, err, "Do")
+	assert.Equal(t, "c", v, "GET value")
+}
+
+// interceptConn is a connection wrapper that only implements redis.Conn
+// and records the commands executed with Do.
+type interceptConn struct {
+	redis.Conn
+	mu   *sync.Mutex
+	cmds *[]string
+}
+
+func (c interceptConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	c.mu.Lock()
+	*c.cmds = append(*c.cmds, cmd)
+	c.mu.Unlock()
+	return c.Conn.Do(cmd, args...)
+}
+
+func TestClusterCreatePoolInterceptor(t *testing.T) {
+	var s *redistest.MockServer
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return mockClusterSlots(s.Addr, s.Addr)
+		case "GET":
+			return args[0]
+		case "PING":
+			return "PONG"
+		case "READONLY", "READWRITE":
+			return resp.OK{}
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	var mu sync.Mutex
+	var cmds []string
+	c := &Cluster{
+		StartupNodes: []string{s.Addr},
+		CreatePool: func(addr string, opts ...redis.DialOption) (*redis.Pool, error) {
+			p, err := createPool(addr, opts...)
+			if err != nil {
+				return nil, err
+			}
+			dial := p.Dial
+			p.Dial = func() (redis.Conn, error) {
+				conn, err := dial()
+				if err != nil {
+					return nil, err
+				}
+				return interceptConn{Conn: conn, mu: &mu, cmds: &cmds}, nil
+			}
+			return p, nil
+		},
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	conn := c.Get()
+	require.NoError(t, ReadOnlyConn(conn), "ReadOnly")
+	v, err := redis.String(conn.Do("GET", "a"))
+	require.NoError(t, err, "GET")
+	assert.Equal(t, "a", v, "GET value")
+	require.NoError(t, conn.Close(), "Close")
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Contains(t, cmds, "READONLY", "READONLY intercepted")
+	assert.Contains(t, cmds, "GET", "GET intercepted")
+	assert.Contains(t, cmds, "READWRITE", "READWRITE intercepted")
+}
+
+func TestClusterAddressRewriter(t *testing.T) {
+	var s1, s2 *redistest.MockServer
+	s1 = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return resp.Array{
+				0: resp.Array{0: int64(0), 1: int64(8000), 2: resp.Array{0: "10.0.0.1", 1: int64(7000)}},
+				1: resp.Array{0: int64(8001), 1: int64(16383), 2: resp.Array{0: "10.0.0.2", 1: int64(7001)}},
+			}
+		case "GET":
+			switch args[0] {
+			case "moved":
+				return resp.Error("MOVED " + strconv.Itoa(Slot(args[0])) + " 10.0.0.2:7001")
+			case "ask":
+				return resp.Error("ASK " + strconv.Itoa(Slot(args[0])) + " 10.0.0.2:7001")
+			}
+			return "s1"
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s1.Close()
+	s2 = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "ASKING":
+			return resp.OK{}
+		case "GET":
+			return "s2"
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s2.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s1.Addr},
+		CreatePool:   createPool,
+		AddressRewriter: func(addr string) string {
+			switch addr {
+			case "10.0.0.1:7000":
+				return s1.Addr
+			case "10.0.0.2:7001":
+				return s2.Addr
+			}
+			return addr
+		},
+		RefreshCooldown: time.Minute,
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	c.mu.Lock()
+	assert.Equal(t, []string{s1.Addr}, c.mapping[0], "rewritten master of slot 0")
+	assert.Equal(t, []string{s2.Addr}, c.mapping[hashSlots-1], "rewritten master of last slot")
+	c.mu.Unlock()
+
+	for _, k := range []string{"ask", "moved"} {
+		// bind to s1 regardless of the slot of the key
+		conn := c.Get().(*Conn)
+		require.NoError(t, conn.BindSlot(0), "BindSlot")
+		rc, err := RetryConn(conn, 3, 0)
+		require.NoError(t, err, "RetryConn")
+		v, err := redis.String(rc.Do("GET", k))
+		if assert.NoError(t, err, "GET %s", k) {
+			assert.Equal(t, "s2", v, "redirection of %s followed to rewritten address", k)
+		}
+		rc.Close()
+	}
+
+	c.mu.Lock()
+	assert.Equal(t, []string{s2.Addr}, c.mapping[Slot("moved")], "rewritten MOVED address")
+	c.mu.Unlock()
+}
+
 func TestClusterClose(t *testing.T) {
 	c := &Cluster{
 		StartupNodes: []string{":6379"},
@@ -491,3 +877,891 @@ func runCommands(t *testing.T, c *Cluster, cmds []redisCmd, wg *sync.WaitGroup)
 		require.NoError(t, conn.Close(), "Close")
 	}
 }
+
+// mockClusterSlots returns the reply to a CLUSTER SLOTS command for a
+// cluster with a single master served by the mock server listening on
+// addr, with the specified replicas.
+func mockClusterSlots(addr string, replicas ...string) interface{} {
+	slots := resp.Array{0: int64(0), 1: int64(16383)}
+	for _, a := range append([]string{addr}, replicas...) {
+		host, port, _ := net.SplitHostPort(a)
+		nPort, _ := strconv.Atoi(port)
+		slots = append(slots, resp.Array{0: host, 1: int64(nPort)})
+	}
+	return resp.Array{0: slots}
+}
+
+func TestClusterDo(t *testing.T) {
+	var s *redistest.MockServer
+	var moved int32
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return mockClusterSlots(s.Addr)
+		case "GET":
+			if atomic.AddInt32(&moved, 1) == 1 {
+				return resp.Error("MOVED 1234 " + s.Addr)
+			}
+			return args[0]
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s.Addr},
+		CreatePool:   createPool,
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	_, err := c.Do("GET", "a")
+	if assert.Error(t, err, "Do with MOVED") {
+		assert.NotNil(t, ParseRedir(err), "MOVED error")
+	}
+	v, err := redis.String(c.Do("GET", "a"))
+	if assert.NoError(t, err, "Do") {
+		assert.Equal(t, "a", v, "expected result")
+	}
+
+	// all connections are returned to the pool
+	for _, st := range c.Stats() {
+		assert.Equal(t, 0, st.ActiveCount-st.IdleCount, "no connection in use")
+	}
+
+	atomic.StoreInt32(&moved, 0)
+	c.MaxAttempts = 2
+	v, err = redis.String(c.Do("GET", "a"))
+	if assert.NoError(t, err, "Do with MaxAttempts") {
+		assert.Equal(t, "a", v, "expected result")
+	}
+}
+
+func TestClusterStats(t *testing.T) {
+	var s1, s2 *redistest.MockServer
+	handler := func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return resp.Array{
+				0: resp.Array{0: int64(0), 1: int64(8000), 2: resp.Array{0: "127.0.0.1", 1: int64(mockPort(s1.Addr))}},
+				1: resp.Array{0: int64(8001), 1: int64(16383), 2: resp.Array{0: "127.0.0.1", 1: int64(mockPort(s2.Addr))}},
+			}
+		case "GET":
+			return args[0]
+		}
+		return resp.Error("unexpected command " + cmd)
+	}
+	s1 = redistest.StartMockServer(t, handler)
+	defer s1.Close()
+	s2 = redistest.StartMockServer(t, handler)
+	defer s2.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s1.Addr},
+		CreatePool:   createPool,
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	addr1, addr2 := "127.0.0.1"+s1.Addr, "127.0.0.1"+s2.Addr
+	require.True(t, Slot("b") <= 8000 && Slot("a") > 8000, "keys on different nodes")
+
+	// hold two connections on the first node and one on the second
+	var conns []redis.Conn
+	for _, k := range []string{"b", "b", "a"} {
+		conn := c.Get()
+		defer conn.Close()
+		_, err := conn.Do("GET", k)
+		require.NoError(t, err, "GET")
+		conns = append(conns, conn)
+	}
+
+	stats := c.Stats()
+	assert.Equal(t, 2, stats[addr1].ActiveCount, "first node")
+	assert.Equal(t, 1, stats[addr2].ActiveCount, "second node")
+
+	for _, conn := range conns {
+		conn.Close()
+	}
+	stats = c.Stats()
+	assert.Equal(t, 2, stats[addr1].IdleCount, "first node after close")
+	assert.Equal(t, 1, stats[addr2].IdleCount, "second node after close")
+}
+
+func TestClusterKeyToSlot(t *testing.T) {
+	var s1, s2 *redistest.MockServer
+	handler := func(node string) func(string, ...string) interface{} {
+		return func(cmd string, args ...string) interface{} {
+			switch cmd {
+			case "CLUSTER":
+				return resp.Array{
+					0: resp.Array{0: int64(0), 1: int64(100), 2: resp.Array{0: "127.0.0.1", 1: int64(mockPort(s1.Addr))}},
+					1: resp.Array{0: int64(101), 1: int64(16383), 2: resp.Array{0: "127.0.0.1", 1: int64(mockPort(s2.Addr))}},
+				}
+			case "GET":
+				return node
+			case "MGET":
+				vals := make(resp.Array, len(args))
+				for i := range args {
+					vals[i] = node
+				}
+				return vals
+			}
+			return resp.Error("unexpected command " + cmd)
+		}
+	}
+	s1 = redistest.StartMockServer(t, handler("s1"))
+	defer s1.Close()
+	s2 = redistest.StartMockServer(t, handler("s2"))
+	defer s2.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s1.Addr},
+		KeyToSlot: func(key []byte) int {
+			if string(key) == "invalid" {
+				return hashSlots
+			}
+			return len(key)
+		},
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	// "a" is in slot 15495 with the standard algorithm, served by s2
+	v, err := redis.String(c.Do("GET", "a"))
+	if assert.NoError(t, err, "GET") {
+		assert.Equal(t, "s1", v, "GET routed with KeyToSlot")
+	}
+
+	conn := c.Get()
+	defer conn.Close()
+	require.NoError(t, BindConn(conn, "a", "b"), "Bind keys of the same custom slot")
+	conn2 := c.Get()
+	defer conn2.Close()
+	assert.Error(t, BindConn(conn2, "a", "bb"), "Bind keys of different custom slots")
+
+	vals, err := redis.Strings(c.MGet("a", "bb"))
+	if assert.NoError(t, err, "MGet") {
+		assert.Equal(t, []string{"s1", "s1"}, vals, "MGet routed with KeyToSlot")
+	}
+
+	// an invalid slot is handled as a keyless command
+	_, err = c.Do("GET", "invalid")
+	assert.NoError(t, err, "GET with invalid slot")
+}
+
+func TestClusterReplicaSelector(t *testing.T) {
+	var addrs []string
+	handler := func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return mockClusterSlots(addrs[0], addrs[1:]...)
+		case "READONLY":
+			return resp.SimpleString("OK")
+		}
+		return resp.Error("unexpected command " + cmd)
+	}
+
+	for i := 0; i < 4; i++ {
+		s := redistest.StartMockServer(t, handler)
+		defer s.Close()
+		addrs = append(addrs, s.Addr)
+	}
+
+	var mu sync.Mutex
+	var next int
+	var got []string
+	c := &Cluster{
+		StartupNodes: []string{addrs[0]},
+		ReplicaSelector: func(replicas []string) string {
+			mu.Lock()
+			defer mu.Unlock()
+			got = append(got, strings.Join(replicas, ","))
+			next++
+			return replicas[next%len(replicas)]
+		},
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	for i := 1; i <= 6; i++ {
+		conn, addr, err := c.getConnForSlot(context.Background(), 0, false, true)
+		require.NoError(t, err, "getConnForSlot")
+		assert.Equal(t, addrs[1+i%3], addr, "round-robin replica %d", i)
+		conn.Close()
+	}
+	assert.Len(t, got, 6, "selector calls")
+	assert.Equal(t, strings.Join(addrs[1:], ","), got[0], "selector receives the replicas")
+
+	// the selector is not called for the master
+	conn, addr, err := c.getConnForSlot(context.Background(), 0, false, false)
+	require.NoError(t, err, "getConnForSlot")
+	conn.Close()
+	assert.Equal(t, addrs[0], addr, "master")
+	assert.Len(t, got, 6, "selector calls")
+}
+
+func TestClusterRefreshCooldown(t *testing.T) {
+	var s *redistest.MockServer
+	var refreshes int32
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			if args[0] == "SLOTS" {
+				atomic.AddInt32(&refreshes, 1)
+			}
+			return mockClusterSlots(s.Addr)
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	c := &Cluster{
+		StartupNodes:    []string{s.Addr},
+		RefreshCooldown: 200 * time.Millisecond,
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+	require.Equal(t, int32(1), atomic.LoadInt32(&refreshes), "initial refresh")
+
+	// all automatic refreshes during the cooldown are coalesced
+	for i := 0; i < 10; i++ {
+		c.needsRefresh(nil)
+	}
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&refreshes), "refresh delayed")
+
+	time.Sleep(250 * time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&refreshes), "refresh after cooldown")
+
+	// explicit refreshes are not delayed
+	require.NoError(t, c.Refresh(), "Refresh")
+	assert.Equal(t, int32(3), atomic.LoadInt32(&refreshes), "explicit refresh")
+}
+
+func TestClusterRefreshConcurrent(t *testing.T) {
+	var s *redistest.MockServer
+	var refreshes, fail int32
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			if args[0] == "SLOTS" {
+				atomic.AddInt32(&refreshes, 1)
+			}
+			time.Sleep(100 * time.Millisecond)
+			if atomic.LoadInt32(&fail) == 1 {
+				return resp.Error("ERR failed")
+			}
+			return mockClusterSlots(s.Addr)
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s.Addr},
+	}
+	defer c.Close()
+
+	refreshAll := func() []error {
+		errs := make([]error, 10)
+		var wg sync.WaitGroup
+		wg.Add(len(errs))
+		for i := range errs {
+			go func(i int) {
+				defer wg.Done()
+				errs[i] = c.Refresh()
+			}(i)
+		}
+		wg.Wait()
+		return errs
+	}
+
+	for _, err := range refreshAll() {
+		assert.NoError(t, err, "Refresh")
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&refreshes), "single refresh")
+	assert.Empty(t, c.UnassignedSlots(), "mapping")
+
+	atomic.StoreInt32(&fail, 1)
+	errs := refreshAll()
+	assert.Error(t, errs[0], "Refresh")
+	for _, err := range errs {
+		assert.Equal(t, errs[0], err, "same error")
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&refreshes), "single failed refresh")
+
+	// a caller waiting for the refresh in progress returns when its context
+	// is done
+	atomic.StoreInt32(&fail, 0)
+	c.needsRefresh(nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, c.RefreshContext(ctx), "RefreshContext")
+	require.NoError(t, c.Refresh(), "Refresh")
+	assert.Equal(t, int32(3), atomic.LoadInt32(&refreshes), "background refresh")
+}
+
+func TestClusterRefreshJitter(t *testing.T) {
+	var s *redistest.MockServer
+	var refreshes int32
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			if args[0] == "SLOTS" {
+				atomic.AddInt32(&refreshes, 1)
+			}
+			return mockClusterSlots(s.Addr)
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	c := &Cluster{
+		StartupNodes:  []string{s.Addr},
+		RefreshJitter: 100 * time.Millisecond,
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+	require.Equal(t, int32(1), atomic.LoadInt32(&refreshes), "initial refresh")
+
+	// all automatic refreshes during the random delay are coalesced, and
+	// the refresh happens within the jitter window
+	for i := 0; i < 10; i++ {
+		c.needsRefresh(nil)
+	}
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&refreshes), "refresh after jitter")
+}
+
+// startSilentServer starts a server that accepts connections but never
+// replies. The closed channel receives a value when a client closes its
+// connection.
+func startSilentServer(t *testing.T) (l net.Listener, closed <-chan struct{}) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err, "net.Listen")
+
+	ch := make(chan struct{}, 10)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				// read until the client closes the connection
+				buf := make([]byte, 512)
+				for {
+					if _, err := conn.Read(buf); err != nil {
+						ch <- struct{}{}
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return l, ch
+}
+
+func TestClusterRefreshSlowNode(t *testing.T) {
+	slow, closed := startSilentServer(t)
+	defer slow.Close()
+
+	var fast *redistest.MockServer
+	fast = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return mockClusterSlots(fast.Addr)
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer fast.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{slow.Addr().String(), fast.Addr},
+	}
+	defer c.Close()
+
+	start := time.Now()
+	require.NoError(t, c.Refresh(), "Refresh")
+	assert.True(t, time.Since(start) < 500*time.Millisecond, "refresh not delayed by slow node")
+
+	c.mu.Lock()
+	addrs := c.mapping[0]
+	c.mu.Unlock()
+	assert.Equal(t, []string{fast.Addr}, addrs, "mapping")
+
+	// the connection to the slow node is closed
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("connection to slow node not closed")
+	}
+}
+
+func TestClusterRefreshTimeout(t *testing.T) {
+	slow, _ := startSilentServer(t)
+	defer slow.Close()
+
+	failing := redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		return resp.Error("ERR failed")
+	})
+	defer failing.Close()
+
+	c := &Cluster{
+		StartupNodes:   []string{slow.Addr().String(), failing.Addr},
+		RefreshTimeout: 50 * time.Millisecond,
+	}
+	defer c.Close()
+
+	start := time.Now()
+	assert.Error(t, c.Refresh(), "Refresh")
+	assert.True(t, time.Since(start) < 500*time.Millisecond, "refresh bounded by RefreshTimeout")
+}
+
+func TestClusterRefreshContext(t *testing.T) {
+	slow, closed := startSilentServer(t)
+	defer slow.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{slow.Addr().String()},
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	assert.Equal(t, context.DeadlineExceeded, c.RefreshContext(ctx), "RefreshContext")
+	assert.True(t, time.Since(start) < 500*time.Millisecond, "refresh bounded by the context")
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("connection not closed")
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, c.RefreshContext(ctx), "RefreshContext with cancelled context")
+}
+
+func TestClusterRefreshMaxQueries(t *testing.T) {
+	var mu sync.Mutex
+	var active, maxActive, queries int
+	handler := func(cmd string, args ...string) interface{} {
+		mu.Lock()
+		active++
+		if args[0] == "SLOTS" {
+			queries++
+		}
+		if active > maxActive {
+			maxActive = active
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		active--
+		mu.Unlock()
+		return resp.Error("ERR failed")
+	}
+
+	var addrs []string
+	for i := 0; i < 2*maxRefreshQueries; i++ {
+		s := redistest.StartMockServer(t, handler)
+		defer s.Close()
+		addrs = append(addrs, s.Addr)
+	}
+
+	c := &Cluster{StartupNodes: addrs}
+	defer c.Close()
+	assert.Error(t, c.Refresh(), "Refresh")
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, len(addrs), queries, "all nodes queried")
+	assert.True(t, maxActive <= maxRefreshQueries, "at most %d concurrent queries, got %d", maxRefreshQueries, maxActive)
+}
+
+func TestClusterNodeDialOptions(t *testing.T) {
+	var s1, s2 *redistest.MockServer
+	var mu sync.Mutex
+	auths := make(map[string][]string)
+	handler := func(addr *string) func(string, ...string) interface{} {
+		return func(cmd string, args ...string) interface{} {
+			switch cmd {
+			case "AUTH":
+				mu.Lock()
+				auths[*addr] = append(auths[*addr], args[0])
+				mu.Unlock()
+				return resp.SimpleString("OK")
+			case "CLUSTER":
+				return resp.Array{
+					0: resp.Array{0: int64(0), 1: int64(8000), 2: resp.Array{0: "127.0.0.1", 1: int64(mockPort(s1.Addr))}},
+					1: resp.Array{0: int64(8001), 1: int64(16383), 2: resp.Array{0: "127.0.0.1", 1: int64(mockPort(s2.Addr))}},
+				}
+			case "GET":
+				return *addr
+			}
+			return resp.Error("unexpected command " + cmd)
+		}
+	}
+	var addr1, addr2 string
+	s1 = redistest.StartMockServer(t, handler(&addr1))
+	defer s1.Close()
+	s2 = redistest.StartMockServer(t, handler(&addr2))
+	defer s2.Close()
+	addr1, addr2 = "127.0.0.1"+s1.Addr, "127.0.0.1"+s2.Addr
+
+	c := &Cluster{
+		StartupNodes: []string{addr1},
+		DialOptions:  []redis.DialOption{redis.DialPassword("global")},
+		NodeDialOptions: func(addr string) []redis.DialOption {
+			if addr == addr2 {
+				return []redis.DialOption{redis.DialPassword("node")}
+			}
+			return nil
+		},
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	// "a" is in slot 15495, "b" in slot 3300
+	v, err := redis.String(c.Do("GET", "a"))
+	require.NoError(t, err, "GET a")
+	assert.Equal(t, addr2, v, "node of a")
+	v, err = redis.String(c.Do("GET", "b"))
+	require.NoError(t, err, "GET b")
+	assert.Equal(t, addr1, v, "node of b")
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, pwd := range auths[addr1] {
+		assert.Equal(t, "global", pwd, "global password")
+	}
+	if assert.NotEmpty(t, auths[addr2], "AUTH on node") {
+		for _, pwd := range auths[addr2] {
+			assert.Equal(t, "node", pwd, "node password")
+		}
+	}
+}
+
+// mockPort returns the port number of the mock server's address.
+func mockPort(addr string) int {
+	_, port, _ := net.SplitHostPort(addr)
+	nPort, _ := strconv.Atoi(port)
+	return nPort
+}
+
+func TestClusterRefreshHostname(t *testing.T) {
+	var s *redistest.MockServer
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch {
+		case cmd == "CLUSTER" && args[0] == "SHARDS":
+			return resp.Error("ERR unknown subcommand 'SHARDS'")
+		case cmd == "CLUSTER" && args[0] == "SLOTS":
+			return resp.Array{
+				0: resp.Array{0: int64(0), 1: int64(16383),
+					2: resp.Array{0: "127.0.0.1", 1: int64(mockPort(s.Addr)), 2: "id1", 3: resp.Array{"hostname", "node1.example.com"}},
+					3: resp.Array{0: "127.0.0.1", 1: int64(7001), 2: "id2"},
+					4: resp.Array{0: "127.0.0.1", 1: int64(7002)},
+				},
+			}
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	c := &Cluster{StartupNodes: []string{s.Addr}}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	nodes := c.Nodes()
+	require.Len(t, nodes, 3, "nodes")
+	master := "127.0.0.1" + s.Addr
+	for _, n := range nodes {
+		switch n.Addr {
+		case master:
+			assert.Equal(t, "id1", n.ID, "master ID")
+			assert.Equal(t, "node1.example.com", n.Hostname, "master hostname")
+		case "127.0.0.1:7001":
+			assert.Equal(t, "id2", n.ID, "replica ID")
+			assert.Equal(t, "", n.Hostname, "replica hostname")
+		case "127.0.0.1:7002":
+			assert.Equal(t, "", n.ID, "replica without ID")
+		default:
+			t.Errorf("unexpected node %s", n.Addr)
+		}
+	}
+}
+
+func TestClusterHostnameTLSConfig(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err, "net.Listen")
+	defer l.Close()
+
+	// the server only records the SNI sent by the client, and aborts the
+	// handshake.
+	sni := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		tconn := tls.Server(conn, &tls.Config{
+			GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+				sni <- hello.ServerName
+				return nil, errors.New("abort")
+			},
+		})
+		tconn.Handshake()
+	}()
+
+	addr := l.Addr().String()
+	c := &Cluster{
+		DialOptions:       []redis.DialOption{redis.DialUseTLS(true)},
+		HostnameTLSConfig: &tls.Config{InsecureSkipVerify: true},
+	}
+	defer c.Close()
+	c.nodeInfos = map[string]nodeInfo{addr: {hostname: "node1.example.com"}}
+
+	_, err = c.getConnForAddr(context.Background(), addr, true)
+	assert.Error(t, err, "handshake aborted")
+	select {
+	case name := <-sni:
+		assert.Equal(t, "node1.example.com", name, "SNI")
+	case <-time.After(time.Second):
+		t.Fatal("no TLS handshake")
+	}
+}
+
+func TestClusterCmdSlotKeyless(t *testing.T) {
+	c := &Cluster{}
+	assert.Equal(t, 15495, c.cmdSlot("GET", []interface{}{"a"}), "GET")
+	assert.Equal(t, -1, c.cmdSlot("GET", nil), "GET without key")
+	for _, cmd := range []string{"PING", "info", "Config", "TIME", "RANDOMKEY"} {
+		assert.Equal(t, -1, c.cmdSlot(cmd, []interface{}{"a"}), cmd)
+	}
+}
+
+func TestClusterCreatePoolContext(t *testing.T) {
+	var s *redistest.MockServer
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return mockClusterSlots(s.Addr)
+		case "GET":
+			return "v"
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	type ctxKey struct{}
+	var got []interface{}
+	c := &Cluster{
+		StartupNodes: []string{s.Addr},
+		CreatePoolContext: func(ctx context.Context, addr string, opts ...redis.DialOption) (*redis.Pool, error) {
+			got = append(got, ctx.Value(ctxKey{}))
+			return createPool(addr, opts...)
+		},
+	}
+	defer c.Close()
+
+	// the pool is created by the refresh
+	ctx := context.WithValue(context.Background(), ctxKey{}, "trace")
+	require.NoError(t, c.RefreshContext(ctx), "RefreshContext")
+
+	conn, err := c.GetContext(context.Background())
+	require.NoError(t, err, "GetContext")
+	defer conn.Close()
+
+	v, err := redis.String(conn.Do("GET", "a"))
+	require.NoError(t, err, "GET")
+	assert.Equal(t, "v", v, "GET")
+	assert.Equal(t, []interface{}{"trace"}, got, "pool created with the context")
+	assert.Len(t, c.Stats(), 1, "pool stats")
+}
+
+func TestClusterNodeErrors(t *testing.T) {
+	var s *redistest.MockServer
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return mockClusterSlots(s.Addr)
+		case "GET":
+			return "v"
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	var fail int32 = 1
+	c := &Cluster{
+		StartupNodes: []string{s.Addr},
+		CreatePool: func(addr string, opts ...redis.DialOption) (*redis.Pool, error) {
+			if atomic.LoadInt32(&fail) == 1 {
+				return nil, errors.New("bad TLS config")
+			}
+			return createPool(addr, opts...)
+		},
+	}
+	defer c.Close()
+
+	assert.Empty(t, c.NodeErrors(), "no error before refresh")
+	require.NoError(t, c.Refresh(), "Refresh")
+	errs := c.NodeErrors()
+	if assert.Len(t, errs, 1, "errors after refresh") {
+		assert.EqualError(t, errs[s.Addr], "bad TLS config", "node error")
+	}
+	assert.Empty(t, c.Stats(), "no pool")
+
+	atomic.StoreInt32(&fail, 0)
+	v, err := redis.String(c.Do("GET", "a"))
+	require.NoError(t, err, "GET")
+	assert.Equal(t, "v", v, "GET")
+	assert.Empty(t, c.NodeErrors(), "error cleared")
+	assert.Len(t, c.Stats(), 1, "pool created")
+}
+
+func TestClusterRefreshNotReady(t *testing.T) {
+	var s *redistest.MockServer
+	var assigned int32
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			if atomic.LoadInt32(&assigned) == 0 {
+				// slots are not assigned yet
+				return resp.Array{}
+			}
+			return mockClusterSlots(s.Addr)
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	for _, quorum := range []int{0, 2} {
+		atomic.StoreInt32(&assigned, 0)
+		c := &Cluster{
+			StartupNodes:  []string{s.Addr},
+			RefreshQuorum: quorum,
+		}
+
+		err := c.Refresh()
+		assert.Equal(t, ErrClusterNotReady, err, "Refresh before slot assignment, quorum %d", quorum)
+		assert.Len(t, c.UnassignedSlots(), hashSlots, "no mapping")
+		assert.Equal(t, []string{s.Addr}, c.getNodeAddrs(false), "startup nodes kept")
+
+		atomic.StoreInt32(&assigned, 1)
+		require.NoError(t, c.Refresh(), "Refresh after slot assignment")
+		assert.Empty(t, c.UnassignedSlots(), "mapping")
+		c.Close()
+	}
+}
+
+func TestClusterRefreshAddrs(t *testing.T) {
+	c := &Cluster{
+		StartupNodes:   []string{"c:1", "a:1", "b:1"},
+		OrderedRefresh: true,
+	}
+	assert.Equal(t, []string{"c:1", "a:1", "b:1"}, c.refreshAddrs(), "startup nodes order")
+
+	c.mu.Lock()
+	c.masters = map[string]bool{"b:1": true, "z:1": true, "d:1": true, "c:1": true}
+	c.replicas = map[string]bool{"y:1": true, "a:1": true, "x:1": true}
+	c.mu.Unlock()
+	want := []string{"c:1", "b:1", "d:1", "z:1", "a:1", "x:1", "y:1"}
+	assert.Equal(t, want, c.refreshAddrs(), "ordered")
+
+	c.OrderedRefresh = false
+	firsts := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		addrs := c.refreshAddrs()
+		assert.ElementsMatch(t, want[:4], addrs[:4], "masters first")
+		assert.ElementsMatch(t, want[4:], addrs[4:], "replicas last")
+		firsts[addrs[0]] = true
+	}
+	assert.True(t, len(firsts) > 1, "shuffled")
+}
+
+func TestClusterReadTimeout(t *testing.T) {
+	var s *redistest.MockServer
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return mockClusterSlots(s.Addr)
+		case "GET":
+			time.Sleep(100 * time.Millisecond)
+			return "v"
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s.Addr},
+		ReadTimeout:  20 * time.Millisecond,
+		WriteTimeout: time.Second,
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	_, err := c.Do("GET", "a")
+	if assert.Error(t, err, "GET with read timeout") {
+		var ne net.Error
+		if assert.True(t, errors.As(err, &ne), "net error") {
+			assert.True(t, ne.Timeout(), "timeout")
+		}
+	}
+
+	// overridden for a single command
+	conn := c.Get()
+	defer conn.Close()
+	v, err := redis.String(redis.DoWithTimeout(conn, time.Second, "GET", "a"))
+	if assert.NoError(t, err, "GET with command timeout") {
+		assert.Equal(t, "v", v, "GET")
+	}
+
+	// overridden by the dial options
+	c2 := &Cluster{
+		StartupNodes: []string{s.Addr},
+		ReadTimeout:  20 * time.Millisecond,
+		DialOptions:  []redis.DialOption{redis.DialReadTimeout(time.Second)},
+	}
+	defer c2.Close()
+	require.NoError(t, c2.Refresh(), "Refresh")
+	v, err = redis.String(c2.Do("GET", "a"))
+	if assert.NoError(t, err, "GET with dial option") {
+		assert.Equal(t, "v", v, "GET")
+	}
+}
+
+func TestNormalizeAddr(t *testing.T) {
+	cases := []struct {
+		in, out string
+	}{
+		{"", ""},
+		{"invalid", "invalid"},
+		{":6379", ":6379"},
+		{" 127.0.0.1:6379 ", "127.0.0.1:6379"},
+		{"[0:0::1]:6379", "[::1]:6379"},
+		{"[::ffff:10.0.0.1]:6379", "10.0.0.1:6379"},
+		{"Redis-1.Example.COM:6379", "redis-1.example.com:6379"},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.out, normalizeAddr(c.in), "%q", c.in)
+	}
+}
+
+func TestClusterDuplicateStartupNodes(t *testing.T) {
+	c := &Cluster{
+		StartupNodes:   []string{"127.0.0.1:6379", " 127.0.0.1:6379", "[0:0::1]:6379", "[::1]:6379", "127.0.0.1:6379"},
+		OrderedRefresh: true,
+	}
+	defer c.Close()
+
+	assert.Equal(t, []string{"127.0.0.1:6379", "[::1]:6379"}, c.refreshAddrs(), "refresh addresses")
+}
@@ -1,29 +1,44 @@
 package redisc
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/garyburd/redigo/redis"
 )
 
 var _ redis.Conn = (*Conn)(nil)
+var _ redis.ConnWithTimeout = (*Conn)(nil)
+
+// ErrAlreadyBound is the error returned when trying to bind a connection
+// (or to make it read-only) that is already bound to a node.
+var ErrAlreadyBound = errors.New("redisc: connection already bound to a node")
 
 // Conn is a redis cluster connection. When returned by Get
 // or Dial, it is not yet bound to any node in the cluster.
-// Only when a call to Do, Send, Receive or Bind is made is a connection
-// to a specific node established:
+// Only when a call to Do, Send, Receive, Bind or BindSlot is made is a
+// connection to a specific node established:
 //
-//     - if Do or Send is called first, the command's first parameter
-//       is assumed to be the key, and its slot is used to find the node
+//     - if Do or Send is called first, the slot of the command's first
+//       key (usually its first parameter, see Cluster.CommandKeys) is
+//       used to find the node
 //     - if Receive is called first, or if Do or Send is called first
-//       but with no parameter for the command (or no command), a
-//       random node is selected in the cluster
+//       but with no parameter for the command (or no command), or with
+//       a command that has no key (e.g. PING, INFO, TIME or RANDOMKEY),
+//       a random master is selected in the cluster, favouring the nodes
+//       that are not marked unavailable (a random replica if the
+//       connection is read-only)
 //     - if Bind is called first, the node corresponding to the slot of
 //       the specified key(s) is selected
+//     - if BindSlot is called first, the node corresponding to the
+//       specified slot is selected
+//     - if BindAddr is called first, the node at the specified address
+//       is selected
 //
 // Because Get and Dial return a redis.Conn interface,
 // a type assertion can be used to call Bind or ReadOnly on this
@@ -38,18 +53,30 @@ var _ redis.Conn = (*Conn)(nil)
 //
 // Or call the package-level BindConn or ReadOnlyConn helper functions.
 //
+// Once bound, the connection keeps using the same node connection for
+// all subsequent calls, regardless of the keys used in the commands, so
+// that a sequence of commands on keys of the same slot never re-resolves
+// the slot nor re-dials the node. Only a RetryConn (or a connection of a
+// cluster with FollowRedirects set) following a MOVED redirection may
+// rebind it to a different node, or a connection for which RebindOnMoved
+// was called when the cluster is resharded. A call to Unbind releases the
+// node connection, so that the connection can be bound again.
+//
 type Conn struct {
 	cluster   *Cluster
-	forceDial bool // immutable
+	forceDial bool            // immutable
+	ctx       context.Context // immutable, context to get the node connections, set by GetContext
 
 	// redigo allows concurrent reader and writer (conn.Receive and
 	// conn.Send/conn.Flush), a mutex is needed to protect concurrent
 	// accesses.
-	mu        sync.Mutex
-	readOnly  bool
-	boundAddr string
-	err       error
-	rc        redis.Conn
+	mu            sync.Mutex
+	readOnly      bool
+	rebindOnMoved bool
+	boundAddr     string
+	lastAddr      string // node that executed the last command, see DoRouted
+	err           error
+	rc            redis.Conn
 }
 
 // RedirError is a cluster redirection error. It indicates that
@@ -96,11 +123,46 @@ func IsCrossSlot(err error) bool {
 	return isRedisErr(err, "CROSSSLOT")
 }
 
-// ParseRedir parses err into a RedirError. If err is
-// not a MOVED or ASK error or if it is nil, it returns nil.
+// IsClusterDown returns true if the error is a redis cluster
+// error of type CLUSTERDOWN, meaning that the cluster is not
+// able to serve requests, e.g. because some slots are not served
+// or because the node is in a minority partition. This is usually
+// a transient state during a failover. Unlike for a MOVED error,
+// refreshing the mapping does not help, the command must be retried
+// after some time.
+func IsClusterDown(err error) bool {
+	return isRedisErr(err, "CLUSTERDOWN")
+}
+
+// IsLoading returns true if the error is a redis error of type
+// LOADING, meaning that the node is still loading its dataset in memory
+// (e.g. after a restart) and can't serve the request yet. The command was
+// not executed, it can be retried on the same node after some time.
+func IsLoading(err error) bool {
+	return isRedisErr(err, "LOADING")
+}
+
+// IsMasterDown returns true if the error is a redis error of type
+// MASTERDOWN, meaning that the replica that received the command lost
+// its link with its master and is configured to not serve stale data.
+// The command was not executed, it can be retried on the same node after
+// some time.
+func IsMasterDown(err error) bool {
+	return isRedisErr(err, "MASTERDOWN")
+}
+
+// ParseRedir parses err into a RedirError, so that the kind of
+// redirection, its slot and the address of its node can be used e.g. to
+// implement custom routing. If err is not a MOVED or ASK error or if it
+// is nil, it returns nil. The error may be wrapped (see errors.As), and
+// it may already be a *RedirError, in which case it is returned as-is.
 func ParseRedir(err error) *RedirError {
-	re, ok := err.(redis.Error)
-	if !ok {
+	var redir *RedirError
+	if errors.As(err, &redir) {
+		return redir
+	}
+	var re redis.Error
+	if !errors.As(err, &re) {
 		return nil
 	}
 	parts := strings.Fields(re.Error())
@@ -123,12 +185,12 @@ func ParseRedir(err error) *RedirError {
 // or a random node if slot is -1, iff the connection is not broken
 // and is not already bound. It returns the redis conn, true if it
 // successfully bound to this slot, or any error.
-func (c *Conn) bind(slot int) (rc redis.Conn, ok bool, err error) {
+func (c *Conn) bind(ctx context.Context, slot int) (rc redis.Conn, ok bool, err error) {
 	c.mu.Lock()
 	rc, err = c.rc, c.err
 	if err == nil {
 		if rc == nil {
-			conn, addr, err2 := c.cluster.getConn(slot, c.forceDial, c.readOnly)
+			conn, addr, err2 := c.cluster.getConn(ctx, slot, c.forceDial, c.readOnly)
 			if err2 != nil {
 				err = err2
 			} else {
@@ -142,13 +204,13 @@ func (c *Conn) bind(slot int) (rc redis.Conn, ok bool, err error) {
 	return rc, ok, err
 }
 
-func cmdSlot(cmd string, args []interface{}) int {
-	slot := -1
-	if len(args) > 0 {
-		key := fmt.Sprintf("%s", args[0])
-		slot = Slot(key)
+// bindContext returns the context to use to get the node connections,
+// when no other context is provided.
+func (c *Conn) bindContext() context.Context {
+	if c.ctx != nil {
+		return c.ctx
 	}
-	return slot
+	return context.Background()
 }
 
 // BindConn is a convenience function that checks if c implements
@@ -170,26 +232,105 @@ func BindConn(c redis.Conn, keys ...string) error {
 // If the connection is already bound, an error is returned.
 // If no key is provided, it binds to a random node.
 func (c *Conn) Bind(keys ...string) error {
+	if err := c.cluster.CheckSameSlot(keys...); err != nil {
+		return err
+	}
 	slot := -1
-	for _, k := range keys {
-		ks := Slot(k)
-		if slot != -1 && ks != slot {
-			return errors.New("redisc: keys do not belong to the same slot")
-		}
-		slot = ks
+	if len(keys) > 0 {
+		slot = c.cluster.slot(keys[0])
+	}
+
+	_, ok, err := c.bind(c.bindContext(), slot)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		// was already bound
+		return ErrAlreadyBound
+	}
+	return nil
+}
+
+// BindSlot binds the connection to the cluster node that serves the
+// specified hash slot, which must be between 0 and 16383. If the
+// connection is already bound, an error is returned.
+func (c *Conn) BindSlot(slot int) error {
+	if slot < 0 || slot >= hashSlots {
+		return fmt.Errorf("redisc: invalid slot %d", slot)
 	}
 
-	_, ok, err := c.bind(slot)
+	_, ok, err := c.bind(c.bindContext(), slot)
 	if err != nil {
 		return err
 	}
 	if !ok {
 		// was already bound
-		return errors.New("redisc: connection already bound to a node")
+		return ErrAlreadyBound
 	}
 	return nil
 }
 
+// BindAddr binds the connection to the node at addr, which does not have
+// to serve any slot (e.g. it may be a replica), to run commands without
+// a key such as INFO or CONFIG on that specific node. If the connection
+// is already bound, an error is returned. Commands with a key sent on a
+// connection bound this way may fail with a MOVED error.
+func (c *Conn) BindAddr(addr string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return c.err
+	}
+	if c.rc != nil {
+		return ErrAlreadyBound
+	}
+	rc, err := c.cluster.getConnForAddr(c.bindContext(), addr, c.forceDial)
+	if err != nil {
+		return err
+	}
+	if c.readOnly {
+		rc.Do("READONLY")
+	}
+	c.rc, c.boundAddr = rc, addr
+	return nil
+}
+
+// Unbind releases the node connection the connection is bound to (it is
+// returned to its pool, if any), so that the connection can be bound
+// again, e.g. with Bind or BindSlot to a different slot, or by the next
+// call to Do, Send or Receive, following the same rules as for a new
+// connection. A read-only connection stays read-only. It does nothing if
+// the connection is not bound. Because the next call needs to get a
+// connection to the node again (from its pool, or by dialing a new one),
+// it should only be used to switch to another slot, not between commands
+// on the same slot. Pending replies of pipelined commands (see Send) are
+// lost.
+func (c *Conn) Unbind() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return c.err
+	}
+	var err error
+	if c.rc != nil {
+		err = c.closeLocked()
+		c.rc, c.boundAddr = nil, ""
+	}
+	return err
+}
+
+// BoundAddr returns the address of the node the connection is bound to,
+// and true if it is bound. If the connection is not bound yet, it returns
+// an empty address and false. The address may change when a RetryConn
+// follows a MOVED redirection, or if RebindOnMoved was called.
+func (c *Conn) BoundAddr() (addr string, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.boundAddr, c.rc != nil
+}
+
 // ReadOnlyConn is a convenience function that checks if c implements
 // a ReadOnly method with the right signature such as the one for
 // a *Conn, and calls that method. If c doesn't implement that
@@ -222,40 +363,448 @@ func (c *Conn) ReadOnly() error {
 	}
 	if c.rc != nil {
 		// was already bound
-		return errors.New("redisc: connection already bound to a node")
+		return ErrAlreadyBound
 	}
 	c.readOnly = true
 	return nil
 }
 
+// IsReadOnly returns true if the connection is marked as read-only by a
+// call to ReadOnly, meaning that it is (or will be) bound to a replica.
+// A read-only connection bound to a replica automatically sends
+// READWRITE when it is closed, so that a pooled node connection is never
+// returned to its pool in read-only mode.
+func (c *Conn) IsReadOnly() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.readOnly
+}
+
+// ReadWriteConn is a convenience function that checks if c implements
+// a ReadWrite method with the right signature such as the one for
+// a *Conn, and calls that method. If c doesn't implement that
+// method, it returns an error.
+func ReadWriteConn(c redis.Conn) error {
+	if cc, ok := c.(interface {
+		ReadWrite() error
+	}); ok {
+		return cc.ReadWrite()
+	}
+	return errors.New("redisc: no ReadWrite method")
+}
+
+// ReadWrite reverts a call to ReadOnly, so that the connection is used
+// for the master of its slot again. If the connection is not bound yet,
+// it only clears the read-only flag. If it is already bound to a
+// replica, the READWRITE command is sent to that replica and its node
+// connection is released (returned to its pool, if any), so that the
+// next call binds the connection to the master of the command's slot.
+// It does nothing if the connection is not read-only.
+// See http://redis.io/commands/readwrite for more details.
+func (c *Conn) ReadWrite() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return c.err
+	}
+	if !c.readOnly {
+		return nil
+	}
+	var err error
+	if c.rc != nil {
+		err = c.closeLocked()
+		c.rc, c.boundAddr = nil, ""
+	}
+	c.readOnly = false
+	return err
+}
+
+// Wait sends a WAIT command to the master the connection is bound to, and
+// returns the number of replicas that acknowledged the writes previously
+// executed on that connection, as reported by redis. It blocks until
+// numReplicas replicas acknowledged the writes or until timeout expires.
+// A timeout of 0 blocks forever. See http://redis.io/commands/wait for
+// more details.
+//
+// Because WAIT applies to the writes of the current node connection, the
+// connection must already be bound to a master, typically by the write
+// commands themselves. An error is returned if it is not bound yet or if
+// it is a read-only connection.
+func (c *Conn) Wait(numReplicas int, timeout time.Duration) (int, error) {
+	c.mu.Lock()
+	rc, err, readOnly := c.rc, c.err, c.readOnly
+	c.mu.Unlock()
+
+	if err != nil {
+		return 0, err
+	}
+	if rc == nil {
+		return 0, errors.New("redisc: connection not bound to a node")
+	}
+	if readOnly {
+		return 0, errors.New("redisc: WAIT on a read-only connection")
+	}
+	return redis.Int(c.do(rc, "WAIT", numReplicas, int64(timeout/time.Millisecond)))
+}
+
 // Do sends a command to the server and returns the received reply.
 // If the connection is not yet bound to a cluster node, it will be
 // after this call, based on the rules documented in the Conn type.
 func (c *Conn) Do(cmd string, args ...interface{}) (interface{}, error) {
-	rc, _, err := c.bind(cmdSlot(cmd, args))
+	if ok, v, err := c.cluster.guardSelect(cmd, args); ok {
+		return v, err
+	}
+	rc, _, err := c.bind(c.bindContext(), c.cluster.cmdSlot(cmd, args))
 	if err != nil {
 		return nil, err
 	}
-	v, err := rc.Do(cmd, args...)
+	return c.doFollow(rc, cmd, args...)
+}
+
+// DoRouted is like Do, but it also returns the address of the node that
+// executed the command and produced the reply, after following the
+// redirections if any (see FollowRedirects), e.g. to correlate the
+// commands with the logs of the nodes. The address is empty if the
+// command was not executed by a node (e.g. if the connection could not
+// be bound).
+func (c *Conn) DoRouted(cmd string, args ...interface{}) (reply interface{}, addr string, err error) {
+	return c.routed(func() (interface{}, error) {
+		return c.Do(cmd, args...)
+	})
+}
+
+// routed calls fn and returns its results along with the address of the
+// node that executed the last command sent by fn.
+func (c *Conn) routed(fn func() (interface{}, error)) (interface{}, string, error) {
+	c.mu.Lock()
+	c.lastAddr = ""
+	c.mu.Unlock()
+
+	v, err := fn()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return v, c.lastAddr, err
+}
+
+// DoWithTimeout is like Do, but it uses the provided read timeout for
+// that command instead of the one of the node connection, as configured
+// with redis.DialReadTimeout. A timeout of 0 means that the command waits
+// for its reply indefinitely. It makes Conn implement
+// redis.ConnWithTimeout, so that redis.DoWithTimeout can be used.
+func (c *Conn) DoWithTimeout(timeout time.Duration, cmd string, args ...interface{}) (interface{}, error) {
+	if ok, v, err := c.cluster.guardSelect(cmd, args); ok {
+		return v, err
+	}
+	rc, _, err := c.bind(c.bindContext(), c.cluster.cmdSlot(cmd, args))
+	if err != nil {
+		return nil, err
+	}
+	return c.doFollowTimeout(rc, timeout, cmd, args...)
+}
+
+// DoWithKeys is like Do, but the slot used to bind the connection is
+// computed from keys instead of the first argument of the command, for
+// the commands where the keys are not (or not only) at that position,
+// e.g. EVAL, SORT ... STORE or GEORADIUS ... STORE. The keys must all
+// belong to the same slot, otherwise an error wrapping ErrCrossSlot is
+// returned and the command is not executed. The keys are only used to
+// route the command, they are not added to args. If no key is provided,
+// it binds to a random node. As for Do, the keys are ignored if the
+// connection is already bound.
+func (c *Conn) DoWithKeys(keys []string, cmd string, args ...interface{}) (interface{}, error) {
+	if ok, v, err := c.cluster.guardSelect(cmd, args); ok {
+		return v, err
+	}
+	if err := c.cluster.CheckSameSlot(keys...); err != nil {
+		return nil, err
+	}
+	slot := -1
+	if len(keys) > 0 {
+		slot = c.cluster.slot(keys[0])
+	}
+	rc, _, err := c.bind(c.bindContext(), slot)
+	if err != nil {
+		return nil, err
+	}
+	return c.doFollow(rc, cmd, args...)
+}
+
+// defaultTimeout is the timeout passed to doTimeout to use the read
+// timeout of the node connection.
+const defaultTimeout = time.Duration(-1)
+
+func (c *Conn) do(rc redis.Conn, cmd string, args ...interface{}) (interface{}, error) {
+	return c.doTimeout(rc, defaultTimeout, cmd, args...)
+}
+
+// doTimeout executes the command on rc with the read timeout, or with the
+// read timeout of rc if timeout is defaultTimeout.
+func (c *Conn) doTimeout(rc redis.Conn, timeout time.Duration, cmd string, args ...interface{}) (interface{}, error) {
+	if c.cluster.WarnCrossSlot {
+		c.cluster.warnCrossSlot(cmd, args)
+	}
+	timed := c.cluster.Observer != nil || c.cluster.LatencyAwareReplicas
+	var start time.Time
+	if timed {
+		start = time.Now()
+	}
+	var v interface{}
+	var err error
+	if timeout == defaultTimeout {
+		v, err = rc.Do(cmd, args...)
+	} else {
+		v, err = redis.DoWithTimeout(rc, timeout, cmd, args...)
+	}
+	c.mu.Lock()
+	addr := c.boundAddr
+	c.lastAddr = addr
+	c.mu.Unlock()
+	if timed {
+		if c.cluster.Observer != nil {
+			c.cluster.observeCommand(cmd, args, addr, start, err)
+		}
+		if _, ok := err.(redis.Error); c.cluster.LatencyAwareReplicas && (err == nil || ok) {
+			c.cluster.recordLatency(addr, time.Since(start))
+		}
+	}
 
 	// handle redirections, if any
 	if re := ParseRedir(err); re != nil {
 		if re.Type == "MOVED" {
 			c.cluster.needsRefresh(re)
+			c.rebind(rc, re)
 		}
 	}
 
+	if err == nil && c.cluster.VerifySlotsRate > 0 && len(args) > 0 {
+		if verr := c.cluster.verifySlot(rc, args[0]); verr != nil {
+			return nil, verr
+		}
+	}
 	return v, err
 }
 
+// doFollow calls do and, if the cluster's FollowRedirects field is set,
+// follows a single MOVED or ASK redirection returned by that call.
+func (c *Conn) doFollow(rc redis.Conn, cmd string, args ...interface{}) (interface{}, error) {
+	return c.doFollowTimeout(rc, defaultTimeout, cmd, args...)
+}
+
+// doFollowTimeout is like doFollow, but it executes the command with the
+// read timeout (see doTimeout).
+func (c *Conn) doFollowTimeout(rc redis.Conn, timeout time.Duration, cmd string, args ...interface{}) (interface{}, error) {
+	if !c.cluster.FollowRedirects {
+		return c.doTimeout(rc, timeout, cmd, args...)
+	}
+	if re := c.cluster.cachedAsk(cmd, args); re != nil {
+		// the key is being migrated, see AskCacheTTL
+		if v, err := c.doAsking(re, cmd, args...); !askFailed(err) {
+			return v, err
+		}
+	}
+
+	v, err := c.doTimeout(rc, timeout, cmd, args...)
+	re := ParseRedir(err)
+	if re == nil {
+		return v, err
+	}
+
+	if re.Type == "ASK" {
+		c.cluster.logf("redisc: %s redirection for slot %d to %s", re.Type, re.NewSlot, re.Addr)
+		return c.doAsking(re, cmd, args...)
+	}
+	if rc, err = c.followMoved(re); err != nil {
+		return nil, err
+	}
+	return c.doTimeout(rc, timeout, cmd, args...)
+}
+
+// followMoved binds the connection to the node that serves the slot of
+// the MOVED redirection re, releasing its current node connection. A
+// read-only connection already bound to a node of that slot is bound to
+// the slot's master instead, as the redirection means that the replica
+// can't serve the command. It returns the new node connection.
+func (c *Conn) followMoved(re *RedirError) (redis.Conn, error) {
+	c.mu.Lock()
+	readOnly := c.readOnly
+	connAddr := c.boundAddr
+	c.mu.Unlock()
+	if readOnly {
+		// check if the connection was already made to that slot, meaning
+		// that the redirection is because the command can't be served
+		// by the replica and a non-readonly connection must be made to
+		// the slot's master. If that's not the case, then keep the
+		// readonly flag to true, meaning that it will attempt a connection
+		// to a replica for the new slot.
+		c.cluster.mu.Lock()
+		slotMappings := c.cluster.mapping[re.NewSlot]
+		c.cluster.mu.Unlock()
+		if isIn(slotMappings, connAddr) {
+			readOnly = false
+		}
+	}
+
+	// forceDial doesn't require locking (immutable)
+	conn, addr, err := c.cluster.getConnForSlot(c.bindContext(), re.NewSlot, c.forceDial, readOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		// closed while getting the connection
+		conn.Close()
+		return nil, c.err
+	}
+	// close and replace the old connection (close must come before assignments)
+	c.closeLocked()
+	c.rc = conn
+	c.boundAddr = addr
+	c.readOnly = readOnly
+	return conn, nil
+}
+
+// doAsking executes the command on the node at the address of the ASK
+// redirection, preceded by the ASKING command. The connection to that
+// node is used only for this call, the connection stays bound to its node.
+func (c *Conn) doAsking(re *RedirError, cmd string, args ...interface{}) (interface{}, error) {
+	addr := c.cluster.rewriteAddr(re.Addr)
+	conn, err := c.cluster.getConnForAddr(c.bindContext(), addr, c.forceDial)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := conn.Send("ASKING"); err != nil {
+		return nil, err
+	}
+	var start time.Time
+	if c.cluster.Observer != nil {
+		start = time.Now()
+	}
+	v, err := conn.Do(cmd, args...)
+	c.mu.Lock()
+	c.lastAddr = addr
+	c.mu.Unlock()
+	if c.cluster.Observer != nil {
+		c.cluster.observeCommand(cmd, args, addr, start, err)
+	}
+	if re := ParseRedir(err); re != nil && re.Type == "MOVED" {
+		c.cluster.needsRefresh(re)
+	}
+	c.cluster.updateAskCache(re, cmd, args, err)
+	return v, err
+}
+
+// RebindOnMoved makes the connection follow the slots it is used for
+// when they move to another node. When a call to Do returns a MOVED
+// error and the node the connection is bound to doesn't serve that slot
+// anymore, the connection releases its node connection and is bound
+// again to the new node of the slot, so that the next calls are sent to
+// that node. The MOVED error is still returned to the caller, the command
+// is not executed again (see RetryConn for this).
+func (c *Conn) RebindOnMoved() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return c.err
+	}
+	c.rebindOnMoved = true
+	return nil
+}
+
+// rebind binds the connection to the node that serves the slot of the
+// MOVED redirection re, if RebindOnMoved was called and if rc, the node
+// connection that returned the redirection, is still the current one and
+// its node doesn't serve that slot anymore. If it fails to get a
+// connection to the new node, the connection is left unbound so that the
+// next call binds it again.
+func (c *Conn) rebind(rc redis.Conn, re *RedirError) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.rebindOnMoved || c.err != nil || c.rc != rc {
+		return
+	}
+
+	// mapping slices are never altered, they are replaced
+	c.cluster.mu.RLock()
+	addrs := c.cluster.mapping[re.NewSlot]
+	c.cluster.mu.RUnlock()
+	if isIn(addrs, c.boundAddr) {
+		// e.g. a write on a read-only connection to a replica
+		return
+	}
+
+	c.closeLocked()
+	c.rc, c.boundAddr = nil, ""
+	conn, addr, err := c.cluster.getConnForSlot(c.bindContext(), re.NewSlot, c.forceDial, c.readOnly)
+	if err == nil {
+		c.rc, c.boundAddr = conn, addr
+	}
+}
+
+// DoContext is like Do, but it returns ctx.Err() as soon as the context
+// is done, without waiting for the reply. Because the command may still be
+// in-flight at that point, the connection is then marked as broken and
+// all subsequent calls fail with that same error. The underlying node
+// connection is released once the abandoned command completes.
+//
+// If the connection is not yet bound, the connection to the node also
+// honors the context: a new connection is dialed with the context (which
+// replaces any DialNetDial and DialConnectTimeout options), and getting a
+// connection from a pool waits for an available connection only until the
+// context is done.
+//
+// The cluster's mapping is never left in a partial state by a cancelled
+// call: an automatic refresh triggered by a MOVED reply runs independently
+// of the context and replaces the mapping in a single step.
+func (c *Conn) DoContext(ctx context.Context, cmd string, args ...interface{}) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if ok, v, err := c.cluster.guardSelect(cmd, args); ok {
+		return v, err
+	}
+	rc, _, err := c.bind(ctx, c.cluster.cmdSlot(cmd, args))
+	if err != nil {
+		return nil, err
+	}
+	return c.withContext(ctx, rc, func() (interface{}, error) {
+		return c.doFollow(rc, cmd, args...)
+	})
+}
+
 // Send writes the command to the client's output buffer. If the
 // connection is not yet bound to a cluster node, it will be after
 // this call, based on the rules documented in the Conn type.
 func (c *Conn) Send(cmd string, args ...interface{}) error {
-	rc, _, err := c.bind(cmdSlot(cmd, args))
+	return c.send(c.bindContext(), cmd, args...)
+}
+
+// SendContext is like Send, but it returns ctx.Err() without sending
+// the command if the context is already done. If the connection is not
+// yet bound, the connection to the node honors the context as for
+// DoContext.
+func (c *Conn) SendContext(ctx context.Context, cmd string, args ...interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.send(ctx, cmd, args...)
+}
+
+func (c *Conn) send(ctx context.Context, cmd string, args ...interface{}) error {
+	rc, _, err := c.bind(ctx, c.cluster.cmdSlot(cmd, args))
 	if err != nil {
 		return err
 	}
+	if c.cluster.WarnCrossSlot {
+		c.cluster.warnCrossSlot(cmd, args)
+	}
 	return rc.Send(cmd, args...)
 }
 
@@ -263,11 +812,38 @@ func (c *Conn) Send(cmd string, args ...interface{}) error {
 // is not yet bound to a cluster node, it will be after this call,
 // based on the rules documented in the Conn type.
 func (c *Conn) Receive() (interface{}, error) {
-	rc, _, err := c.bind(-1)
+	rc, _, err := c.bind(c.bindContext(), -1)
 	if err != nil {
 		return nil, err
 	}
-	v, err := rc.Receive()
+	return c.receive(rc)
+}
+
+// ReceiveWithTimeout is like Receive, but it uses the provided read
+// timeout instead of the one of the node connection. A timeout of 0 means
+// that it waits for the reply indefinitely.
+func (c *Conn) ReceiveWithTimeout(timeout time.Duration) (interface{}, error) {
+	rc, _, err := c.bind(c.bindContext(), -1)
+	if err != nil {
+		return nil, err
+	}
+	return c.receiveTimeout(rc, timeout)
+}
+
+func (c *Conn) receive(rc redis.Conn) (interface{}, error) {
+	return c.receiveTimeout(rc, defaultTimeout)
+}
+
+// receiveTimeout receives a reply on rc with the read timeout, or with the
+// read timeout of rc if timeout is defaultTimeout.
+func (c *Conn) receiveTimeout(rc redis.Conn, timeout time.Duration) (interface{}, error) {
+	var v interface{}
+	var err error
+	if timeout == defaultTimeout {
+		v, err = rc.Receive()
+	} else {
+		v, err = redis.ReceiveWithTimeout(rc, timeout)
+	}
 
 	// handle redirections, if any
 	if re := ParseRedir(err); re != nil {
@@ -279,6 +855,72 @@ func (c *Conn) Receive() (interface{}, error) {
 	return v, err
 }
 
+// ReceiveContext is like Receive, but it returns ctx.Err() as soon as
+// the context is done, without waiting for the reply. As for DoContext,
+// the connection is then marked as broken.
+func (c *Conn) ReceiveContext(ctx context.Context) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	rc, _, err := c.bind(ctx, -1)
+	if err != nil {
+		return nil, err
+	}
+	return c.withContext(ctx, rc, func() (interface{}, error) {
+		return c.receive(rc)
+	})
+}
+
+// withContext calls fn in a separate goroutine and waits for it to
+// return or for ctx to be done, whichever comes first. If ctx is done
+// first, the connection is marked as broken with ctx.Err() and rc is
+// closed once fn returns, so that a pooled connection is never put back
+// in its pool with a pending reply.
+func (c *Conn) withContext(ctx context.Context, rc redis.Conn, fn func() (interface{}, error)) (interface{}, error) {
+	if ctx.Done() == nil {
+		// context can never be cancelled
+		return fn()
+	}
+
+	type result struct {
+		v   interface{}
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		v, err := fn()
+		ch <- result{v, err}
+	}()
+
+	select {
+	case res := <-ch:
+		return res.v, res.err
+
+	case <-ctx.Done():
+		err := ctx.Err()
+
+		c.mu.Lock()
+		readOnly, addr := c.readOnly, c.boundAddr
+		release := c.err == nil && c.rc == rc
+		if c.err == nil {
+			c.err = err
+		}
+		if release {
+			// take ownership of rc, Close must not release it while fn runs
+			c.rc = nil
+		}
+		c.mu.Unlock()
+
+		if release {
+			go func() {
+				<-ch
+				c.release(rc, addr, readOnly)
+			}()
+		}
+		return nil, err
+	}
+}
+
 // Flush flushes the output buffer to the server.
 func (c *Conn) Flush() error {
 	c.mu.Lock()
@@ -316,11 +958,24 @@ func (c *Conn) Close() error {
 
 func (c *Conn) closeLocked() (err error) {
 	if c.rc != nil {
-		// this may be a pooled connection, so make sure the readOnly flag is reset
-		if c.readOnly {
-			c.rc.Do("READWRITE")
-		}
-		err = c.rc.Close()
+		err = c.release(c.rc, c.boundAddr, c.readOnly)
 	}
 	return err
 }
+
+// release closes the node connection rc to the node at addr, which may
+// return it to its pool.
+func (c *Conn) release(rc redis.Conn, addr string, readOnly bool) error {
+	if c.cluster.ResetOnReturn && !c.forceDial && c.cluster.pooled() && rc.Err() == nil {
+		if c.cluster.resetConn(rc, addr) {
+			// RESET also resets the readOnly flag
+			return rc.Close()
+		}
+	}
+
+	// this may be a pooled connection, so make sure the readOnly flag is reset
+	if readOnly {
+		rc.Do("READWRITE")
+	}
+	return rc.Close()
+}
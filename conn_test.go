@@ -1,13 +1,21 @@
 package redisc
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
+	"net"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/garyburd/redigo/redis"
 	"github.com/mna/redisc/redistest"
+	"github.com/mna/redisc/redistest/resp"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -147,9 +155,9 @@ func testWithReplicaBindEmptySlot(t *testing.T, c *Cluster) {
 		assert.Contains(t, err.Error(), "MOVED", "MOVED error")
 	}
 
-	// wait for refreshing to become false again
+	// wait for the refresh to complete
 	c.mu.Lock()
-	for c.refreshing {
+	for c.refreshing != nil {
 		c.mu.Unlock()
 		time.Sleep(100 * time.Millisecond)
 		c.mu.Lock()
@@ -223,6 +231,91 @@ func TestConnReadOnly(t *testing.T) {
 	assert.Error(t, cc2.ReadOnly(), "ReadOnly after Bind")
 }
 
+func TestConnReadWrite(t *testing.T) {
+	var s1, s2 *redistest.MockServer
+	var mu sync.Mutex
+	var readWrite []string
+	handler := func(node string) func(string, ...string) interface{} {
+		return func(cmd string, args ...string) interface{} {
+			switch cmd {
+			case "CLUSTER":
+				return mockClusterSlots(s1.Addr, s2.Addr)
+			case "READONLY":
+				return "OK"
+			case "READWRITE":
+				mu.Lock()
+				readWrite = append(readWrite, node)
+				mu.Unlock()
+				return "OK"
+			case "GET":
+				return node
+			case "SET":
+				if node != "master" {
+					return resp.Error("MOVED 1234 " + s1.Addr)
+				}
+				return "OK"
+			}
+			return resp.Error("unexpected command " + cmd)
+		}
+	}
+	s1 = redistest.StartMockServer(t, handler("master"))
+	defer s1.Close()
+	s2 = redistest.StartMockServer(t, handler("replica"))
+	defer s2.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s1.Addr},
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	conn := c.Get()
+	defer conn.Close()
+
+	// no-op on a read-write connection
+	require.NoError(t, ReadWriteConn(conn), "ReadWrite")
+	assert.False(t, conn.(*Conn).IsReadOnly(), "IsReadOnly")
+
+	require.NoError(t, ReadOnlyConn(conn), "ReadOnly")
+	assert.True(t, conn.(*Conn).IsReadOnly(), "IsReadOnly")
+	v, err := redis.String(conn.Do("GET", "a"))
+	if assert.NoError(t, err, "GET") {
+		assert.Equal(t, "replica", v, "read from replica")
+	}
+
+	require.NoError(t, ReadWriteConn(conn), "ReadWrite")
+	assert.False(t, conn.(*Conn).IsReadOnly(), "IsReadOnly")
+	_, err = conn.Do("SET", "a", "b")
+	assert.NoError(t, err, "SET after ReadWrite")
+	v, err = redis.String(conn.Do("GET", "a"))
+	if assert.NoError(t, err, "GET") {
+		assert.Equal(t, "master", v, "read from master")
+	}
+	mu.Lock()
+	assert.Equal(t, []string{"replica"}, readWrite, "READWRITE sent to the replica")
+	mu.Unlock()
+
+	// ReadWrite before the connection is bound
+	conn2 := c.Get()
+	defer conn2.Close()
+	require.NoError(t, ReadOnlyConn(conn2), "ReadOnly")
+	require.NoError(t, ReadWriteConn(conn2), "ReadWrite")
+	v, err = redis.String(conn2.Do("GET", "a"))
+	if assert.NoError(t, err, "GET") {
+		assert.Equal(t, "master", v, "read from master")
+	}
+
+	// closing a read-only connection resets the node connection
+	conn3 := c.Get()
+	require.NoError(t, ReadOnlyConn(conn3), "ReadOnly")
+	_, err = conn3.Do("GET", "a")
+	require.NoError(t, err, "GET")
+	require.NoError(t, conn3.Close(), "Close")
+	mu.Lock()
+	assert.Equal(t, []string{"replica", "replica"}, readWrite, "READWRITE sent on close")
+	mu.Unlock()
+}
+
 func TestConnBind(t *testing.T) {
 	fn, ports := redistest.StartCluster(t, nil)
 	defer fn()
@@ -253,6 +346,223 @@ func TestConnBind(t *testing.T) {
 	assert.NoError(t, BindConn(conn2), "Bind without key")
 }
 
+func TestConnBindSlot(t *testing.T) {
+	var s1, s2 *redistest.MockServer
+	handler := func(node string) func(string, ...string) interface{} {
+		return func(cmd string, args ...string) interface{} {
+			switch cmd {
+			case "CLUSTER":
+				return resp.Array{
+					0: resp.Array{0: int64(0), 1: int64(100), 2: resp.Array{0: "127.0.0.1", 1: int64(mockPort(s1.Addr))}},
+					1: resp.Array{0: int64(101), 1: int64(16383), 2: resp.Array{0: "127.0.0.1", 1: int64(mockPort(s2.Addr))}},
+				}
+			case "PING":
+				return node
+			}
+			return resp.Error("unexpected command " + cmd)
+		}
+	}
+	s1 = redistest.StartMockServer(t, handler("s1"))
+	defer s1.Close()
+	s2 = redistest.StartMockServer(t, handler("s2"))
+	defer s2.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s1.Addr},
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	for _, slot := range []int{-1, hashSlots} {
+		conn := c.Get().(*Conn)
+		assert.Error(t, conn.BindSlot(slot), "BindSlot %d", slot)
+		conn.Close()
+	}
+
+	for slot, want := range map[int]string{0: "s1", 100: "s1", 101: "s2", hashSlots - 1: "s2"} {
+		conn := c.Get().(*Conn)
+		_, ok := conn.BoundAddr()
+		assert.False(t, ok, "not bound")
+		require.NoError(t, conn.BindSlot(slot), "BindSlot %d", slot)
+		addr, ok := conn.BoundAddr()
+		if assert.True(t, ok, "bound") {
+			wantAddr := "127.0.0.1" + s1.Addr
+			if want == "s2" {
+				wantAddr = "127.0.0.1" + s2.Addr
+			}
+			assert.Equal(t, wantAddr, addr, "bound address")
+		}
+		v, err := redis.String(conn.Do("PING"))
+		if assert.NoError(t, err, "PING") {
+			assert.Equal(t, want, v, "node of slot %d", slot)
+		}
+		if err := conn.BindSlot(slot); assert.Error(t, err, "BindSlot after BindSlot") {
+			assert.Contains(t, err.Error(), "connection already bound", "expected message")
+		}
+		conn.Close()
+	}
+}
+
+func TestConnUnbind(t *testing.T) {
+	var s1, s2 *redistest.MockServer
+	handler := func(node string) func(string, ...string) interface{} {
+		return func(cmd string, args ...string) interface{} {
+			switch cmd {
+			case "CLUSTER":
+				return resp.Array{
+					0: resp.Array{0: int64(0), 1: int64(100), 2: resp.Array{0: "127.0.0.1", 1: int64(mockPort(s1.Addr))}},
+					1: resp.Array{0: int64(101), 1: int64(16383), 2: resp.Array{0: "127.0.0.1", 1: int64(mockPort(s2.Addr))}},
+				}
+			case "PING":
+				return node
+			}
+			return resp.Error("unexpected command " + cmd)
+		}
+	}
+	s1 = redistest.StartMockServer(t, handler("s1"))
+	defer s1.Close()
+	s2 = redistest.StartMockServer(t, handler("s2"))
+	defer s2.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s1.Addr},
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	conn := c.Get().(*Conn)
+	defer conn.Close()
+
+	assert.NoError(t, conn.Unbind(), "Unbind when not bound")
+
+	require.NoError(t, conn.BindSlot(0), "BindSlot 0")
+	v, err := redis.String(conn.Do("PING"))
+	if assert.NoError(t, err, "PING") {
+		assert.Equal(t, "s1", v, "node of slot 0")
+	}
+
+	require.NoError(t, conn.Unbind(), "Unbind")
+	_, ok := conn.BoundAddr()
+	assert.False(t, ok, "not bound after Unbind")
+
+	require.NoError(t, conn.BindSlot(200), "BindSlot 200")
+	v, err = redis.String(conn.Do("PING"))
+	if assert.NoError(t, err, "PING") {
+		assert.Equal(t, "s2", v, "node of slot 200")
+	}
+
+	require.NoError(t, conn.Close(), "Close")
+	if err := conn.Unbind(); assert.Error(t, err, "Unbind after Close") {
+		assert.Contains(t, err.Error(), "redisc: closed", "expected message")
+	}
+}
+
+func TestConnBindAddr(t *testing.T) {
+	var s1, s2 *redistest.MockServer
+	handler := func(node string) func(string, ...string) interface{} {
+		return func(cmd string, args ...string) interface{} {
+			switch cmd {
+			case "CLUSTER":
+				return resp.Array{
+					0: resp.Array{0: int64(0), 1: int64(8000), 2: resp.Array{0: "127.0.0.1", 1: int64(mockPort(s1.Addr))}},
+					1: resp.Array{0: int64(8001), 1: int64(16383), 2: resp.Array{0: "127.0.0.1", 1: int64(mockPort(s2.Addr))}},
+				}
+			case "INFO":
+				return node
+			}
+			return resp.Error("unexpected command " + cmd)
+		}
+	}
+	s1 = redistest.StartMockServer(t, handler("s1"))
+	defer s1.Close()
+	s2 = redistest.StartMockServer(t, handler("s2"))
+	defer s2.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s1.Addr},
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	conn := c.Get().(*Conn)
+	defer conn.Close()
+
+	for _, addr := range []string{"127.0.0.1" + s2.Addr, "127.0.0.1" + s1.Addr} {
+		require.NoError(t, conn.BindAddr(addr), "BindAddr %s", addr)
+		got, ok := conn.BoundAddr()
+		assert.True(t, ok, "bound")
+		assert.Equal(t, addr, got, "bound address")
+
+		want := "s1"
+		if addr == "127.0.0.1"+s2.Addr {
+			want = "s2"
+		}
+		v, err := redis.String(conn.Do("INFO", "server"))
+		if assert.NoError(t, err, "INFO") {
+			assert.Equal(t, want, v, "node")
+		}
+		if err := conn.BindAddr(addr); assert.Error(t, err, "BindAddr after BindAddr") {
+			assert.Contains(t, err.Error(), "connection already bound", "expected message")
+			assert.True(t, errors.Is(err, ErrAlreadyBound), "ErrAlreadyBound")
+		}
+		require.NoError(t, conn.Unbind(), "Unbind")
+	}
+}
+
+func TestConnDoWithKeys(t *testing.T) {
+	var s1, s2 *redistest.MockServer
+	handler := func(node string) func(string, ...string) interface{} {
+		return func(cmd string, args ...string) interface{} {
+			switch cmd {
+			case "CLUSTER":
+				return resp.Array{
+					0: resp.Array{0: int64(0), 1: int64(8000), 2: resp.Array{0: "127.0.0.1", 1: int64(mockPort(s1.Addr))}},
+					1: resp.Array{0: int64(8001), 1: int64(16383), 2: resp.Array{0: "127.0.0.1", 1: int64(mockPort(s2.Addr))}},
+				}
+			case "EVAL":
+				return node
+			}
+			return resp.Error("unexpected command " + cmd)
+		}
+	}
+	s1 = redistest.StartMockServer(t, handler("s1"))
+	defer s1.Close()
+	s2 = redistest.StartMockServer(t, handler("s2"))
+	defer s2.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s1.Addr},
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	// "a" is in slot 15495 (s2), "b" in slot 3300 (s1)
+	cases := []struct {
+		key, node, addr string
+	}{
+		{"a", "s2", "127.0.0.1" + s2.Addr},
+		{"b", "s1", "127.0.0.1" + s1.Addr},
+	}
+	for _, cs := range cases {
+		conn := c.Get().(*Conn)
+		keys := []string{cs.key, "{" + cs.key + "}2"}
+		v, err := redis.String(conn.DoWithKeys(keys, "EVAL", "return 1", 2, keys[0], keys[1]))
+		if assert.NoError(t, err, "DoWithKeys %s", cs.key) {
+			assert.Equal(t, cs.node, v, "node for %s", cs.key)
+		}
+		addr, _ := conn.BoundAddr()
+		assert.Equal(t, cs.addr, addr, "bound address for %s", cs.key)
+		conn.Close()
+	}
+
+	conn := c.Get().(*Conn)
+	defer conn.Close()
+	_, err := conn.DoWithKeys([]string{"a", "b"}, "EVAL", "return 1", 2, "a", "b")
+	assert.True(t, errors.Is(err, ErrCrossSlot), "DoWithKeys with different slots")
+	_, ok := conn.BoundAddr()
+	assert.False(t, ok, "not bound")
+}
+
 func TestConnClose(t *testing.T) {
 	c := &Cluster{
 		StartupNodes: []string{":6379"},
@@ -302,4 +612,448 @@ func TestIsRedisError(t *testing.T) {
 	err = redis.Error("ERR some error")
 	assert.False(t, IsCrossSlot(err), "ERR")
 	assert.False(t, IsTryAgain(err), "ERR")
+	assert.False(t, IsClusterDown(err), "ERR")
+	err = redis.Error("CLUSTERDOWN The cluster is down")
+	assert.True(t, IsClusterDown(err), "ClusterDown")
+	assert.False(t, IsTryAgain(err), "ClusterDown")
+	err = redis.Error("LOADING Redis is loading the dataset in memory")
+	assert.True(t, IsLoading(err), "Loading")
+	assert.False(t, IsMasterDown(err), "Loading")
+	err = redis.Error("MASTERDOWN Link with MASTER is down and replica-serve-stale-data is set to 'no'.")
+	assert.True(t, IsMasterDown(err), "MasterDown")
+	assert.False(t, IsLoading(err), "MasterDown")
+}
+
+func TestConnDoContext(t *testing.T) {
+	var s *redistest.MockServer
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			addr, port, _ := net.SplitHostPort(s.Addr)
+			nPort, _ := strconv.Atoi(port)
+			return resp.Array{
+				0: resp.Array{0: int64(0), 1: int64(16383), 2: resp.Array{0: addr, 1: int64(nPort)}},
+			}
+		case "GET":
+			if args[0] == "slow" {
+				time.Sleep(200 * time.Millisecond)
+			}
+			return args[0]
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s.Addr},
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	conn := c.Get().(*Conn)
+	defer conn.Close()
+
+	v, err := redis.String(conn.DoContext(context.Background(), "GET", "fast"))
+	if assert.NoError(t, err, "DoContext Background") {
+		assert.Equal(t, "fast", v, "expected result")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = conn.DoContext(ctx, "GET", "fast")
+	assert.Equal(t, context.Canceled, err, "DoContext with cancelled context")
+	assert.NoError(t, conn.SendContext(context.Background(), "GET", "fast"), "SendContext")
+	assert.NoError(t, conn.Flush(), "Flush")
+	v, err = redis.String(conn.ReceiveContext(context.Background()))
+	if assert.NoError(t, err, "ReceiveContext") {
+		assert.Equal(t, "fast", v, "expected result")
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = conn.DoContext(ctx, "GET", "slow")
+	assert.Equal(t, context.DeadlineExceeded, err, "DoContext with timeout")
+	assert.True(t, time.Since(start) < 100*time.Millisecond, "returned before the reply")
+	assert.Equal(t, context.DeadlineExceeded, conn.Err(), "connection is broken")
+	_, err = conn.Do("GET", "fast")
+	assert.Equal(t, context.DeadlineExceeded, err, "Do after timeout")
+
+	// wait for the abandoned command to complete
+	time.Sleep(300 * time.Millisecond)
+}
+
+func TestConnDoContextBind(t *testing.T) {
+	var s *redistest.MockServer
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			addr, port, _ := net.SplitHostPort(s.Addr)
+			nPort, _ := strconv.Atoi(port)
+			return resp.Array{
+				0: resp.Array{0: int64(0), 1: int64(16383), 2: resp.Array{0: addr, 1: int64(nPort)}},
+			}
+		case "GET":
+			return args[0]
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s.Addr},
+		CreatePool: func(addr string, opts ...redis.DialOption) (*redis.Pool, error) {
+			p, err := createPool(addr, opts...)
+			if err != nil {
+				return nil, err
+			}
+			p.MaxActive = 1
+			p.Wait = true
+			return p, nil
+		},
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	// hold the only connection of the pool
+	conn1 := c.Get().(*Conn)
+	defer conn1.Close()
+	_, err := conn1.Do("GET", "a")
+	require.NoError(t, err, "Do")
+
+	conn2 := c.Get().(*Conn)
+	defer conn2.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = conn2.DoContext(ctx, "GET", "b")
+	assert.Equal(t, context.DeadlineExceeded, err, "DoContext while the pool is exhausted")
+	assert.True(t, time.Since(start) < time.Second, "returned at the deadline")
+}
+
+func TestConnBoundAffinity(t *testing.T) {
+	var s *redistest.MockServer
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			addr, port, _ := net.SplitHostPort(s.Addr)
+			nPort, _ := strconv.Atoi(port)
+			return resp.Array{
+				0: resp.Array{0: int64(0), 1: int64(16383), 2: resp.Array{0: addr, 1: int64(nPort)}},
+			}
+		case "GET":
+			if args[0] == "moved" {
+				return resp.Error("MOVED 1234 " + s.Addr)
+			}
+			return args[0]
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s.Addr},
+		CreatePool:   createPool,
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	conn := c.Get().(*Conn)
+	defer conn.Close()
+
+	_, err := conn.Do("GET", "{a}1")
+	require.NoError(t, err, "GET {a}1")
+	conn.mu.Lock()
+	rc1 := conn.rc
+	conn.mu.Unlock()
+
+	for i := 2; i < 10; i++ {
+		_, err := conn.Do("GET", "{a}"+strconv.Itoa(i))
+		require.NoError(t, err, "GET {a}%d", i)
+		conn.mu.Lock()
+		rc := conn.rc
+		conn.mu.Unlock()
+		assert.True(t, rc1 == rc, "same underlying connection %d", i)
+	}
+
+	// a redirection followed by a RetryConn rebinds the connection
+	rc, err := RetryConn(conn, 2, time.Millisecond)
+	require.NoError(t, err, "RetryConn")
+	_, err = rc.Do("GET", "moved")
+	assert.Error(t, err, "GET moved")
+	conn.mu.Lock()
+	rc2 := conn.rc
+	conn.mu.Unlock()
+	assert.False(t, rc1 == rc2, "rebound after redirection")
+}
+
+func TestConnRebindOnMoved(t *testing.T) {
+	var s1, s2 *redistest.MockServer
+	var moved int32
+	s1 = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return mockClusterSlots(s1.Addr)
+		case "GET":
+			if atomic.LoadInt32(&moved) == 1 {
+				return resp.Error("MOVED " + strconv.Itoa(Slot(args[0])) + " " + s2.Addr)
+			}
+			return "s1"
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s1.Close()
+	s2 = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "GET":
+			return "s2"
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s2.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s1.Addr},
+		CreatePool:   createPool,
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	sticky := c.Get().(*Conn)
+	defer sticky.Close()
+	require.NoError(t, sticky.RebindOnMoved(), "RebindOnMoved")
+	plain := c.Get()
+	defer plain.Close()
+
+	for _, conn := range []redis.Conn{sticky, plain} {
+		v, err := redis.String(conn.Do("GET", "{a}1"))
+		require.NoError(t, err, "GET")
+		assert.Equal(t, "s1", v, "bound to s1")
+	}
+
+	// the slot moves to s2
+	atomic.StoreInt32(&moved, 1)
+	for _, conn := range []redis.Conn{sticky, plain} {
+		_, err := conn.Do("GET", "{a}2")
+		if assert.Error(t, err, "GET after reshard") {
+			assert.NotNil(t, ParseRedir(err), "MOVED error")
+		}
+	}
+
+	v, err := redis.String(sticky.Do("GET", "{a}3"))
+	if assert.NoError(t, err, "GET on rebound connection") {
+		assert.Equal(t, "s2", v, "rebound to s2")
+	}
+	sticky.mu.Lock()
+	assert.Equal(t, s2.Addr, sticky.boundAddr, "bound address")
+	sticky.mu.Unlock()
+
+	// without RebindOnMoved, the connection stays bound to s1
+	_, err = plain.Do("GET", "{a}3")
+	assert.Error(t, err, "GET on plain connection")
+
+	require.NoError(t, sticky.Close(), "Close")
+	assert.Error(t, sticky.RebindOnMoved(), "RebindOnMoved after Close")
+}
+
+func TestConnFollowRedirects(t *testing.T) {
+	var s1, s2 *redistest.MockServer
+	s1 = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return mockClusterSlots(s1.Addr)
+		case "GET":
+			switch args[0] {
+			case "moved", "loop":
+				return resp.Error("MOVED " + strconv.Itoa(Slot(args[0])) + " " + s2.Addr)
+			case "ask":
+				return resp.Error("ASK " + strconv.Itoa(Slot(args[0])) + " " + s2.Addr)
+			}
+			return "s1"
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s1.Close()
+	var asking int32
+	s2 = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "ASKING":
+			atomic.AddInt32(&asking, 1)
+			return "OK"
+		case "GET":
+			if args[0] == "loop" {
+				return resp.Error("MOVED " + strconv.Itoa(Slot(args[0])) + " " + s1.Addr)
+			}
+			return "s2"
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s2.Close()
+
+	c := &Cluster{
+		StartupNodes:    []string{s1.Addr},
+		CreatePool:      createPool,
+		FollowRedirects: true,
+		// prevent the background refresh from restoring the mapping
+		RefreshCooldown: time.Minute,
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	// an ASK redirection is followed for that call only
+	conn := c.Get().(*Conn)
+	defer conn.Close()
+	v, err := redis.String(conn.Do("GET", "ask"))
+	require.NoError(t, err, "GET ask")
+	assert.Equal(t, "s2", v, "ask followed")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&asking), "ASKING sent")
+	addr, _ := conn.BoundAddr()
+	assert.Equal(t, s1.Addr, addr, "still bound to s1")
+
+	// a MOVED redirection rebinds the connection
+	v, err = redis.String(conn.Do("GET", "moved"))
+	require.NoError(t, err, "GET moved")
+	assert.Equal(t, "s2", v, "moved followed")
+	addr, _ = conn.BoundAddr()
+	assert.Equal(t, s2.Addr, addr, "bound to s2")
+
+	// only one redirection is followed
+	conn2 := c.Get()
+	defer conn2.Close()
+	_, err = conn2.Do("GET", "loop")
+	if assert.Error(t, err, "GET loop") {
+		re := ParseRedir(err)
+		if assert.NotNil(t, re, "MOVED error") {
+			assert.Equal(t, s1.Addr, re.Addr, "redirection to s1")
+		}
+	}
+}
+
+func TestConnWait(t *testing.T) {
+	var s *redistest.MockServer
+	var waitArgs []string
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return mockClusterSlots(s.Addr)
+		case "SET":
+			return "OK"
+		case "WAIT":
+			waitArgs = args
+			return int64(1)
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s.Addr},
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	conn := c.Get().(*Conn)
+	defer conn.Close()
+
+	_, err := conn.Wait(1, time.Second)
+	assert.Error(t, err, "Wait on unbound connection")
+
+	_, err = conn.Do("SET", "a", "b")
+	require.NoError(t, err, "SET")
+	n, err := conn.Wait(1, 250*time.Millisecond)
+	if assert.NoError(t, err, "Wait") {
+		assert.Equal(t, 1, n, "acknowledged replicas")
+		assert.Equal(t, []string{"1", "250"}, waitArgs, "WAIT arguments")
+	}
+
+	roConn := c.Get().(*Conn)
+	defer roConn.Close()
+	require.NoError(t, roConn.ReadOnly(), "ReadOnly")
+	require.NoError(t, roConn.Bind("a"), "Bind")
+	_, err = roConn.Wait(1, time.Second)
+	assert.Error(t, err, "Wait on read-only connection")
+}
+
+func TestParseRedir(t *testing.T) {
+	cases := []struct {
+		err  error
+		want *RedirError
+	}{
+		{nil, nil},
+		{errors.New("MOVED 1 :1"), nil},
+		{redis.Error("ERR MOVED 1 :1"), nil},
+		{redis.Error("MOVED x :1"), nil},
+		{redis.Error("MOVED 1"), nil},
+		{redis.Error("MOVED 1234 127.0.0.1:7000"), &RedirError{Type: "MOVED", NewSlot: 1234, Addr: "127.0.0.1:7000", raw: "MOVED 1234 127.0.0.1:7000"}},
+		{redis.Error("ASK 1 :7001"), &RedirError{Type: "ASK", NewSlot: 1, Addr: ":7001", raw: "ASK 1 :7001"}},
+		{fmt.Errorf("wrapped: %w", redis.Error("ASK 1 :7001")), &RedirError{Type: "ASK", NewSlot: 1, Addr: ":7001", raw: "ASK 1 :7001"}},
+		{&RedirError{Type: "MOVED", NewSlot: 2, Addr: ":7002"}, &RedirError{Type: "MOVED", NewSlot: 2, Addr: ":7002"}},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.want, ParseRedir(c.err), "%v", c.err)
+	}
+}
+
+func TestConnDoRouted(t *testing.T) {
+	var s1, s2 *redistest.MockServer
+	s1 = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return mockClusterSlots(s1.Addr)
+		case "GET":
+			if args[0] == "b" {
+				return resp.Error("MOVED " + strconv.Itoa(Slot(args[0])) + " " + s2.Addr)
+			}
+			return "s1"
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s1.Close()
+	s2 = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		if cmd == "GET" {
+			return "s2"
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s2.Close()
+
+	c := &Cluster{
+		StartupNodes:    []string{s1.Addr},
+		RefreshCooldown: time.Minute,
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	conn := c.Get().(*Conn)
+	defer conn.Close()
+	v, addr, err := conn.DoRouted("GET", "a")
+	require.NoError(t, err, "DoRouted")
+	assert.Equal(t, []byte("s1"), v, "reply")
+	assert.Equal(t, s1.Addr, addr, "node of a")
+
+	// the MOVED reply is produced by the bound node
+	_, addr, err = conn.DoRouted("GET", "b")
+	assert.NotNil(t, ParseRedir(err), "MOVED")
+	assert.Equal(t, s1.Addr, addr, "node of the redirection")
+
+	// with a RetryConn, the node that served the command after the redirection
+	c.mu.Lock()
+	c.mapping[Slot("b")] = []string{s1.Addr}
+	c.mu.Unlock()
+	rc, err := RetryConn(c.Get(), 3, time.Millisecond)
+	require.NoError(t, err, "RetryConn")
+	defer rc.Close()
+	routed := rc.(interface {
+		DoRouted(cmd string, args ...interface{}) (interface{}, string, error)
+	})
+	v, addr, err = routed.DoRouted("GET", "b")
+	require.NoError(t, err, "DoRouted with RetryConn")
+	assert.Equal(t, []byte("s2"), v, "reply")
+	assert.Equal(t, s2.Addr, addr, "node that served b")
+
+	cc := c.Get().(*Conn)
+	cc.Close()
+	_, addr, err = cc.DoRouted("GET", "a")
+	assert.Error(t, err, "DoRouted on closed connection")
+	assert.Empty(t, addr, "no node")
 }
@@ -0,0 +1,50 @@
+package redisc
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrCrossSlot is the error returned when keys that must belong to the
+// same hash slot do not, as detected by CheckSameSlot, Bind and the
+// other same-slot checks of the package. It is returned wrapped with the
+// details of the keys, so it should be tested with errors.Is. It differs
+// from the server's CROSSSLOT error, which is detected with IsCrossSlot.
+var ErrCrossSlot = errors.New("redisc: keys do not belong to the same slot")
+
+// CheckSameSlot returns an error if the keys don't all belong to the same
+// hash slot, as computed by the cluster (see the KeyToSlot field). The
+// error identifies the first key that doesn't belong to the slot of the
+// first key. It is typically used to detect missing hash tags (e.g.
+// "{user1}:name" and "{user1}:email") before executing a multi-key
+// command, which the server would reject with a CROSSSLOT error.
+func (c *Cluster) CheckSameSlot(keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	slot := c.slot(keys[0])
+	for _, k := range keys[1:] {
+		if ks := c.slot(k); ks != slot {
+			return fmt.Errorf("%w: %q (slot %d) and %q (slot %d)", ErrCrossSlot, keys[0], slot, k, ks)
+		}
+	}
+	return nil
+}
+
+// warnCrossSlot logs a warning if cmd is a known multi-key command (see
+// CommandKeys) and its keys don't belong to the same slot.
+func (c *Cluster) warnCrossSlot(cmd string, args []interface{}) {
+	fn := c.keysFunc(strings.ToUpper(cmd))
+	if fn == nil {
+		return
+	}
+	keys := fn(args)
+	strKeys := make([]string, len(keys))
+	for i, k := range keys {
+		strKeys[i] = keyString(k)
+	}
+	if err := c.CheckSameSlot(strKeys...); err != nil {
+		c.logf("redisc: cross-slot %s command: %v", strings.ToUpper(cmd), err)
+	}
+}
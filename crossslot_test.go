@@ -0,0 +1,80 @@
+package redisc
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/mna/redisc/redistest"
+	"github.com/mna/redisc/redistest/resp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClusterCheckSameSlot(t *testing.T) {
+	c := &Cluster{}
+	assert.NoError(t, c.CheckSameSlot(), "no key")
+	assert.NoError(t, c.CheckSameSlot("a"), "single key")
+	assert.NoError(t, c.CheckSameSlot("{user1}:name", "{user1}:email"), "hash tags")
+	if err := c.CheckSameSlot("a", "{a}b", "user1:name"); assert.Error(t, err, "different slots") {
+		assert.Contains(t, err.Error(), `"a" (slot 15495) and "user1:name"`, "expected message")
+		assert.True(t, errors.Is(err, ErrCrossSlot), "ErrCrossSlot")
+	}
+
+	c.KeyToSlot = func(key []byte) int { return 1 }
+	assert.NoError(t, c.CheckSameSlot("a", "b"), "KeyToSlot")
+}
+
+func TestClusterWarnCrossSlot(t *testing.T) {
+	var s *redistest.MockServer
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch strings.ToUpper(cmd) {
+		case "CLUSTER":
+			return mockClusterSlots(s.Addr)
+		case "MGET", "MSET", "GET":
+			return resp.OK{}
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	var buf syncBuffer
+	c := &Cluster{
+		StartupNodes:  []string{s.Addr},
+		CreatePool:    createPool,
+		Logger:        log.New(&buf, "", 0),
+		WarnCrossSlot: true,
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	conn := c.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("mget", "{a}1", "{a}2")
+	require.NoError(t, err, "MGET same slot")
+	_, err = conn.Do("MSET", "{a}1", "b", "{a}2", "c")
+	require.NoError(t, err, "MSET same slot")
+	_, err = conn.Do("GET", "{a}1", "b")
+	require.NoError(t, err, "GET")
+	assert.NotContains(t, buf.String(), "cross-slot", "no warning")
+
+	_, err = conn.Do("mget", "{a}1", "b")
+	require.NoError(t, err, "MGET")
+	require.NoError(t, conn.Send("MSET", "{a}1", "{a}2", "c", "d"), "Send MSET")
+	require.NoError(t, conn.Flush(), "Flush")
+	_, err = conn.Receive()
+	require.NoError(t, err, "Receive")
+
+	var lines []string
+	for _, l := range strings.Split(buf.String(), "\n") {
+		if strings.Contains(l, "cross-slot") {
+			lines = append(lines, l)
+		}
+	}
+	if assert.Len(t, lines, 2, "warnings") {
+		assert.Contains(t, lines[0], `redisc: cross-slot MGET command: redisc: keys do not belong to the same slot: "{a}1"`, "MGET warning")
+		assert.Contains(t, lines[1], `"{a}1" (slot 15495) and "c"`, "MSET warning")
+	}
+}
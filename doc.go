@@ -25,10 +25,10 @@
 // When more control is needed, the package offers some
 // extra behaviour specific to working with a redis cluster:
 //
-//     - Slot and SplitBySlot functions to compute the slot for
-//     a given key and to split a list of keys into groups of
-//     keys from the same slot, so that each group can safely be
-//     handled using the same connection.
+//     - Slot, SplitBySlot and GroupKeysBySlot functions to compute
+//     the slot for a given key and to split a list of keys into
+//     groups of keys from the same slot, so that each group can
+//     safely be handled using the same connection.
 //
 //     - *Conn.Bind (or the BindConn package-level helper function)
 //     to explicitly specify the keys that will be used with the
@@ -57,11 +57,16 @@
 //
 //     Dial() (redis.Conn, error)
 //     Refresh() error
+//     Do(string, ...interface{}) (interface{}, error)
 //
 // If the CreatePool function field is set, then a
 // redis.Pool is created to manage connections to each of the
 // cluster's nodes. A call to Get returns a connection
-// from that pool.
+// from that pool. The CreatePoolContext field can be set instead
+// to also receive the context of the call that creates the pool.
+// DefaultCreatePool can be used as CreatePool for pools with sensible
+// defaults, and the MaxConnLifetime field of the cluster sets how long
+// the pooled connections are reused before they are rotated.
 //
 // The Dial method, on the other hand, guarantees that
 // the returned connection will not be managed by a pool, even if
@@ -70,6 +75,19 @@
 // set on the cluster. If the cluster's CreatePool field is nil,
 // Get behaves the same as Dial.
 //
+// The Do method is a convenience method that gets a connection,
+// executes a single command on it and closes the connection.
+//
+// The Pipeline method returns a Pipeline that buffers commands on keys
+// of any slot and sends them with a single round-trip per node, with
+// the replies returned in the order of the commands.
+//
+// The BlockingConn method returns a connection bound to the node of
+// its keys to run blocking commands such as BLPOP or BRPOP. It holds
+// its node connection until closed and sets the read timeout of each
+// blocking command from the command's own timeout, so that the client
+// does not give up before the server replies.
+//
 // The Refresh method refreshes the cluster's internal mapping of
 // hash slots to nodes. It should typically be called only once,
 // after the cluster is created and before it is used, so that
@@ -88,15 +106,28 @@
 //
 //     Bind(...string) error
 //     ReadOnly() error
+//     RebindOnMoved() error
+//     DoContext(context.Context, string, ...interface{}) (interface{}, error)
+//     SendContext(context.Context, string, ...interface{}) error
+//     ReceiveContext(context.Context) (interface{}, error)
+//
+// The context variants of Do, Send and Receive return as soon as the
+// context is done. If a command was in-flight at that point, the
+// connection is broken and must be closed.
 //
 // The returned connection is not yet connected to any node; it is
 // "bound" to a specific node only when a call to Do, Send, Receive
 // or Bind is made. For Do, Send and Receive, the node selection is
-// implicit, it uses the first parameter of the command, and
-// computes the hash slot assuming that first parameter is a key.
-// It then binds the connection to the node corresponding to that
-// slot. If there are no parameters for the command, or if there is
-// no command (e.g. in a call to Receive), a random node is selected.
+// implicit, it uses the first key of the command, and computes its
+// hash slot. The first key is the first parameter of the command,
+// except for the commands of a built-in table (e.g. EVAL, XREAD or
+// ZUNIONSTORE) and of the cluster's CommandKeys field. It then binds
+// the connection to the node corresponding to that slot. If there
+// are no parameters for the command, if the command has
+// no key (e.g. PING, INFO, TIME or RANDOMKEY), or if there is no command
+// (e.g. in a call to Receive), a random master node is selected. To run
+// such commands on a specific node, call BindAddr with its address
+// before the first command.
 //
 // Bind is explicit, it gives control to the caller over
 // which node to select by specifying a list of keys that the caller
@@ -126,15 +157,21 @@
 // use. ReadOnly must be called before the connection is bound to a
 // node, otherwise an error is returned.
 //
+// The ReadWrite method reverts ReadOnly. If the connection is already
+// bound to a replica, READWRITE is sent to that replica and the
+// connection is released, so that the next command binds it to the
+// master of its slot. Note that sending READWRITE as a normal redis
+// command instead would essentially end that connection (all commands
+// would return MOVED errors, unless it is wrapped in a RetryConn).
+//
 // For the same reason as for Bind, a type assertion must be used to
-// call ReadOnly on a *Conn, so a package-level helper function is
-// also provided, ReadOnlyConn.
+// call ReadOnly and ReadWrite on a *Conn, so package-level helper
+// functions are also provided, ReadOnlyConn and ReadWriteConn.
 //
-// There is no ReadWrite method, because it can be sent as a normal
-// redis command and will essentially end that connection (all commands
-// will now return MOVED errors). If the connection was wrapped in
-// a RetryConn call, then it will automatically follow the redirection
-// to the master node (see the Redirections section).
+// Once bound, a connection stays bound to the same node. The
+// RebindOnMoved method makes it follow its slot instead: when a MOVED
+// error shows that the node doesn't serve that slot anymore, the
+// connection is bound to the new node for the next calls.
 //
 // The connection must be closed after use, to release the underlying
 // resources.
@@ -151,7 +188,13 @@
 // returns a redis.Conn interface where only calls to Do, Close and Err
 // can succeed. That means pipelining is not supported, and only a single
 // command can be executed at a time, but it will automatically handle
-// MOVED and ASK replies, as well as TRYAGAIN errors.
+// MOVED and ASK replies, as well as TRYAGAIN, LOADING and MASTERDOWN
+// errors (and CLUSTERDOWN errors if the RetryClusterDown option is set).
+//
+// Alternatively, if the cluster's FollowRedirects field is set, the Do
+// method of its connections follows a single MOVED or ASK redirection,
+// without retrying any other error. Send and Receive still return the
+// redirection errors.
 //
 // Note that even if RetryConn is not used, the cluster always updates
 // its mapping of slots to nodes automatically by keeping track of
@@ -0,0 +1,115 @@
+package redisc
+
+import (
+	"errors"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// DryRunCommand is a command passed to the DryRun function of a Cluster,
+// with the node it is routed to.
+type DryRunCommand struct {
+	// Addr is the address of the node the command is routed to.
+	Addr string
+	// Slot is the hash slot of the command's first key, or -1 if the
+	// command has no key.
+	Slot int
+	// Cmd and Args are the command and its arguments.
+	Cmd  string
+	Args []interface{}
+}
+
+// dryRunConn is the node connection returned when the cluster's DryRun
+// field is set. It passes the commands to DryRun instead of sending them
+// to the node.
+type dryRunConn struct {
+	cluster *Cluster
+	addr    string
+	closed  bool
+	pending []pipelineCmd
+	replies []pipelineReply
+}
+
+var _ redis.ConnWithTimeout = (*dryRunConn)(nil)
+
+func (c *dryRunConn) run(cmd string, args []interface{}) (interface{}, error) {
+	return c.cluster.DryRun(DryRunCommand{
+		Addr: c.addr,
+		Slot: c.cluster.cmdSlot(cmd, args),
+		Cmd:  cmd,
+		Args: args,
+	})
+}
+
+func (c *dryRunConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if err := c.Err(); err != nil {
+		return nil, err
+	}
+	if err := c.Flush(); err != nil {
+		return nil, err
+	}
+	if cmd == "" {
+		// as for redigo, return the reply of the last pending command
+		var r pipelineReply
+		if n := len(c.replies); n > 0 {
+			r = c.replies[n-1]
+		}
+		c.replies = nil
+		return r.v, r.err
+	}
+	c.replies = nil
+	return c.run(cmd, args)
+}
+
+func (c *dryRunConn) DoWithTimeout(timeout time.Duration, cmd string, args ...interface{}) (interface{}, error) {
+	return c.Do(cmd, args...)
+}
+
+func (c *dryRunConn) Send(cmd string, args ...interface{}) error {
+	if err := c.Err(); err != nil {
+		return err
+	}
+	c.pending = append(c.pending, pipelineCmd{cmd: cmd, args: args})
+	return nil
+}
+
+func (c *dryRunConn) Flush() error {
+	if err := c.Err(); err != nil {
+		return err
+	}
+	for _, pc := range c.pending {
+		v, err := c.run(pc.cmd, pc.args)
+		c.replies = append(c.replies, pipelineReply{v: v, err: err})
+	}
+	c.pending = nil
+	return nil
+}
+
+func (c *dryRunConn) Receive() (interface{}, error) {
+	if err := c.Err(); err != nil {
+		return nil, err
+	}
+	if len(c.replies) == 0 {
+		return nil, errors.New("redisc: no pending reply in dry run")
+	}
+	r := c.replies[0]
+	c.replies = c.replies[1:]
+	return r.v, r.err
+}
+
+func (c *dryRunConn) ReceiveWithTimeout(timeout time.Duration) (interface{}, error) {
+	return c.Receive()
+}
+
+func (c *dryRunConn) Err() error {
+	if c.closed {
+		return errors.New("redisc: closed")
+	}
+	return nil
+}
+
+func (c *dryRunConn) Close() error {
+	c.closed = true
+	return nil
+}
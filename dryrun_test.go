@@ -0,0 +1,59 @@
+package redisc
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClusterDryRun(t *testing.T) {
+	var mu sync.Mutex
+	var cmds []DryRunCommand
+	c := &Cluster{
+		StartupNodes: []string{"node1:7000"},
+		DryRun: func(cmd DryRunCommand) (interface{}, error) {
+			if cmd.Cmd == "CLUSTER" {
+				if cmd.Args[0] == "SHARDS" {
+					return nil, redis.Error("ERR unknown subcommand")
+				}
+				return []interface{}{
+					[]interface{}{int64(0), int64(8000), []interface{}{[]byte("node1"), int64(7000)}},
+					[]interface{}{int64(8001), int64(16383), []interface{}{[]byte("node2"), int64(7000)}},
+				}, nil
+			}
+			mu.Lock()
+			cmds = append(cmds, cmd)
+			mu.Unlock()
+			return "OK", nil
+		},
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	conn := c.Get()
+	defer conn.Close()
+	v, err := conn.Do("SET", "a", "1")
+	require.NoError(t, err, "SET")
+	assert.Equal(t, "OK", v, "reply")
+
+	conn2 := c.Get()
+	defer conn2.Close()
+	require.NoError(t, conn2.Send("GET", "b"), "Send")
+	require.NoError(t, conn2.Send("GET", "{b}c"), "Send")
+	require.NoError(t, conn2.Flush(), "Flush")
+	for i := 0; i < 2; i++ {
+		v, err := conn2.Receive()
+		require.NoError(t, err, "Receive")
+		assert.Equal(t, "OK", v, "reply")
+	}
+
+	want := []DryRunCommand{
+		{Addr: "node2:7000", Slot: 15495, Cmd: "SET", Args: []interface{}{"a", "1"}},
+		{Addr: "node1:7000", Slot: 3300, Cmd: "GET", Args: []interface{}{"b"}},
+		{Addr: "node1:7000", Slot: 3300, Cmd: "GET", Args: []interface{}{"{b}c"}},
+	}
+	assert.Equal(t, want, cmds, "commands")
+}
@@ -0,0 +1,83 @@
+package redisc
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// ErrStaleMapping is the error returned by Refresh when RejectStaleMapping
+// is set and the refreshed mapping is older than the current one, based
+// on the config epochs of the masters. The current mapping is kept.
+var ErrStaleMapping = errors.New("redisc: refreshed mapping is older than the current one")
+
+// getConfigEpochs sets the config epoch of the nodes of the mapping m, as
+// reported by the CLUSTER NODES command on conn. The nodes are matched by
+// ID, or by address if the ID is unknown.
+func (c *Cluster) getConfigEpochs(conn redis.Conn, m []slotMapping) error {
+	timeout := c.RefreshTimeout
+	if timeout <= 0 {
+		timeout = defaultRefreshTimeout
+	}
+	nodes, err := redis.String(redis.DoWithTimeout(conn, timeout, "CLUSTER", "NODES"))
+	if err != nil {
+		return err
+	}
+
+	byID := make(map[string]int64)
+	byAddr := make(map[string]int64)
+	for _, line := range strings.Split(nodes, "\n") {
+		// <id> <ip:port@cport[,hostname]> <flags> <master> <ping-sent>
+		// <pong-recv> <config-epoch> <link-state> <slot> ...
+		fields := strings.Fields(line)
+		if len(fields) < 8 {
+			continue
+		}
+		epoch, err := strconv.ParseInt(fields[6], 10, 64)
+		if err != nil {
+			return errors.New("redisc: invalid CLUSTER NODES config epoch: " + fields[6])
+		}
+		addr := fields[1]
+		if i := strings.IndexAny(addr, "@,"); i >= 0 {
+			addr = addr[:i]
+		}
+		byID[fields[0]] = epoch
+		byAddr[addr] = epoch
+	}
+
+	for _, sm := range m {
+		for i := range sm.infos {
+			if epoch, ok := byID[sm.infos[i].id]; ok && sm.infos[i].id != "" {
+				sm.infos[i].epoch = epoch
+			} else if epoch, ok := byAddr[sm.nodes[i]]; ok {
+				sm.infos[i].epoch = epoch
+			}
+		}
+	}
+	return nil
+}
+
+// staleSlotLocked returns the first slot of the mapping m that is served
+// by a master with a lower config epoch than the master that currently
+// serves it, and true, or false if there is no such slot. Masters with
+// an unknown config epoch are ignored. The caller must hold the lock.
+func (c *Cluster) staleSlotLocked(m []slotMapping) (int, bool) {
+	for _, sm := range m {
+		if len(sm.infos) == 0 || sm.infos[0].epoch == 0 {
+			continue
+		}
+		epoch := sm.infos[0].epoch
+		for ix := sm.start; ix <= sm.end; ix++ {
+			cur := c.mapping[ix]
+			if len(cur) == 0 {
+				continue
+			}
+			if c.nodeInfos[cur[0]].epoch > epoch {
+				return ix, true
+			}
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,65 @@
+package redisc
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mna/redisc/redistest"
+	"github.com/mna/redisc/redistest/resp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClusterConfigEpochs(t *testing.T) {
+	var s *redistest.MockServer
+	// epochs of m1 (the mock server) and m2, and the master of all slots
+	var epoch1, epoch2, owner int32 = 5, 3, 1
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		port := mockPort(s.Addr)
+		switch {
+		case cmd == "CLUSTER" && args[0] == "SLOTS":
+			node := resp.Array{"127.0.0.1", int64(port), "m1"}
+			if atomic.LoadInt32(&owner) == 2 {
+				node = resp.Array{"127.0.0.1", int64(7001), "m2"}
+			}
+			return resp.Array{resp.Array{int64(0), int64(hashSlots - 1), node}}
+		case cmd == "CLUSTER" && args[0] == "NODES":
+			return fmt.Sprintf("m1 127.0.0.1:%d@1%[1]d myself,master - 0 0 %d connected\n"+
+				"m2 127.0.0.1:7001@17001 master - 0 0 %d connected\n",
+				port, atomic.LoadInt32(&epoch1), atomic.LoadInt32(&epoch2))
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	c := &Cluster{
+		StartupNodes:       []string{s.Addr},
+		RejectStaleMapping: true,
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	m1 := "127.0.0.1" + s.Addr
+	nodes := c.Nodes()
+	if assert.Len(t, nodes, 1, "nodes") {
+		assert.Equal(t, m1, nodes[0].Addr, "master")
+		assert.Equal(t, int64(5), nodes[0].ConfigEpoch, "config epoch")
+	}
+
+	// a mapping that assigns the slots to a master with a lower epoch is
+	// rejected
+	atomic.StoreInt32(&owner, 2)
+	assert.Equal(t, ErrStaleMapping, c.Refresh(), "stale mapping")
+	addr, _ := c.NodeForKey("a")
+	assert.Equal(t, m1, addr, "mapping unchanged")
+
+	atomic.StoreInt32(&epoch2, 6)
+	require.NoError(t, c.Refresh(), "Refresh")
+	addr, _ = c.NodeForKey("a")
+	assert.Equal(t, "127.0.0.1:7001", addr, "new master")
+	nodes = c.Nodes()
+	if assert.Len(t, nodes, 1, "nodes") {
+		assert.Equal(t, int64(6), nodes[0].ConfigEpoch, "new config epoch")
+	}
+}
@@ -0,0 +1,26 @@
+package redisc
+
+// EvictNode closes the pool of the node at addr and removes it from the
+// cluster's pools, e.g. before a planned maintenance of that node, so
+// that its idle connections are closed and the next commands routed to
+// that node dial new connections (in a new pool). Connections of that
+// pool in use at that point are closed when they are closed by the
+// caller. The mapping of slots is not changed. It does nothing if the
+// cluster has no pool for that node.
+func (c *Cluster) EvictNode(addr string) error {
+	c.mu.Lock()
+	if err := c.err; err != nil {
+		c.mu.Unlock()
+		return err
+	}
+	p := c.pools[addr]
+	delete(c.pools, addr)
+	delete(c.orphans, addr)
+	c.mu.Unlock()
+
+	if p == nil {
+		return nil
+	}
+	c.logf("redisc: evicted pool for %s", addr)
+	return p.Close()
+}
@@ -0,0 +1,65 @@
+package redisc
+
+import (
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/mna/redisc/redistest"
+	"github.com/mna/redisc/redistest/resp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClusterEvictNode(t *testing.T) {
+	var s *redistest.MockServer
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return mockClusterSlots(s.Addr)
+		case "GET":
+			return "v"
+		case "PING":
+			return resp.Pong{}
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s.Addr},
+		CreatePool:   createPool,
+	}
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	_, err := c.Do("GET", "a")
+	require.NoError(t, err, "GET")
+	st := c.Stats()
+	require.Len(t, st, 1, "pool created")
+	assert.Equal(t, 1, st[s.Addr].IdleCount, "idle connection")
+
+	// a connection in use when the pool is evicted
+	conn := c.Get()
+	_, err = conn.Do("GET", "a")
+	require.NoError(t, err, "GET")
+
+	require.NoError(t, c.EvictNode(s.Addr), "EvictNode")
+	assert.Empty(t, c.Stats(), "pool evicted")
+	require.NoError(t, c.EvictNode(s.Addr), "EvictNode without pool")
+
+	// the connection in use still works until it is closed
+	_, err = conn.Do("GET", "a")
+	assert.NoError(t, err, "GET on connection in use")
+	conn.Close()
+
+	v, err := redis.String(c.Do("GET", "a"))
+	if assert.NoError(t, err, "GET after eviction") {
+		assert.Equal(t, "v", v, "GET")
+	}
+	st = c.Stats()
+	if assert.Len(t, st, 1, "new pool") {
+		assert.Equal(t, 1, st[s.Addr].ActiveCount, "new connection dialed")
+	}
+
+	require.NoError(t, c.Close(), "Close")
+	assert.Error(t, c.EvictNode(s.Addr), "EvictNode after Close")
+}
@@ -0,0 +1,96 @@
+package redisc
+
+import (
+	"context"
+	"sync"
+)
+
+// DoOnEachMaster runs the command concurrently on each master node of
+// the cluster, as known by the cluster's mapping, e.g. to run
+// administrative commands such as CONFIG SET or MEMORY DOCTOR. It
+// returns the reply of each node that succeeded and the error of each
+// node that failed (including error replies), indexed by node address.
+// A node is present in only one of the returned maps.
+func (c *Cluster) DoOnEachMaster(cmd string, args ...interface{}) (map[string]interface{}, map[string]error) {
+	return c.doOnEach(c.getNodeAddrs(false), cmd, args...)
+}
+
+// DoOnEachNode is like DoOnEachMaster, but it runs the command on the
+// replica nodes too.
+func (c *Cluster) DoOnEachNode(cmd string, args ...interface{}) (map[string]interface{}, map[string]error) {
+	return c.doOnEach(c.allNodeAddrs(), cmd, args...)
+}
+
+// NodeFilter is a predicate that selects nodes of the cluster, e.g. by
+// role (Node.Replica) or by the slots they serve (Node.Slots), for
+// DoOnNodes and ScanNodes.
+type NodeFilter func(node Node) bool
+
+// DoOnNodes is like DoOnEachNode, but it runs the command only on the
+// nodes for which filter returns true. The nodes are those returned by
+// the Nodes method.
+func (c *Cluster) DoOnNodes(filter NodeFilter, cmd string, args ...interface{}) (map[string]interface{}, map[string]error) {
+	return c.doOnEach(c.filterNodeAddrs(filter), cmd, args...)
+}
+
+// filterNodeAddrs returns the addresses of the nodes for which filter
+// returns true, ordered by address.
+func (c *Cluster) filterNodeAddrs(filter NodeFilter) []string {
+	var addrs []string
+	for _, n := range c.Nodes() {
+		if filter(n) {
+			addrs = append(addrs, n.Addr)
+		}
+	}
+	return addrs
+}
+
+// allNodeAddrs returns the addresses of the masters and replicas.
+func (c *Cluster) allNodeAddrs() []string {
+	addrs := c.getNodeAddrs(false)
+	c.mu.RLock()
+	for addr := range c.replicas {
+		addrs = append(addrs, addr)
+	}
+	c.mu.RUnlock()
+	return dedupeAddrs(addrs)
+}
+
+func (c *Cluster) doOnEach(addrs []string, cmd string, args ...interface{}) (map[string]interface{}, map[string]error) {
+	return c.onEach(addrs, func(addr string) (interface{}, error) {
+		conn, err := c.getConnForAddr(context.Background(), addr, false)
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+		return conn.Do(cmd, args...)
+	})
+}
+
+// onEach calls fn concurrently for each address and returns the results
+// of the calls that succeeded and the errors of the ones that failed,
+// indexed by address.
+func (c *Cluster) onEach(addrs []string, fn func(addr string) (interface{}, error)) (map[string]interface{}, map[string]error) {
+	vals := make([]interface{}, len(addrs))
+	errs := make([]error, len(addrs))
+	var wg sync.WaitGroup
+	wg.Add(len(addrs))
+	for i, addr := range addrs {
+		go func(i int, addr string) {
+			defer wg.Done()
+			vals[i], errs[i] = fn(addr)
+		}(i, addr)
+	}
+	wg.Wait()
+
+	res := make(map[string]interface{})
+	resErrs := make(map[string]error)
+	for i, addr := range addrs {
+		if errs[i] != nil {
+			resErrs[addr] = errs[i]
+			continue
+		}
+		res[addr] = vals[i]
+	}
+	return res, resErrs
+}
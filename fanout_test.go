@@ -0,0 +1,84 @@
+package redisc
+
+import (
+	"testing"
+
+	"github.com/mna/redisc/redistest"
+	"github.com/mna/redisc/redistest/resp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClusterDoOnEach(t *testing.T) {
+	var s1, s2 *redistest.MockServer
+	s1 = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return mockClusterSlots(s1.Addr, s2.Addr)
+		case "CONFIG":
+			return resp.OK{}
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s1.Close()
+	s2 = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		if cmd == "CONFIG" {
+			return resp.Error("ERR replica")
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s2.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s1.Addr},
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	vals, errs := c.DoOnEachMaster("CONFIG", "SET", "maxmemory", "1mb")
+	assert.Empty(t, errs, "no master error")
+	if assert.Len(t, vals, 1, "one master") {
+		assert.Equal(t, "OK", vals[s1.Addr], "master reply")
+	}
+
+	vals, errs = c.DoOnEachNode("CONFIG", "SET", "maxmemory", "1mb")
+	if assert.Len(t, vals, 1, "one successful node") {
+		assert.Equal(t, "OK", vals[s1.Addr], "master reply")
+	}
+	if assert.Len(t, errs, 1, "one failed node") {
+		assert.EqualError(t, errs[s2.Addr], "ERR replica", "replica error")
+	}
+}
+
+func TestClusterDoOnNodes(t *testing.T) {
+	var s1, s2 *redistest.MockServer
+	handler := func(name string) func(string, ...string) interface{} {
+		return func(cmd string, args ...string) interface{} {
+			switch cmd {
+			case "CLUSTER":
+				return mockClusterSlots(s1.Addr, s2.Addr)
+			case "INFO":
+				return name
+			}
+			return resp.Error("unexpected command " + cmd)
+		}
+	}
+	s1 = redistest.StartMockServer(t, handler("s1"))
+	defer s1.Close()
+	s2 = redistest.StartMockServer(t, handler("s2"))
+	defer s2.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s1.Addr},
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	vals, errs := c.DoOnNodes(func(n Node) bool { return n.Replica }, "INFO")
+	assert.Empty(t, errs, "no error")
+	assert.Equal(t, map[string]interface{}{s2.Addr: []byte("s2")}, vals, "replica reply")
+
+	vals, errs = c.DoOnNodes(func(n Node) bool { return false }, "INFO")
+	assert.Empty(t, errs, "no error")
+	assert.Empty(t, vals, "no node")
+}
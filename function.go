@@ -0,0 +1,86 @@
+package redisc
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// FCall calls the function fn of a library loaded with FunctionLoad, with
+// the FCALL command (redis 7+), on the node that owns the slot of the
+// keys. All keys must belong to the same slot, otherwise an error is
+// returned before the function is called. If no key is provided, the
+// function runs on a random node.
+func (c *Cluster) FCall(fn string, keys []string, args ...interface{}) (interface{}, error) {
+	return c.doWithKeys(keys, func(conn redis.Conn) (interface{}, error) {
+		return conn.Do("FCALL", evalArgs(fn, keys, args)...)
+	})
+}
+
+// FCallRO is like FCall, but it uses the FCALL_RO command, which fails if
+// the function is not flagged as read-only (no-writes). As for FCall, it
+// runs on the master node of the slot of the keys.
+func (c *Cluster) FCallRO(fn string, keys []string, args ...interface{}) (interface{}, error) {
+	return c.doWithKeys(keys, func(conn redis.Conn) (interface{}, error) {
+		return conn.Do("FCALL_RO", evalArgs(fn, keys, args)...)
+	})
+}
+
+// FunctionLoad loads the library code on all master nodes with the
+// FUNCTION LOAD command (redis 7+), so that its functions can be called
+// with FCall on any slot. If replace is true, the REPLACE option is set
+// so that an existing library with the same name is replaced. The
+// library is loaded concurrently on each node, and it returns the name
+// of the library. If the library could not be loaded on some nodes, the
+// name is still returned (if any node succeeded) along with the error of
+// the first node (by address) that failed.
+//
+// Unlike scripts, functions are persisted and replicated to the replicas
+// of a master, but they are not copied to the nodes that join the
+// cluster afterwards, so FunctionLoad should be called again after such
+// a change.
+func (c *Cluster) FunctionLoad(code string, replace bool) (string, error) {
+	c.mu.Lock()
+	err := c.err
+	c.mu.Unlock()
+	if err != nil {
+		return "", err
+	}
+
+	masters := c.getNodeAddrs(false)
+	if len(masters) == 0 {
+		return "", errors.New("redisc: no master node to load the library")
+	}
+	sort.Strings(masters)
+
+	args := redis.Args{"LOAD"}
+	if replace {
+		args = args.Add("REPLACE")
+	}
+	args = args.Add(code)
+	vals, errs := c.doOnEach(masters, "FUNCTION", args...)
+
+	var name string
+	for _, addr := range masters {
+		if _, ok := errs[addr]; ok {
+			continue
+		}
+		nodeName, err := redis.String(vals[addr], nil)
+		if err == nil && name != "" && nodeName != name {
+			err = fmt.Errorf("redisc: unexpected library name %s, want %s", nodeName, name)
+		}
+		if err != nil {
+			errs[addr] = err
+			continue
+		}
+		name = nodeName
+	}
+	for _, addr := range masters {
+		if err := errs[addr]; err != nil {
+			return name, fmt.Errorf("redisc: failed to load library on %s: %v", addr, err)
+		}
+	}
+	return name, nil
+}
@@ -0,0 +1,95 @@
+package redisc
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/mna/redisc/redistest"
+	"github.com/mna/redisc/redistest/resp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClusterFCall(t *testing.T) {
+	var s *redistest.MockServer
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return mockClusterSlots(s.Addr)
+		case "FCALL", "FCALL_RO":
+			return resp.Array{strings.ToLower(cmd), args[0], args[1], args[2]}
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s.Addr},
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	_, err := c.FCall("myfn", []string{"a", "b"})
+	assert.Error(t, err, "FCall with keys in different slots")
+
+	v, err := redis.Strings(c.FCall("myfn", []string{"{a}1", "{a}2"}, "x"))
+	if assert.NoError(t, err, "FCall") {
+		assert.Equal(t, []string{"fcall", "myfn", "2", "{a}1"}, v, "FCall")
+	}
+	v, err = redis.Strings(c.FCallRO("myfn", []string{"a"}, "x"))
+	if assert.NoError(t, err, "FCallRO") {
+		assert.Equal(t, []string{"fcall_ro", "myfn", "1", "a"}, v, "FCallRO")
+	}
+}
+
+func TestClusterFunctionLoad(t *testing.T) {
+	const code = "#!lua name=mylib\nredis.register_function('myfn', function() return 1 end)"
+
+	var s1, s2 *redistest.MockServer
+	var loaded, replaced int32
+	handler := func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return resp.Array{
+				0: resp.Array{0: int64(0), 1: int64(8000), 2: resp.Array{0: "127.0.0.1", 1: int64(mockPort(s1.Addr))}},
+				1: resp.Array{0: int64(8001), 1: int64(16383), 2: resp.Array{0: "127.0.0.1", 1: int64(mockPort(s2.Addr))}},
+			}
+		case "FUNCTION":
+			if args[0] != "LOAD" || args[len(args)-1] != code {
+				return resp.Error("ERR invalid library")
+			}
+			if args[1] == "REPLACE" {
+				atomic.AddInt32(&replaced, 1)
+			}
+			atomic.AddInt32(&loaded, 1)
+			return "mylib"
+		}
+		return resp.Error("unexpected command " + cmd)
+	}
+	s1 = redistest.StartMockServer(t, handler)
+	defer s1.Close()
+	s2 = redistest.StartMockServer(t, handler)
+	defer s2.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s1.Addr},
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	name, err := c.FunctionLoad(code, false)
+	require.NoError(t, err, "FunctionLoad")
+	assert.Equal(t, "mylib", name, "library name")
+	assert.Equal(t, int32(2), atomic.LoadInt32(&loaded), "loaded on both masters")
+
+	_, err = c.FunctionLoad(code, true)
+	require.NoError(t, err, "FunctionLoad with replace")
+	assert.Equal(t, int32(2), atomic.LoadInt32(&replaced), "replaced on both masters")
+
+	_, err = c.FunctionLoad("invalid", false)
+	if assert.Error(t, err, "FunctionLoad failure") {
+		assert.Contains(t, err.Error(), "failed to load library", "expected message")
+	}
+}
@@ -16,6 +16,18 @@ func Slot(key string) int {
 	return int(crc16(key) % hashSlots)
 }
 
+// SlotEqual returns true if all keys belong to the same hash slot,
+// meaning that they can be used in the same multi-key command. It
+// returns true if there are less than two keys.
+func SlotEqual(keys ...string) bool {
+	for i := 1; i < len(keys); i++ {
+		if Slot(keys[i]) != Slot(keys[0]) {
+			return false
+		}
+	}
+	return true
+}
+
 // SplitBySlot takes a list of keys and returns a list of list of keys,
 // grouped by identical cluster slot. For example:
 //
@@ -24,16 +36,10 @@ func Slot(key string) int {
 //       // keys is a list of keys that belong to the same slot
 //     }
 func SplitBySlot(keys ...string) [][]string {
-	var slots []int
-	m := make(map[int][]string)
-	for _, k := range keys {
-		slot := Slot(k)
-		_, ok := m[slot]
-		m[slot] = append(m[slot], k)
-
-		if !ok {
-			slots = append(slots, slot)
-		}
+	m := GroupKeysBySlot(keys)
+	slots := make([]int, 0, len(m))
+	for slot := range m {
+		slots = append(slots, slot)
 	}
 
 	sort.Ints(slots)
@@ -43,3 +49,20 @@ func SplitBySlot(keys ...string) [][]string {
 	}
 	return bySlot
 }
+
+// GroupKeysBySlot returns the keys grouped by their cluster slot, using
+// the same hash tag rules as Slot. The keys of each slot are in the same
+// order as in keys. This is useful to execute multi-key commands on a
+// cluster, with one command per slot. For example:
+//
+//     for slot, keys := range GroupKeysBySlot(keys) {
+//       // keys is a list of keys that belong to slot
+//     }
+func GroupKeysBySlot(keys []string) map[int][]string {
+	m := make(map[int][]string)
+	for _, k := range keys {
+		slot := Slot(k)
+		m[slot] = append(m[slot], k)
+	}
+	return m
+}
@@ -73,3 +73,44 @@ func TestSplitBySlot(t *testing.T) {
 		t.Logf("%#v", got)
 	}
 }
+
+func TestGroupKeysBySlot(t *testing.T) {
+	cases := []struct {
+		in  []string
+		out map[int][]string
+	}{
+		{nil, map[int][]string{}},
+		{[]string{"a"}, map[int][]string{15495: {"a"}}},
+		{[]string{"a", "b", "a"}, map[int][]string{15495: {"a", "a"}, 3300: {"b"}}},
+		{[]string{"{b}c", "a", "c{a}", "a{b}"}, map[int][]string{15495: {"a", "c{a}"}, 3300: {"{b}c", "a{b}"}}},
+		{[]string{"{}a", "{}b"}, map[int][]string{Slot("{}a"): {"{}a"}, Slot("{}b"): {"{}b"}}},
+	}
+
+	for _, c := range cases {
+		got := GroupKeysBySlot(c.in)
+		assert.Equal(t, c.out, got, "%v", c.in)
+	}
+}
+
+func TestSlotEqual(t *testing.T) {
+	cases := []struct {
+		in  string
+		out bool
+	}{
+		{"", true},
+		{"a", true},
+		{"a,b", false},
+		{"a,{a}b", true},
+		{"a,{a}b,c{a}", true},
+		{"a,{a}b,c{b}", false},
+		{"{}a,{}a", true},
+	}
+
+	for _, c := range cases {
+		args := strings.Split(c.in, ",")
+		if c.in == "" {
+			args = nil
+		}
+		assert.Equal(t, c.out, SlotEqual(args...), c.in)
+	}
+}
@@ -0,0 +1,103 @@
+package redisc
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// defaultHealthCheckFailures is the number of consecutive failed health
+// checks after which a node is marked unavailable, if the cluster's
+// HealthCheckFailures field is not set.
+const defaultHealthCheckFailures = 3
+
+// startHealthCheckLocked starts the background health-checker goroutine if it
+// is enabled and not already started. The cluster's lock must be held.
+func (c *Cluster) startHealthCheckLocked() {
+	if c.HealthCheckInterval <= 0 || c.healthStop != nil {
+		return
+	}
+	c.healthStop = make(chan struct{})
+	go c.healthCheck(c.HealthCheckInterval, c.healthStop)
+}
+
+// healthCheck pings all known nodes every interval, until stop is closed.
+// When a node fails enough consecutive checks, it is marked unavailable
+// and a refresh of the mapping is triggered, so that a failover is picked
+// up without waiting for a MOVED reply. A node is marked available again
+// as soon as a check succeeds.
+func (c *Cluster) healthCheck(interval time.Duration, stop <-chan struct{}) {
+	maxFailures := c.HealthCheckFailures
+	if maxFailures <= 0 {
+		maxFailures = defaultHealthCheckFailures
+	}
+	failures := make(map[string]int)
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+
+		// getNodeAddrs returns the masters if there are no replicas, so
+		// remove the duplicates to check each node only once.
+		addrs := dedupeAddrs(append(c.getNodeAddrs(false), c.getNodeAddrs(true)...))
+		results := make([]bool, len(addrs))
+
+		var wg sync.WaitGroup
+		wg.Add(len(addrs))
+		for i, addr := range addrs {
+			go func(i int, addr string) {
+				defer wg.Done()
+				results[i] = c.pingNode(addr)
+			}(i, addr)
+		}
+		wg.Wait()
+
+		var refresh bool
+		known := make(map[string]bool, len(addrs))
+		c.mu.Lock()
+		for i, addr := range addrs {
+			known[addr] = true
+			if results[i] {
+				delete(failures, addr)
+				delete(c.down, addr)
+				continue
+			}
+			failures[addr]++
+			if failures[addr] >= maxFailures && !c.down[addr] {
+				if c.down == nil {
+					c.down = make(map[string]bool)
+				}
+				c.down[addr] = true
+				refresh = true
+			}
+		}
+		c.mu.Unlock()
+
+		// forget about nodes that are not part of the cluster anymore
+		for addr := range failures {
+			if !known[addr] {
+				delete(failures, addr)
+			}
+		}
+		if refresh {
+			c.needsRefresh(nil)
+		}
+	}
+}
+
+// pingNode returns true if the node at addr replies to a PING command.
+func (c *Cluster) pingNode(addr string) bool {
+	conn, err := c.getConnForAddr(context.Background(), addr, false)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+
+	_, err = conn.Do("PING")
+	return err == nil
+}
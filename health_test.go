@@ -0,0 +1,155 @@
+package redisc
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/mna/redisc/redistest"
+	"github.com/mna/redisc/redistest/resp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClusterHealthCheckFailover(t *testing.T) {
+	var master, replica *redistest.MockServer
+	var promoted int32
+
+	master = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return mockClusterSlots(master.Addr, replica.Addr)
+		case "PING":
+			return resp.SimpleString("PONG")
+		case "GET":
+			return "master"
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer master.Close()
+
+	replica = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			if atomic.LoadInt32(&promoted) == 1 {
+				return mockClusterSlots(replica.Addr)
+			}
+			return mockClusterSlots(master.Addr, replica.Addr)
+		case "PING":
+			return resp.SimpleString("PONG")
+		case "GET":
+			if atomic.LoadInt32(&promoted) == 1 {
+				return "replica"
+			}
+			return resp.Error("MOVED 15495 " + master.Addr)
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer replica.Close()
+
+	c := &Cluster{
+		StartupNodes:        []string{master.Addr},
+		HealthCheckInterval: 10 * time.Millisecond,
+		HealthCheckFailures: 2,
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	v, err := redis.String(c.Do("GET", "a"))
+	require.NoError(t, err, "GET")
+	assert.Equal(t, "master", v, "served by master")
+
+	// the master goes down and the replica gets promoted
+	atomic.StoreInt32(&promoted, 1)
+	master.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		c.mu.RLock()
+		addrs := c.mapping[0]
+		c.mu.RUnlock()
+		if len(addrs) > 0 && addrs[0] == replica.Addr {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	v, err = redis.String(c.Do("GET", "a"))
+	require.NoError(t, err, "GET after failover")
+	assert.Equal(t, "replica", v, "served by promoted replica")
+
+	c.mu.RLock()
+	assert.False(t, c.down[master.Addr], "removed node is forgotten")
+	assert.False(t, c.masters[master.Addr], "removed node is not a master")
+	c.mu.RUnlock()
+}
+
+func TestClusterHealthCheckDisabled(t *testing.T) {
+	var s *redistest.MockServer
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return mockClusterSlots(s.Addr)
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	c := &Cluster{StartupNodes: []string{s.Addr}}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	c.mu.RLock()
+	assert.Nil(t, c.healthStop, "no health-checker")
+	c.mu.RUnlock()
+}
+
+func TestClusterHealthCheckMasterOnly(t *testing.T) {
+	var s *redistest.MockServer
+	var pings int32
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return mockClusterSlots(s.Addr)
+		case "PING":
+			atomic.AddInt32(&pings, 1)
+			return resp.Error("ERR not ready")
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	const interval = 20 * time.Millisecond
+	c := &Cluster{
+		StartupNodes:        []string{s.Addr},
+		HealthCheckInterval: interval,
+		HealthCheckFailures: 4,
+	}
+	defer c.Close()
+
+	start := time.Now()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	var down bool
+	deadline := time.Now().Add(time.Second)
+	for !down && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+		c.mu.RLock()
+		down = c.down[s.Addr]
+		c.mu.RUnlock()
+	}
+	elapsed := time.Since(start)
+	n := atomic.LoadInt32(&pings)
+
+	// without replicas, the master must still be checked only once per
+	// interval, so it takes 4 checks to mark it as unavailable.
+	require.True(t, down, "unavailable after repeated failures")
+	assert.True(t, elapsed >= 3*interval, "marked unavailable after %s", elapsed)
+	assert.True(t, n >= 4 && n <= 5, "%d pings", n)
+}
+
+func TestDedupeAddrs(t *testing.T) {
+	assert.Equal(t, []string{"a", "b", "c"}, dedupeAddrs([]string{"a", "b", "a", "c", "b"}), "dedupe")
+	assert.Equal(t, []string{}, dedupeAddrs(nil), "empty")
+}
@@ -0,0 +1,71 @@
+package redisc
+
+import (
+	"fmt"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// NodeHello returns the server metadata returned by the HELLO command
+// for the node at addr (e.g. "server", "version", "proto", "mode",
+// "role"), as a map of field name to value. It returns false if the
+// Protocol field of the cluster is not set, if no connection has been
+// made to that node yet or if the node doesn't support the HELLO
+// command.
+func (c *Cluster) NodeHello(addr string) (map[string]interface{}, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	info := c.hellos[addr]
+	if len(info) == 0 {
+		return nil, false
+	}
+	cp := make(map[string]interface{}, len(info))
+	for k, v := range info {
+		cp[k] = v
+	}
+	return cp, true
+}
+
+// hello negotiates the protocol with the node at addr and stores the
+// returned metadata, once per node.
+func (c *Cluster) hello(conn redis.Conn, addr string) error {
+	// redigo only supports RESP2
+	if c.Protocol != 2 {
+		return fmt.Errorf("redisc: unsupported protocol version %d", c.Protocol)
+	}
+
+	c.mu.RLock()
+	_, ok := c.hellos[addr]
+	c.mu.RUnlock()
+	if ok {
+		return nil
+	}
+
+	// an error reply means that the server doesn't support HELLO, store
+	// an empty map so that it is not attempted again, and keep using
+	// RESP2. Other errors (e.g. network errors) are returned, HELLO is
+	// attempted again on the next connection.
+	info := make(map[string]interface{})
+	reply, err := conn.Do("HELLO", c.Protocol)
+	if _, ok := err.(redis.Error); err != nil && !ok {
+		return err
+	}
+	if vals, err := redis.Values(reply, err); err == nil {
+		for i := 0; i+1 < len(vals); i += 2 {
+			if k, ok := vals[i].([]byte); ok {
+				info[string(k)] = vals[i+1]
+			} else {
+				info[fmt.Sprint(vals[i])] = vals[i+1]
+			}
+		}
+	}
+
+	c.mu.Lock()
+	if c.hellos == nil {
+		c.hellos = make(map[string]map[string]interface{})
+	}
+	c.hellos[addr] = info
+	c.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,139 @@
+package redisc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/mna/redisc/redistest"
+	"github.com/mna/redisc/redistest/resp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClusterHello(t *testing.T) {
+	var s *redistest.MockServer
+	var protos []string
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return mockClusterSlots(s.Addr)
+		case "HELLO":
+			protos = append(protos, args[0])
+			return resp.Array{"server", "redis", "version", "6.0.0", "proto", int64(2), "mode", "cluster"}
+		case "GET":
+			return args[0]
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s.Addr},
+		Protocol:     2,
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	conn := c.Get()
+	defer conn.Close()
+	_, err := conn.Do("GET", "a")
+	require.NoError(t, err, "GET")
+
+	// HELLO is sent once per node, with protocol 2
+	assert.Equal(t, []string{"2"}, protos, "HELLO protocols")
+	info, ok := c.NodeHello(s.Addr)
+	if assert.True(t, ok, "NodeHello") {
+		assert.Equal(t, []byte("6.0.0"), info["version"], "version")
+		assert.Equal(t, int64(2), info["proto"], "proto")
+	}
+}
+
+func TestClusterHelloUnsupported(t *testing.T) {
+	var s *redistest.MockServer
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return mockClusterSlots(s.Addr)
+		case "GET":
+			return args[0]
+		}
+		return resp.Error("ERR unknown command '" + cmd + "'")
+	})
+	defer s.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s.Addr},
+		Protocol:     2,
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	conn := c.Get()
+	defer conn.Close()
+	_, err := conn.Do("GET", "a")
+	assert.NoError(t, err, "GET")
+	_, ok := c.NodeHello(s.Addr)
+	assert.False(t, ok, "NodeHello")
+}
+
+func TestClusterHelloInvalidProtocol(t *testing.T) {
+	var s *redistest.MockServer
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return mockClusterSlots(s.Addr)
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s.Addr},
+		Protocol:     3,
+	}
+	defer c.Close()
+	assert.Error(t, c.Refresh(), "Refresh")
+
+	_, err := c.getConnForAddr(context.Background(), s.Addr, true)
+	if assert.Error(t, err, "getConnForAddr") {
+		assert.Contains(t, err.Error(), "unsupported protocol version 3", "expected message")
+	}
+}
+
+func TestClusterHelloConnError(t *testing.T) {
+	s := redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "HELLO":
+			return resp.Array{"server", "redis", "version", "6.0.0"}
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s.Addr},
+		Protocol:     2,
+	}
+	defer c.Close()
+
+	// a failure that is not an error reply is not cached
+	conn, err := redis.Dial("tcp", s.Addr)
+	require.NoError(t, err, "Dial")
+	conn.Close()
+	assert.Error(t, c.hello(conn, s.Addr), "hello on closed connection")
+	_, ok := c.NodeHello(s.Addr)
+	assert.False(t, ok, "NodeHello")
+	c.mu.RLock()
+	_, cached := c.hellos[s.Addr]
+	c.mu.RUnlock()
+	assert.False(t, cached, "not cached")
+
+	conn, err = c.getConnForAddr(context.Background(), s.Addr, true)
+	require.NoError(t, err, "getConnForAddr")
+	conn.Close()
+	info, ok := c.NodeHello(s.Addr)
+	if assert.True(t, ok, "NodeHello") {
+		assert.Equal(t, []byte("6.0.0"), info["version"], "version")
+	}
+}
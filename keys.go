@@ -0,0 +1,177 @@
+package redisc
+
+import (
+	"strconv"
+	"strings"
+)
+
+// KeysFunc is a function that returns the keys of a command, given its
+// arguments (without the command name). See the CommandKeys field of
+// the Cluster.
+type KeysFunc func(args []interface{}) []interface{}
+
+// commandKeys is the built-in table of the commands whose keys are not
+// only their first argument, with the function that returns their keys.
+var commandKeys = map[string]KeysFunc{
+	"DEL":         allKeys,
+	"EXISTS":      allKeys,
+	"MGET":        allKeys,
+	"PFCOUNT":     allKeys,
+	"PFMERGE":     allKeys,
+	"RENAME":      allKeys,
+	"RENAMENX":    allKeys,
+	"RPOPLPUSH":   allKeys,
+	"SDIFF":       allKeys,
+	"SDIFFSTORE":  allKeys,
+	"SINTER":      allKeys,
+	"SINTERSTORE": allKeys,
+	"SUNION":      allKeys,
+	"SUNIONSTORE": allKeys,
+	"TOUCH":       allKeys,
+	"UNLINK":      allKeys,
+	"WATCH":       allKeys,
+	"MSET":        pairKeys,
+	"MSETNX":      pairKeys,
+	"BLMOVE":      firstTwoKeys,
+	"BRPOPLPUSH":  firstTwoKeys,
+	"LMOVE":       firstTwoKeys,
+	"SMOVE":       firstTwoKeys,
+	"BLPOP":       allButLastKeys,
+	"BRPOP":       allButLastKeys,
+	"BZPOPMAX":    allButLastKeys,
+	"BZPOPMIN":    allButLastKeys,
+	"BITOP":       afterFirstKeys,
+	"MEMORY":      secondKey,
+	"OBJECT":      secondKey,
+	"XGROUP":      secondKey,
+	"XINFO":       secondKey,
+	"EVAL":        numKeysAt(1),
+	"EVALSHA":     numKeysAt(1),
+	"EVAL_RO":     numKeysAt(1),
+	"EVALSHA_RO":  numKeysAt(1),
+	"FCALL":       numKeysAt(1),
+	"FCALL_RO":    numKeysAt(1),
+	"BLMPOP":      numKeysAt(1),
+	"BZMPOP":      numKeysAt(1),
+	"LMPOP":       numKeysAt(0),
+	"ZMPOP":       numKeysAt(0),
+	"SINTERCARD":  numKeysAt(0),
+	"ZDIFF":       numKeysAt(0),
+	"ZINTER":      numKeysAt(0),
+	"ZINTERCARD":  numKeysAt(0),
+	"ZUNION":      numKeysAt(0),
+	"ZDIFFSTORE":  storeNumKeys,
+	"ZINTERSTORE": storeNumKeys,
+	"ZUNIONSTORE": storeNumKeys,
+	"XREAD":       streamKeys,
+	"XREADGROUP":  streamKeys,
+}
+
+// keysFunc returns the function that returns the keys of the upper-case
+// command name, from the CommandKeys field or the built-in table, or nil
+// if the command is in neither table.
+func (c *Cluster) keysFunc(name string) KeysFunc {
+	if fn, ok := c.CommandKeys[name]; ok {
+		return fn
+	}
+	return commandKeys[name]
+}
+
+// cmdKeys returns the keys of the upper-case command name, using the
+// CommandKeys field, the server (see UseServerKeyExtraction) or the
+// built-in table, in that order. It returns false if the keys of the
+// command are not known.
+func (c *Cluster) cmdKeys(name string, args []interface{}) ([]interface{}, bool) {
+	if fn, ok := c.CommandKeys[name]; ok {
+		return fn(args), true
+	}
+	if c.UseServerKeyExtraction {
+		if keys, ok := c.serverKeys(name, args); ok {
+			return keys, true
+		}
+	}
+	if fn := commandKeys[name]; fn != nil {
+		return fn(args), true
+	}
+	return nil, false
+}
+
+func allKeys(args []interface{}) []interface{} {
+	return args
+}
+
+func pairKeys(args []interface{}) []interface{} {
+	keys := make([]interface{}, 0, (len(args)+1)/2)
+	for i := 0; i < len(args); i += 2 {
+		keys = append(keys, args[i])
+	}
+	return keys
+}
+
+func firstTwoKeys(args []interface{}) []interface{} {
+	if len(args) > 2 {
+		return args[:2]
+	}
+	return args
+}
+
+func allButLastKeys(args []interface{}) []interface{} {
+	if len(args) == 0 {
+		return nil
+	}
+	return args[:len(args)-1]
+}
+
+func afterFirstKeys(args []interface{}) []interface{} {
+	if len(args) == 0 {
+		return nil
+	}
+	return args[1:]
+}
+
+func secondKey(args []interface{}) []interface{} {
+	if len(args) < 2 {
+		return nil
+	}
+	return args[1:2]
+}
+
+// numKeysAt returns a KeysFunc for the commands that have the number of
+// keys at index i of their arguments, followed by the keys.
+func numKeysAt(i int) KeysFunc {
+	return func(args []interface{}) []interface{} {
+		if len(args) <= i {
+			return nil
+		}
+		n, err := strconv.Atoi(keyString(args[i]))
+		if err != nil || n <= 0 {
+			return nil
+		}
+		keys := args[i+1:]
+		if n < len(keys) {
+			keys = keys[:n]
+		}
+		return keys
+	}
+}
+
+// storeNumKeys returns the keys of the commands that have a destination
+// key followed by the number of source keys and the source keys.
+func storeNumKeys(args []interface{}) []interface{} {
+	if len(args) == 0 {
+		return nil
+	}
+	return append([]interface{}{args[0]}, numKeysAt(1)(args)...)
+}
+
+// streamKeys returns the keys of XREAD and XREADGROUP, the first half of
+// the arguments that follow STREAMS.
+func streamKeys(args []interface{}) []interface{} {
+	for i, arg := range args {
+		if strings.EqualFold(keyString(arg), "STREAMS") {
+			rest := args[i+1:]
+			return rest[:len(rest)/2]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package redisc
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClusterCmdKeys(t *testing.T) {
+	c := &Cluster{}
+	cases := []struct {
+		cmd  string
+		args []interface{}
+		keys []interface{}
+	}{
+		{"MSET", []interface{}{"a", 1, "b", 2}, []interface{}{"a", "b"}},
+		{"blpop", []interface{}{"a", "b", 0}, []interface{}{"a", "b"}},
+		{"BITOP", []interface{}{"AND", "dst", "a", "b"}, []interface{}{"dst", "a", "b"}},
+		{"OBJECT", []interface{}{"ENCODING", "a"}, []interface{}{"a"}},
+		{"MEMORY", []interface{}{"DOCTOR"}, nil},
+		{"EVAL", []interface{}{"return 1", 2, "a", "b", "arg"}, []interface{}{"a", "b"}},
+		{"EVALSHA", []interface{}{"sha", "0", "arg"}, nil},
+		{"FCALL", []interface{}{"fn", []byte("1"), "a"}, []interface{}{"a"}},
+		{"BLMPOP", []interface{}{0, 2, "a", "b", "LEFT"}, []interface{}{"a", "b"}},
+		{"ZUNION", []interface{}{2, "a", "b", "WITHSCORES"}, []interface{}{"a", "b"}},
+		{"ZUNIONSTORE", []interface{}{"dst", 2, "a", "b"}, []interface{}{"dst", "a", "b"}},
+		{"XREAD", []interface{}{"COUNT", 1, "streams", "a", "b", "0", "0"}, []interface{}{"a", "b"}},
+		{"XREADGROUP", []interface{}{"GROUP", "g", "c", "STREAMS", "a", ">"}, []interface{}{"a"}},
+	}
+	for _, cs := range cases {
+		fn := c.keysFunc(strings.ToUpper(cs.cmd))
+		if assert.NotNil(t, fn, cs.cmd) {
+			assert.Equal(t, cs.keys, emptyToNil(fn(cs.args)), cs.cmd)
+		}
+	}
+}
+
+func TestClusterCmdSlotKeyTable(t *testing.T) {
+	c := &Cluster{}
+	// "a" is in slot 15495, "b" in slot 3300
+	assert.Equal(t, 15495, c.cmdSlot("EVAL", []interface{}{"return 1", 1, "a"}), "EVAL")
+	assert.Equal(t, 3300, c.cmdSlot("XREAD", []interface{}{"STREAMS", "b", "0"}), "XREAD")
+	assert.Equal(t, -1, c.cmdSlot("EVAL", []interface{}{"return 1", 0}), "EVAL without key")
+	assert.Equal(t, -1, c.cmdSlot("MEMORY", []interface{}{"STATS"}), "MEMORY STATS")
+	assert.Equal(t, Slot("c"), c.cmdSlot("GET", []interface{}{"c"}), "first argument")
+	assert.Equal(t, Slot("x"), c.cmdSlot("MODULE.CMD", []interface{}{"x", "a"}), "unknown command")
+
+	c.CommandKeys = map[string]KeysFunc{
+		"MODULE.CMD": func(args []interface{}) []interface{} { return args[1:] },
+		"GET":        func(args []interface{}) []interface{} { return nil },
+	}
+	assert.Equal(t, 15495, c.cmdSlot("module.cmd", []interface{}{"x", "a"}), "custom command")
+	assert.Equal(t, -1, c.cmdSlot("GET", []interface{}{"c"}), "overridden command")
+}
+
+func emptyToNil(v []interface{}) []interface{} {
+	if len(v) == 0 {
+		return nil
+	}
+	return v
+}
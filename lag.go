@@ -0,0 +1,133 @@
+package redisc
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// replicaLagTTL is the duration during which the replication lag of the
+// replicas of a master is cached, see MaxReplicaLag.
+const replicaLagTTL = time.Second
+
+// replicaLag is the replication lag of a replica, in bytes, as reported
+// by its master.
+type replicaLag struct {
+	lag    int64
+	online bool
+}
+
+// masterLags is the replication lag of the replicas of a master, by
+// replica address, as of the updated time.
+type masterLags struct {
+	replicas map[string]replicaLag
+	updated  time.Time
+}
+
+// replicaLagging returns true if the replica of master lags behind it by
+// more than MaxReplicaLag, or if its lag is unknown (e.g. because the
+// master could not be queried or doesn't list that replica).
+func (c *Cluster) replicaLagging(ctx context.Context, master, replica string) bool {
+	c.lagMu.Lock()
+	ml, ok := c.lags[master]
+	c.lagMu.Unlock()
+
+	if !ok || time.Since(ml.updated) > replicaLagTTL {
+		ml = c.updateReplicaLags(ctx, master)
+	}
+	rl, ok := ml.replicas[replica]
+	return !ok || !rl.online || rl.lag > c.MaxReplicaLag
+}
+
+// updateReplicaLags queries the replication offsets of the replicas of the
+// master with INFO replication, and stores and returns their lag. If the
+// master could not be queried, no replica is known until the next update.
+func (c *Cluster) updateReplicaLags(ctx context.Context, master string) masterLags {
+	ml := masterLags{replicas: make(map[string]replicaLag), updated: time.Now()}
+	info, err := c.replicationInfo(ctx, master)
+	if err != nil {
+		c.logf("redisc: failed to get replication lag from %s: %v", master, err)
+	}
+	for addr, rl := range parseReplicaLags(info) {
+		ml.replicas[c.rewriteAddr(addr)] = rl
+	}
+
+	c.lagMu.Lock()
+	defer c.lagMu.Unlock()
+	if c.lags == nil {
+		c.lags = make(map[string]masterLags)
+	}
+	c.lags[master] = ml
+	return ml
+}
+
+// replicationInfo returns the reply of INFO replication of the node at
+// addr.
+func (c *Cluster) replicationInfo(ctx context.Context, addr string) (string, error) {
+	conn, err := c.getConnForAddr(ctx, addr, false)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	return redis.String(conn.Do("INFO", "replication"))
+}
+
+// parseReplicaLags parses the reply of INFO replication of a master and
+// returns the lag of each replica, by address.
+func parseReplicaLags(info string) map[string]replicaLag {
+	var masterOffset int64
+	type replica struct {
+		addr   string
+		offset int64
+		online bool
+	}
+	var replicas []replica
+
+	for _, line := range strings.Split(info, "\n") {
+		line = strings.TrimSpace(line)
+		ix := strings.Index(line, ":")
+		if ix < 0 {
+			continue
+		}
+		key, val := line[:ix], line[ix+1:]
+		switch {
+		case key == "master_repl_offset":
+			masterOffset, _ = strconv.ParseInt(val, 10, 64)
+		case strings.HasPrefix(key, "slave"):
+			// e.g. slave0:ip=127.0.0.1,port=7001,state=online,offset=1234,lag=0
+			var r replica
+			var host, port string
+			for _, field := range strings.Split(val, ",") {
+				kv := strings.SplitN(field, "=", 2)
+				if len(kv) != 2 {
+					continue
+				}
+				switch kv[0] {
+				case "ip":
+					host = kv[1]
+				case "port":
+					port = kv[1]
+				case "state":
+					r.online = kv[1] == "online"
+				case "offset":
+					r.offset, _ = strconv.ParseInt(kv[1], 10, 64)
+				}
+			}
+			if port == "" {
+				continue
+			}
+			r.addr = net.JoinHostPort(host, port)
+			replicas = append(replicas, r)
+		}
+	}
+
+	lags := make(map[string]replicaLag, len(replicas))
+	for _, r := range replicas {
+		lags[r.addr] = replicaLag{lag: masterOffset - r.offset, online: r.online}
+	}
+	return lags
+}
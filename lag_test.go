@@ -0,0 +1,89 @@
+package redisc
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/mna/redisc/redistest"
+	"github.com/mna/redisc/redistest/resp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClusterMaxReplicaLag(t *testing.T) {
+	var master, replica *redistest.MockServer
+	var replicaOffset, infos int32
+	master = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return mockClusterSlots(master.Addr, replica.Addr)
+		case "INFO":
+			atomic.AddInt32(&infos, 1)
+			return "# Replication\r\nrole:master\r\nconnected_slaves:1\r\n" +
+				"slave0:ip=,port=" + strconv.Itoa(mockPort(replica.Addr)) +
+				",state=online,offset=" + strconv.Itoa(int(atomic.LoadInt32(&replicaOffset))) + ",lag=0\r\n" +
+				"master_repl_offset:1000\r\n"
+		case "GET":
+			return "master"
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer master.Close()
+	replica = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "READONLY":
+			return resp.OK{}
+		case "GET":
+			return "replica"
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer replica.Close()
+
+	c := &Cluster{
+		StartupNodes:  []string{master.Addr},
+		MaxReplicaLag: 100,
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	get := func() string {
+		conn := c.Get()
+		defer conn.Close()
+		require.NoError(t, ReadOnlyConn(conn), "ReadOnly")
+		v, err := redis.String(conn.Do("GET", "a"))
+		require.NoError(t, err, "GET")
+		return v
+	}
+
+	// the replica lags by 1000 bytes
+	assert.Equal(t, "master", get(), "lagging replica")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&infos), "INFO calls")
+
+	// the lag is cached
+	atomic.StoreInt32(&replicaOffset, 950)
+	assert.Equal(t, "master", get(), "cached lag")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&infos), "INFO calls")
+
+	c.lagMu.Lock()
+	c.lags = nil
+	c.lagMu.Unlock()
+	assert.Equal(t, "replica", get(), "replica within the lag")
+	assert.Equal(t, int32(2), atomic.LoadInt32(&infos), "INFO calls")
+}
+
+func TestParseReplicaLags(t *testing.T) {
+	info := "# Replication\r\n" +
+		"role:master\r\n" +
+		"connected_slaves:2\r\n" +
+		"slave0:ip=10.0.0.1,port=7001,state=online,offset=990,lag=0\r\n" +
+		"slave1:ip=10.0.0.2,port=7002,state=wait_bgsave,offset=0,lag=1\r\n" +
+		"master_repl_offset:1000\r\n"
+	assert.Equal(t, map[string]replicaLag{
+		"10.0.0.1:7001": {lag: 10, online: true},
+		"10.0.0.2:7002": {lag: 1000, online: false},
+	}, parseReplicaLags(info), "lags")
+	assert.Empty(t, parseReplicaLags(""), "no replica")
+}
@@ -0,0 +1,74 @@
+package redisc
+
+import "time"
+
+const (
+	// latencyWeight is the weight of a new measure in the moving average
+	// of a node's latency.
+	latencyWeight = 0.2
+
+	// latencyProbeInterval is the age after which the latency measured for
+	// a replica is considered stale, so that the replica is selected again
+	// to measure it.
+	latencyProbeInterval = 5 * time.Second
+)
+
+type nodeLatency struct {
+	avg     time.Duration
+	updated time.Time
+}
+
+// recordLatency adds the measure d to the moving average of the latency
+// of the node at addr.
+func (c *Cluster) recordLatency(addr string, d time.Duration) {
+	if addr == "" {
+		return
+	}
+
+	c.latencyMu.Lock()
+	defer c.latencyMu.Unlock()
+
+	if c.latencies == nil {
+		c.latencies = make(map[string]nodeLatency)
+	}
+	nl, ok := c.latencies[addr]
+	if ok {
+		d = time.Duration(latencyWeight*float64(d) + (1-latencyWeight)*float64(nl.avg))
+	}
+	c.latencies[addr] = nodeLatency{avg: d, updated: time.Now()}
+}
+
+// fastestReplica returns the replica with the lowest measured latency,
+// or the first one that has no recent measure.
+func (c *Cluster) fastestReplica(replicas []string) string {
+	c.latencyMu.Lock()
+	defer c.latencyMu.Unlock()
+
+	var best string
+	var bestAvg time.Duration
+	for _, addr := range replicas {
+		nl, ok := c.latencies[addr]
+		if !ok || time.Since(nl.updated) > latencyProbeInterval {
+			return addr
+		}
+		if best == "" || nl.avg < bestAvg {
+			best, bestAvg = addr, nl.avg
+		}
+	}
+	return best
+}
+
+// NodeLatencies returns the latency measured for each node, as a moving
+// average of the duration of the commands executed on that node. It is
+// only measured if LatencyAwareReplicas is set. Keys are the node's
+// addresses.
+func (c *Cluster) NodeLatencies() map[string]time.Duration {
+	c.latencyMu.Lock()
+	defer c.latencyMu.Unlock()
+
+	res := make(map[string]time.Duration, len(c.latencies))
+	for addr, nl := range c.latencies {
+		res[addr] = nl.avg
+	}
+	return res
+}
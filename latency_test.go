@@ -0,0 +1,92 @@
+package redisc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/mna/redisc/redistest"
+	"github.com/mna/redisc/redistest/resp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClusterFastestReplica(t *testing.T) {
+	c := &Cluster{}
+	replicas := []string{"a", "b", "c"}
+	assert.Equal(t, "a", c.fastestReplica(replicas), "no measure")
+
+	c.recordLatency("a", 3*time.Millisecond)
+	c.recordLatency("b", time.Millisecond)
+	assert.Equal(t, "c", c.fastestReplica(replicas), "c not measured")
+
+	c.recordLatency("c", 2*time.Millisecond)
+	assert.Equal(t, "b", c.fastestReplica(replicas), "fastest")
+
+	// the average moves towards the new measures
+	for i := 0; i < 20; i++ {
+		c.recordLatency("b", 5*time.Millisecond)
+	}
+	assert.Equal(t, "c", c.fastestReplica(replicas), "b is now slower")
+	lat := c.NodeLatencies()
+	assert.True(t, lat["b"] > 4*time.Millisecond && lat["b"] <= 5*time.Millisecond, "b latency %v", lat["b"])
+
+	// a stale measure is probed again
+	c.latencyMu.Lock()
+	nl := c.latencies["a"]
+	nl.updated = time.Now().Add(-2 * latencyProbeInterval)
+	c.latencies["a"] = nl
+	c.latencyMu.Unlock()
+	assert.Equal(t, "a", c.fastestReplica(replicas), "stale measure")
+}
+
+func TestClusterLatencyAwareReplicas(t *testing.T) {
+	var master, fast, slow *redistest.MockServer
+	handler := func(name string, delay time.Duration) func(string, ...string) interface{} {
+		return func(cmd string, args ...string) interface{} {
+			switch cmd {
+			case "CLUSTER":
+				return mockClusterSlots(master.Addr, fast.Addr, slow.Addr)
+			case "READONLY":
+				return "OK"
+			case "GET":
+				time.Sleep(delay)
+				return name
+			}
+			return resp.Error("unexpected command " + cmd)
+		}
+	}
+	master = redistest.StartMockServer(t, handler("master", 0))
+	defer master.Close()
+	fast = redistest.StartMockServer(t, handler("fast", 0))
+	defer fast.Close()
+	slow = redistest.StartMockServer(t, handler("slow", 20*time.Millisecond))
+	defer slow.Close()
+
+	c := &Cluster{
+		StartupNodes:         []string{master.Addr},
+		LatencyAwareReplicas: true,
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	get := func() string {
+		conn := c.Get()
+		defer conn.Close()
+		require.NoError(t, ReadOnlyConn(conn), "ReadOnly")
+		v, err := redis.String(conn.Do("GET", "a"))
+		require.NoError(t, err, "GET")
+		return v
+	}
+
+	// the first reads measure both replicas
+	seen := map[string]bool{get(): true, get(): true}
+	assert.Equal(t, map[string]bool{"fast": true, "slow": true}, seen, "both replicas measured")
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, "fast", get(), "fastest replica selected")
+	}
+
+	lat := c.NodeLatencies()
+	assert.Len(t, lat, 2, "latencies")
+	assert.True(t, lat[slow.Addr] > lat[fast.Addr], "slow replica is slower")
+}
@@ -0,0 +1,14 @@
+package redisc
+
+// Logger is the interface used by the cluster to log its internal
+// events. It is implemented by *log.Logger.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// logf logs the formatted message with the cluster's Logger, if any.
+func (c *Cluster) logf(format string, v ...interface{}) {
+	if c.Logger != nil {
+		c.Logger.Printf(format, v...)
+	}
+}
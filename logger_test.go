@@ -0,0 +1,81 @@
+package redisc
+
+import (
+	"bytes"
+	"log"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/mna/redisc/redistest"
+	"github.com/mna/redisc/redistest/resp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestClusterLogger(t *testing.T) {
+	var s *redistest.MockServer
+	var moved int32
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return mockClusterSlots(s.Addr)
+		case "GET":
+			if atomic.AddInt32(&moved, 1) == 1 {
+				return resp.Error("MOVED 1234 " + s.Addr)
+			}
+			return args[0]
+		case "PING":
+			return "PONG"
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	var buf syncBuffer
+	c := &Cluster{
+		StartupNodes: []string{s.Addr},
+		CreatePool:   createPool,
+		Logger:       log.New(&buf, "", 0),
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	conn := c.Get()
+	defer conn.Close()
+	rc, err := RetryConn(conn, 3, 0)
+	require.NoError(t, err, "RetryConn")
+	v, err := redis.String(rc.Do("GET", "a"))
+	require.NoError(t, err, "GET")
+	assert.Equal(t, "a", v, "GET result")
+
+	out := buf.String()
+	assert.Contains(t, out, "redisc: refreshed mapping: 1 masters, 0 replicas\n", "refresh")
+	assert.Contains(t, out, "redisc: MOVED redirection for slot 1234 to "+s.Addr+"\n", "redirection")
+	assert.Contains(t, out, "redisc: created pool for "+s.Addr+"\n", "pool creation")
+
+	c2 := &Cluster{
+		Logger: log.New(&buf, "", 0),
+	}
+	defer c2.Close()
+	assert.Error(t, c2.Refresh(), "Refresh without node")
+	assert.Contains(t, buf.String(), "redisc: failed to refresh mapping: ", "refresh failure")
+}
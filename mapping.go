@@ -0,0 +1,161 @@
+package redisc
+
+import "sort"
+
+// Mapping is a snapshot of the cluster's mapping of hash slots to nodes.
+// It is indexed by slot, and each entry lists the address of the master
+// node serving that slot, followed by the addresses of its replicas. An
+// entry is empty if the slot is not served by any known node. The entries
+// are shared between snapshots and must not be modified.
+type Mapping [hashSlots][]string
+
+// ChangedSlots returns the list of slots, in increasing order, for which
+// the master or the replicas differ between m and other.
+func (m *Mapping) ChangedSlots(other *Mapping) []int {
+	var slots []int
+	for i := range m {
+		if !sameNodes(m[i], other[i]) {
+			slots = append(slots, i)
+		}
+	}
+	return slots
+}
+
+func sameNodes(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Mapping returns a snapshot of the current mapping of hash slots to
+// nodes, e.g. to render the slots served by each node. The snapshot is
+// not affected by subsequent refreshes of the mapping. See the Nodes
+// method for a list of nodes with their slot ranges.
+func (c *Cluster) Mapping() *Mapping {
+	c.mu.RLock()
+	m := c.mapping
+	c.mu.RUnlock()
+	return &m
+}
+
+// UnassignedSlots returns the list of slots, in increasing order, that
+// are not served by any node as of the last refresh of the mapping (or
+// of the MOVED replies received since then), e.g. during a reshard or
+// while the cluster is being formed. Commands on keys of those slots
+// fail. It returns all the slots if the mapping was never refreshed, and
+// an empty list if the cluster is fully covered.
+func (c *Cluster) UnassignedSlots() []int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var slots []int
+	for i := range c.mapping {
+		if len(c.mapping[i]) == 0 {
+			slots = append(slots, i)
+		}
+	}
+	return slots
+}
+
+// NodeForKey returns the address of the master node that serves the
+// slot of key (as computed by the cluster, see the KeyToSlot field) in
+// the current mapping, without connecting to any node. It returns false
+// if that slot is not assigned to any known node.
+func (c *Cluster) NodeForKey(key string) (addr string, ok bool) {
+	slot := c.slot(key)
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if addrs := c.mapping[slot]; len(addrs) > 0 {
+		return addrs[0], true
+	}
+	return "", false
+}
+
+// SlotRange is a range of hash slots, from Start to End inclusively.
+type SlotRange struct {
+	Start, End int
+}
+
+// Node describes a node of the cluster, as known by the cluster's
+// mapping of slots to nodes.
+type Node struct {
+	// Addr is the address of the node.
+	Addr string
+	// ID is the node ID, if it was reported by the cluster.
+	ID string
+	// Hostname is the hostname announced by the node, if any (redis 7+).
+	Hostname string
+	// Health is the health of the node as reported by the CLUSTER SHARDS
+	// command (e.g. "online" or "loading"), on nodes that support it
+	// (redis 7+). It is empty otherwise.
+	Health string
+	// ConfigEpoch is the config epoch of the node, if it is known (see the
+	// FetchConfigEpochs field of the Cluster). It is 0 otherwise.
+	ConfigEpoch int64
+	// Replica is true if the node is a replica, false if it is a master.
+	Replica bool
+	// Master is the address of the master of a replica node. It is empty
+	// for master nodes.
+	Master string
+	// Slots is the list of slot ranges served by the node, in increasing
+	// order. For a replica, those are the slots served by its master.
+	Slots []SlotRange
+}
+
+// Nodes returns the list of nodes of the cluster, masters and replicas,
+// ordered by address. It reflects the state of the cluster as of the
+// last refresh of its mapping, and only nodes that serve at least one
+// slot are listed.
+func (c *Cluster) Nodes() []Node {
+	c.mu.Lock()
+	m := c.mapping
+	infos := c.nodeInfos
+	c.mu.Unlock()
+
+	byAddr := make(map[string]*Node)
+	var addrs []string
+	for slot := 0; slot < hashSlots; slot++ {
+		nodes := m[slot]
+		for i, addr := range nodes {
+			n := byAddr[addr]
+			if n == nil {
+				info := infos[addr]
+				n = &Node{Addr: addr, ID: info.id, Hostname: info.hostname, Health: info.health, ConfigEpoch: info.epoch}
+				byAddr[addr] = n
+				addrs = append(addrs, addr)
+			}
+			if i > 0 {
+				n.Replica = true
+				n.Master = nodes[0]
+			}
+			if last := len(n.Slots) - 1; last >= 0 && n.Slots[last].End == slot-1 {
+				n.Slots[last].End = slot
+			} else {
+				n.Slots = append(n.Slots, SlotRange{Start: slot, End: slot})
+			}
+		}
+	}
+
+	sort.Strings(addrs)
+	list := make([]Node, 0, len(addrs))
+	for _, addr := range addrs {
+		list = append(list, *byAddr[addr])
+	}
+	return list
+}
+
+// nodeInfo is the additional information about a node reported by the
+// cluster.
+type nodeInfo struct {
+	id       string
+	health   string
+	hostname string
+	epoch    int64 // config epoch, if FetchConfigEpochs is set
+}
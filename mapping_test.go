@@ -0,0 +1,205 @@
+package redisc
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/mna/redisc/redistest"
+	"github.com/mna/redisc/redistest/resp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMappingChangedSlots(t *testing.T) {
+	var m1, m2 Mapping
+	assert.Nil(t, m1.ChangedSlots(&m2), "empty mappings")
+
+	m1[1] = []string{"a", "b"}
+	m2[1] = []string{"a", "b"}
+	m1[2] = []string{"a"}
+	m2[2] = []string{"b"}
+	m2[3] = []string{"a", "c"}
+	m1[4] = []string{"a", "b"}
+	m2[4] = []string{"a", "c"}
+	assert.Equal(t, []int{2, 3, 4}, m1.ChangedSlots(&m2), "changed slots")
+	assert.Equal(t, []int{2, 3, 4}, m2.ChangedSlots(&m1), "changed slots")
+}
+
+func TestClusterOnRefresh(t *testing.T) {
+	var s1, s2 *redistest.MockServer
+	var failover int32
+	handler := func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			if atomic.LoadInt32(&failover) == 1 {
+				return mockClusterSlots(s2.Addr, s1.Addr)
+			}
+			return mockClusterSlots(s1.Addr, s2.Addr)
+		}
+		return resp.Error("unexpected command " + cmd)
+	}
+	s1 = redistest.StartMockServer(t, handler)
+	defer s1.Close()
+	s2 = redistest.StartMockServer(t, handler)
+	defer s2.Close()
+
+	var calls int
+	var old, cur *Mapping
+	c := &Cluster{
+		StartupNodes: []string{s1.Addr},
+		OnRefresh: func(o, n *Mapping) {
+			calls++
+			old, cur = o, n
+		},
+	}
+	defer c.Close()
+
+	require.NoError(t, c.Refresh(), "Refresh")
+	require.Equal(t, 1, calls, "initial mapping")
+	assert.Len(t, old.ChangedSlots(cur), hashSlots, "all slots changed")
+	assert.Equal(t, []string{s1.Addr, s2.Addr}, cur[0], "new mapping")
+
+	require.NoError(t, c.Refresh(), "Refresh")
+	assert.Equal(t, 1, calls, "unchanged mapping")
+
+	// a slot updated by a MOVED reply and restored by the refresh is not
+	// reported as changed
+	c.mu.Lock()
+	c.mapping[0] = []string{s2.Addr}
+	c.mu.Unlock()
+	require.NoError(t, c.Refresh(), "Refresh")
+	assert.Equal(t, 1, calls, "mapping patched by MOVED")
+
+	atomic.StoreInt32(&failover, 1)
+	require.NoError(t, c.Refresh(), "Refresh")
+	require.Equal(t, 2, calls, "failover")
+	assert.Equal(t, []string{s1.Addr, s2.Addr}, old[hashSlots-1], "old mapping")
+	assert.Equal(t, []string{s2.Addr, s1.Addr}, cur[hashSlots-1], "new mapping")
+}
+
+func TestClusterNodes(t *testing.T) {
+	c := &Cluster{}
+	assert.Empty(t, c.Nodes(), "no node")
+
+	for i := 0; i < 100; i++ {
+		c.mapping[i] = []string{"a", "b", "c"}
+	}
+	for i := 100; i < hashSlots; i++ {
+		c.mapping[i] = []string{"d", "e"}
+	}
+	c.mapping[50] = []string{"d", "e"}
+
+	want := []Node{
+		{Addr: "a", Slots: []SlotRange{{0, 49}, {51, 99}}},
+		{Addr: "b", Replica: true, Master: "a", Slots: []SlotRange{{0, 49}, {51, 99}}},
+		{Addr: "c", Replica: true, Master: "a", Slots: []SlotRange{{0, 49}, {51, 99}}},
+		{Addr: "d", Slots: []SlotRange{{50, 50}, {100, hashSlots - 1}}},
+		{Addr: "e", Replica: true, Master: "d", Slots: []SlotRange{{50, 50}, {100, hashSlots - 1}}},
+	}
+	assert.Equal(t, want, c.Nodes(), "nodes")
+}
+
+func TestClusterOnRefreshOrder(t *testing.T) {
+	var calls int
+	var old, cur *Mapping
+	c := &Cluster{
+		OnRefresh: func(o, n *Mapping) {
+			calls++
+			old, cur = o, n
+		},
+	}
+
+	var m1, m2, m3 Mapping
+	m1[0] = []string{"a"}
+	m2[0] = []string{"b"}
+	m3[0] = []string{"c"}
+
+	c.notifyRefresh(&m1, 1)
+	require.Equal(t, 1, calls, "first refresh")
+	assert.Nil(t, old[0], "old mapping")
+	assert.Equal(t, []string{"a"}, cur[0], "new mapping")
+
+	c.notifyRefresh(&m3, 3)
+	require.Equal(t, 2, calls, "third refresh")
+	assert.Equal(t, []string{"a"}, old[0], "old mapping")
+	assert.Equal(t, []string{"c"}, cur[0], "new mapping")
+
+	// the second refresh completed after the third one
+	c.notifyRefresh(&m2, 2)
+	assert.Equal(t, 2, calls, "stale refresh")
+}
+
+func TestClusterMapping(t *testing.T) {
+	c := &Cluster{}
+	m := c.Mapping()
+	for i := range m {
+		require.Empty(t, m[i], "no node for slot %d", i)
+	}
+
+	c.mapping[0] = []string{"a", "b"}
+	m = c.Mapping()
+	assert.Equal(t, []string{"a", "b"}, m[0], "master and replica")
+
+	// the snapshot is not affected by updates
+	c.mapping[0] = []string{"c"}
+	assert.Equal(t, []string{"a", "b"}, m[0], "snapshot")
+	assert.Equal(t, []string{"c"}, c.Mapping()[0], "new snapshot")
+}
+
+func TestClusterNodeForKey(t *testing.T) {
+	c := &Cluster{}
+	_, ok := c.NodeForKey("a")
+	assert.False(t, ok, "unassigned")
+
+	c.mapping[15495] = []string{"m1", "r1"}
+	addr, ok := c.NodeForKey("a")
+	assert.True(t, ok, "assigned")
+	assert.Equal(t, "m1", addr, "master")
+	addr, ok = c.NodeForKey("{a}b")
+	assert.True(t, ok, "hash tag")
+	assert.Equal(t, "m1", addr, "master for hash tag")
+	_, ok = c.NodeForKey("b")
+	assert.False(t, ok, "other slot")
+
+	c.KeyToSlot = func(key []byte) int { return 15495 }
+	addr, ok = c.NodeForKey("b")
+	assert.True(t, ok, "KeyToSlot")
+	assert.Equal(t, "m1", addr, "master with KeyToSlot")
+}
+
+func TestClusterUnassignedSlots(t *testing.T) {
+	var s *redistest.MockServer
+	var full int32
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		node := resp.Array{0: "127.0.0.1", 1: int64(mockPort(s.Addr))}
+		if atomic.LoadInt32(&full) == 1 {
+			return resp.Array{0: resp.Array{0: int64(0), 1: int64(hashSlots - 1), 2: node}}
+		}
+		return resp.Array{
+			0: resp.Array{0: int64(0), 1: int64(100), 2: node},
+			1: resp.Array{0: int64(200), 1: int64(hashSlots - 1), 2: node},
+		}
+	})
+	defer s.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s.Addr},
+	}
+	defer c.Close()
+	assert.Len(t, c.UnassignedSlots(), hashSlots, "before refresh")
+
+	require.NoError(t, c.Refresh(), "Refresh")
+	slots := c.UnassignedSlots()
+	if assert.Len(t, slots, 99, "partial coverage") {
+		assert.Equal(t, 101, slots[0], "first unassigned")
+		assert.Equal(t, 199, slots[len(slots)-1], "last unassigned")
+	}
+
+	atomic.StoreInt32(&full, 1)
+	require.NoError(t, c.Refresh(), "Refresh")
+	assert.Empty(t, c.UnassignedSlots(), "full coverage")
+
+	atomic.StoreInt32(&full, 0)
+	require.NoError(t, c.Refresh(), "Refresh")
+	assert.Len(t, c.UnassignedSlots(), 99, "slots unassigned again")
+}
@@ -0,0 +1,64 @@
+package redisc
+
+import (
+	"github.com/garyburd/redigo/redis"
+)
+
+// ObjectEncoding returns the internal encoding of the value stored at key
+// (e.g. "listpack" or "hashtable"), by executing the OBJECT ENCODING
+// command on the master node that serves the slot of the key. It returns
+// redis.ErrNil if the key does not exist.
+func (c *Cluster) ObjectEncoding(key string) (string, error) {
+	return redis.String(c.doOnKey(key, "OBJECT", "ENCODING", key))
+}
+
+// ObjectIdleTime returns the number of seconds since the value stored at
+// key was last accessed, by executing the OBJECT IDLETIME command on the
+// master node that serves the slot of the key.
+func (c *Cluster) ObjectIdleTime(key string) (int64, error) {
+	return redis.Int64(c.doOnKey(key, "OBJECT", "IDLETIME", key))
+}
+
+// ObjectFreq returns the logarithmic access frequency counter of the
+// value stored at key, by executing the OBJECT FREQ command on the master
+// node that serves the slot of the key. The node must use an LFU
+// maxmemory-policy.
+func (c *Cluster) ObjectFreq(key string) (int64, error) {
+	return redis.Int64(c.doOnKey(key, "OBJECT", "FREQ", key))
+}
+
+// ObjectRefCount returns the reference count of the value stored at key,
+// by executing the OBJECT REFCOUNT command on the master node that serves
+// the slot of the key.
+func (c *Cluster) ObjectRefCount(key string) (int64, error) {
+	return redis.Int64(c.doOnKey(key, "OBJECT", "REFCOUNT", key))
+}
+
+// MemoryUsage returns the number of bytes used by the key and its value,
+// by executing the MEMORY USAGE command on the master node that serves
+// the slot of the key. It returns redis.ErrNil if the key does not exist.
+func (c *Cluster) MemoryUsage(key string) (int64, error) {
+	return redis.Int64(c.doOnKey(key, "MEMORY", "USAGE", key))
+}
+
+// DebugObject returns the debugging information about the value stored at
+// key, by executing the DEBUG OBJECT command on the master node that
+// serves the slot of the key. The DEBUG command may be disabled on the
+// nodes (the enable-debug-command configuration of redis 7+).
+func (c *Cluster) DebugObject(key string) (string, error) {
+	return redis.String(c.doOnKey(key, "DEBUG", "OBJECT", key))
+}
+
+// doOnKey executes the command on a connection bound to the master of the
+// slot of key. It is used for commands that have the key in another
+// position than their first parameter.
+func (c *Cluster) doOnKey(key, cmd string, args ...interface{}) (interface{}, error) {
+	conn := c.Get()
+	defer conn.Close()
+
+	cc := conn.(*Conn)
+	if err := cc.Bind(key); err != nil {
+		return nil, err
+	}
+	return cc.Do(cmd, args...)
+}
@@ -0,0 +1,77 @@
+package redisc
+
+import (
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/mna/redisc/redistest"
+	"github.com/mna/redisc/redistest/resp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClusterObject(t *testing.T) {
+	var s1, s2 *redistest.MockServer
+	handler := func(name string) func(string, ...string) interface{} {
+		return func(cmd string, args ...string) interface{} {
+			switch cmd {
+			case "CLUSTER":
+				return resp.Array{
+					0: resp.Array{0: int64(0), 1: int64(8000), 2: resp.Array{0: "127.0.0.1", 1: int64(mockPort(s1.Addr))}},
+					1: resp.Array{0: int64(8001), 1: int64(16383), 2: resp.Array{0: "127.0.0.1", 1: int64(mockPort(s2.Addr))}},
+				}
+			case "OBJECT", "MEMORY", "DEBUG":
+				// "a" is in slot 15495, "b" in 3300
+				if want := map[string]string{"a": "s2", "b": "s1"}[args[1]]; want != name {
+					return resp.Error("MOVED 1 " + want)
+				}
+				switch args[0] {
+				case "ENCODING":
+					if args[1] == "b" {
+						return nil
+					}
+					return name + ":embstr"
+				case "IDLETIME", "FREQ", "REFCOUNT", "USAGE":
+					return int64(len(args[0]))
+				case "OBJECT":
+					return resp.SimpleString(name + ":Value at:0x1 refcount:1")
+				}
+			}
+			return resp.Error("unexpected command " + cmd)
+		}
+	}
+	s1 = redistest.StartMockServer(t, handler("s1"))
+	defer s1.Close()
+	s2 = redistest.StartMockServer(t, handler("s2"))
+	defer s2.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s1.Addr},
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	enc, err := c.ObjectEncoding("a")
+	if assert.NoError(t, err, "ObjectEncoding") {
+		assert.Equal(t, "s2:embstr", enc, "encoding")
+	}
+	_, err = c.ObjectEncoding("b")
+	assert.Equal(t, redis.ErrNil, err, "ObjectEncoding of missing key")
+
+	for name, fn := range map[string]func(string) (int64, error){
+		"IDLETIME": c.ObjectIdleTime,
+		"FREQ":     c.ObjectFreq,
+		"REFCOUNT": c.ObjectRefCount,
+		"USAGE":    c.MemoryUsage,
+	} {
+		n, err := fn("b")
+		if assert.NoError(t, err, name) {
+			assert.Equal(t, int64(len(name)), n, name)
+		}
+	}
+
+	v, err := c.DebugObject("b")
+	if assert.NoError(t, err, "DebugObject") {
+		assert.Equal(t, "s1:Value at:0x1 refcount:1", v, "debug")
+	}
+}
@@ -0,0 +1,48 @@
+package redisc
+
+import "time"
+
+// Observer is the interface that receives notifications about the
+// commands executed on the cluster, e.g. to record metrics about their
+// latency and the redirections and retries they cause. Only the commands
+// executed with Do (including DoContext, RetryConn and the Cluster's
+// helper methods) are reported, not those sent with Send. The methods may
+// be called concurrently, synchronously with the commands, so they should
+// return quickly.
+type Observer interface {
+	// OnCommand is called after the command cmd was executed on the node
+	// at addr, with the slot of its key (-1 if it has no key), the time it
+	// took and the error it returned, if any.
+	OnCommand(cmd string, slot int, addr string, dur time.Duration, err error)
+
+	// OnRedirect is called when a command returned a redirection error,
+	// with the kind of redirection ("MOVED" or "ASK").
+	OnRedirect(kind string)
+
+	// OnRetry is called by a RetryConn before it executes a command again
+	// after a retryable error (e.g. TRYAGAIN), with the number of the next
+	// attempt and the error. Redirections are reported by OnRedirect.
+	OnRetry(cmd string, attempt int, err error)
+}
+
+// PoolWaitObserver is an optional interface that the Observer of a
+// cluster can implement to be notified of the time spent to get a
+// connection from the pool of a node (see CreatePool), e.g. to detect
+// that the MaxActive connections of a pool are all in use. The duration
+// includes the time to dial a new connection when the pool has no idle
+// connection.
+type PoolWaitObserver interface {
+	// OnPoolWait is called after a connection was requested from the pool
+	// of the node at addr, whether it succeeded or not, with the time it
+	// took.
+	OnPoolWait(addr string, waited time.Duration)
+}
+
+// observeCommand reports the command to the cluster's Observer, if any.
+// It must only be called if the Observer is set.
+func (c *Cluster) observeCommand(cmd string, args []interface{}, addr string, start time.Time, err error) {
+	c.Observer.OnCommand(cmd, c.cmdSlot(cmd, args), addr, time.Since(start), err)
+	if re := ParseRedir(err); re != nil {
+		c.Observer.OnRedirect(re.Type)
+	}
+}
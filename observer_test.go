@@ -0,0 +1,156 @@
+package redisc
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/mna/redisc/redistest"
+	"github.com/mna/redisc/redistest/resp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type observedCmd struct {
+	cmd  string
+	slot int
+	addr string
+	err  bool
+}
+
+type testObserver struct {
+	mu        sync.Mutex
+	cmds      []observedCmd
+	redirects []string
+	retries   []int
+}
+
+func (o *testObserver) OnCommand(cmd string, slot int, addr string, dur time.Duration, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.cmds = append(o.cmds, observedCmd{cmd, slot, addr, err != nil})
+}
+
+func (o *testObserver) OnRedirect(kind string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.redirects = append(o.redirects, kind)
+}
+
+func (o *testObserver) OnRetry(cmd string, attempt int, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.retries = append(o.retries, attempt)
+}
+
+func TestClusterObserver(t *testing.T) {
+	var s *redistest.MockServer
+	var calls int32
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return mockClusterSlots(s.Addr)
+		case "GET":
+			switch atomic.AddInt32(&calls, 1) {
+			case 1:
+				return resp.Error("MOVED 1234 " + s.Addr)
+			case 2:
+				return resp.Error("TRYAGAIN")
+			}
+			return args[0]
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	var o testObserver
+	c := &Cluster{
+		StartupNodes: []string{s.Addr},
+		Observer:     &o,
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	conn := c.Get()
+	defer conn.Close()
+	rc, err := RetryConn(conn, 5, time.Millisecond)
+	require.NoError(t, err, "RetryConn")
+	v, err := redis.String(rc.Do("GET", "a"))
+	require.NoError(t, err, "GET")
+	assert.Equal(t, "a", v, "GET result")
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	slot := Slot("a")
+	assert.Equal(t, []observedCmd{
+		{"GET", slot, s.Addr, true},
+		{"GET", slot, s.Addr, true},
+		{"GET", slot, s.Addr, false},
+	}, o.cmds, "commands")
+	assert.Equal(t, []string{"MOVED"}, o.redirects, "redirections")
+	assert.Equal(t, []int{3}, o.retries, "retries")
+}
+
+type poolWaitObserver struct {
+	testObserver
+	waits []time.Duration
+}
+
+func (o *poolWaitObserver) OnPoolWait(addr string, waited time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.waits = append(o.waits, waited)
+}
+
+func TestClusterPoolWaitObserver(t *testing.T) {
+	var s *redistest.MockServer
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return mockClusterSlots(s.Addr)
+		case "PING":
+			return resp.Pong{}
+		case "GET":
+			return args[0]
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	var o poolWaitObserver
+	c := &Cluster{
+		StartupNodes: []string{s.Addr},
+		Observer:     &o,
+		CreatePool: func(addr string, opts ...redis.DialOption) (*redis.Pool, error) {
+			p, err := createPool(addr, opts...)
+			if err != nil {
+				return nil, err
+			}
+			p.MaxActive = 1
+			p.Wait = true
+			return p, nil
+		},
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	// hold the only connection of the pool for a while
+	conn1 := c.Get()
+	_, err := conn1.Do("GET", "a")
+	require.NoError(t, err, "GET")
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		conn1.Close()
+	}()
+
+	_, err = c.Do("GET", "a")
+	require.NoError(t, err, "GET")
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if assert.Len(t, o.waits, 2, "pool waits") {
+		assert.True(t, o.waits[1] >= 40*time.Millisecond, "waited for the connection: %s", o.waits[1])
+	}
+}
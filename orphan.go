@@ -0,0 +1,67 @@
+package redisc
+
+import "time"
+
+// reapPoolsLocked closes the pools of the nodes that don't serve any slot,
+// once they have been orphaned for OrphanPoolTTL. If some orphaned pools
+// are kept, it schedules a call to close them when the first TTL expires.
+// The cluster's lock must be held.
+func (c *Cluster) reapPoolsLocked() {
+	now := time.Now()
+	var next time.Duration
+	for addr, p := range c.pools {
+		if c.masters[addr] || c.replicas[addr] {
+			delete(c.orphans, addr)
+			continue
+		}
+
+		since, ok := c.orphans[addr]
+		if !ok {
+			if c.orphans == nil {
+				c.orphans = make(map[string]time.Time)
+			}
+			since = now
+			c.orphans[addr] = since
+		}
+		if left := c.OrphanPoolTTL - now.Sub(since); left > 0 {
+			if next == 0 || left < next {
+				next = left
+			}
+			continue
+		}
+
+		p.Close()
+		delete(c.pools, addr)
+		delete(c.orphans, addr)
+		c.logf("redisc: closed pool for %s", addr)
+	}
+
+	// remove the orphans for which the pool was closed
+	for addr := range c.orphans {
+		if c.pools[addr] == nil {
+			delete(c.orphans, addr)
+		}
+	}
+
+	if c.reapTimer != nil {
+		c.reapTimer.Stop()
+		c.reapTimer = nil
+	}
+	if next > 0 {
+		var t *time.Timer
+		t = time.AfterFunc(next, func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+
+			if c.reapTimer != t {
+				// stopped or replaced in the meantime
+				return
+			}
+			c.reapTimer = nil
+			if c.err == nil {
+				c.reapPoolsLocked()
+			}
+		})
+		c.reapTimer = t
+	}
+}
@@ -0,0 +1,78 @@
+package redisc
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mna/redisc/redistest"
+	"github.com/mna/redisc/redistest/resp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClusterOrphanPools(t *testing.T) {
+	var s1, s2 *redistest.MockServer
+	var removed int32
+	handler := func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			if atomic.LoadInt32(&removed) == 1 {
+				return mockClusterSlots(s1.Addr)
+			}
+			return resp.Array{
+				0: resp.Array{0: int64(0), 1: int64(8000), 2: resp.Array{0: "", 1: int64(mockPort(s1.Addr))}},
+				1: resp.Array{0: int64(8001), 1: int64(16383), 2: resp.Array{0: "", 1: int64(mockPort(s2.Addr))}},
+			}
+		case "GET":
+			return args[0]
+		case "PING":
+			return "PONG"
+		}
+		return resp.Error("unexpected command " + cmd)
+	}
+	s1 = redistest.StartMockServer(t, handler)
+	defer s1.Close()
+	s2 = redistest.StartMockServer(t, handler)
+	defer s2.Close()
+
+	c := &Cluster{
+		StartupNodes:  []string{s1.Addr},
+		CreatePool:    createPool,
+		OrphanPoolTTL: 100 * time.Millisecond,
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	use := func(key string) {
+		conn := c.Get()
+		defer conn.Close()
+		_, err := conn.Do("GET", key)
+		require.NoError(t, err, "GET %s", key)
+	}
+	c.mu.Lock()
+	addr1, addr2 := c.mapping[0][0], c.mapping[hashSlots-1][0]
+	c.mu.Unlock()
+	use("b") // slot 3300
+	use("a") // slot 15495
+	require.Len(t, c.Stats(), 2, "pools for both nodes")
+
+	// the node of s2 is removed, its pool is kept for the TTL
+	atomic.StoreInt32(&removed, 1)
+	require.NoError(t, c.Refresh(), "Refresh")
+	assert.Contains(t, c.Stats(), addr2, "orphaned pool kept")
+
+	// the node returns within the TTL, its pool is kept
+	atomic.StoreInt32(&removed, 0)
+	require.NoError(t, c.Refresh(), "Refresh")
+	time.Sleep(150 * time.Millisecond)
+	assert.Contains(t, c.Stats(), addr2, "pool of returning node kept")
+
+	// the node is removed again, the pool is closed after the TTL
+	atomic.StoreInt32(&removed, 1)
+	require.NoError(t, c.Refresh(), "Refresh")
+	time.Sleep(150 * time.Millisecond)
+	stats := c.Stats()
+	assert.NotContains(t, stats, addr2, "orphaned pool closed")
+	assert.Contains(t, stats, addr1, "pool of remaining node kept")
+}
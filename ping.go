@@ -0,0 +1,76 @@
+package redisc
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// pingTimeout is the maximum time to wait for a node to reply to the
+// PING of Ping and PingAll, including the time to get the connection.
+const pingTimeout = 2 * time.Second
+
+// PingError is the error returned by Ping and PingAll when some nodes
+// could not be reached. It maps the address of those nodes to their
+// error.
+type PingError map[string]error
+
+// Error returns the list of unreachable nodes with their error, ordered
+// by address.
+func (e PingError) Error() string {
+	addrs := make([]string, 0, len(e))
+	for addr := range e {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	var b strings.Builder
+	b.WriteString("redisc: unreachable nodes: ")
+	for i, addr := range addrs {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(addr + " (" + e[addr].Error() + ")")
+	}
+	return b.String()
+}
+
+// Ping sends a PING command to each master node of the cluster,
+// concurrently, e.g. for a readiness probe. It returns nil if all nodes
+// replied, otherwise a PingError with the nodes that failed. The
+// connections are taken from the pools if CreatePool is set, and each
+// node must reply within 2 seconds.
+func (c *Cluster) Ping() error {
+	return c.ping(c.getNodeAddrs(false))
+}
+
+// PingAll is like Ping, but it also sends the PING command to the
+// replica nodes.
+func (c *Cluster) PingAll() error {
+	return c.ping(c.allNodeAddrs())
+}
+
+func (c *Cluster) ping(addrs []string) error {
+	if len(addrs) == 0 {
+		return errors.New("redisc: no node to ping")
+	}
+	_, errs := c.onEach(addrs, func(addr string) (interface{}, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+		defer cancel()
+
+		conn, err := c.getConnForAddr(ctx, addr, false)
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+		return redis.DoWithTimeout(conn, pingTimeout, "PING")
+	})
+	if len(errs) > 0 {
+		return PingError(errs)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package redisc
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mna/redisc/redistest"
+	"github.com/mna/redisc/redistest/resp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClusterPing(t *testing.T) {
+	var s1, s2 *redistest.MockServer
+	s1 = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return mockClusterSlots(s1.Addr, s2.Addr)
+		case "PING":
+			return resp.Pong{}
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s1.Close()
+	s2 = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		return resp.Error("LOADING loading the dataset")
+	})
+	defer s2.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s1.Addr},
+	}
+	defer c.Close()
+	assert.NoError(t, c.Ping(), "Ping startup node")
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	assert.NoError(t, c.Ping(), "Ping")
+	err := c.PingAll()
+	var perr PingError
+	if assert.True(t, errors.As(err, &perr), "PingError") {
+		assert.Len(t, perr, 1, "failed nodes")
+		assert.Contains(t, perr, s2.Addr, "replica failed")
+		assert.Equal(t, "redisc: unreachable nodes: "+s2.Addr+" (LOADING loading the dataset)", err.Error(), "message")
+	}
+
+	s1.Close()
+	assert.Error(t, c.Ping(), "Ping closed node")
+}
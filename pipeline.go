@@ -0,0 +1,153 @@
+package redisc
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Pipeline buffers commands to execute them on the cluster with a single
+// round-trip per node, regardless of the slots of their keys. As for
+// Conn.Do, the first parameter of a command is assumed to be its key.
+// When the pipeline is flushed, the commands are grouped by the node that
+// serves the slot of their key, and each group is sent on its own
+// connection, concurrently. The replies are then returned by Receive in
+// the same order as the commands were sent.
+//
+// Commands without a key, or whose slot is not served by a known node,
+// are sent together to a random node. A Pipeline is not safe for
+// concurrent use.
+type Pipeline struct {
+	cluster *Cluster
+	cmds    []pipelineCmd
+	replies []pipelineReply
+}
+
+type pipelineCmd struct {
+	cmd  string
+	args []interface{}
+}
+
+type pipelineReply struct {
+	v   interface{}
+	err error
+}
+
+// Pipeline returns a new, empty pipeline of commands for the cluster.
+func (c *Cluster) Pipeline() *Pipeline {
+	return &Pipeline{cluster: c}
+}
+
+// Send adds the command to the pipeline. It is only sent to the cluster
+// when Flush is called.
+func (p *Pipeline) Send(cmd string, args ...interface{}) {
+	p.cmds = append(p.cmds, pipelineCmd{cmd: cmd, args: args})
+}
+
+// Flush sends the commands added since the last call to Flush to the
+// cluster and waits for all replies. The replies, including the errors
+// returned for individual commands (e.g. a MOVED error if a slot moved
+// since the last refresh of the mapping), are then available via
+// Receive. If the connection to a node fails, Flush returns that error,
+// and the same error is returned by Receive for all the commands sent to
+// that node.
+func (p *Pipeline) Flush() error {
+	cmds := p.cmds
+	p.cmds = nil
+
+	// compute the slots before locking the cluster, as it may call the
+	// KeyToSlot and CommandKeys functions or query the server for the keys
+	slots := make([]int, len(cmds))
+	for i, cmd := range cmds {
+		slots[i] = p.cluster.cmdSlot(cmd.cmd, cmd.args)
+	}
+
+	// group the commands by the node that serves their slot
+	var groups [][]int
+	byAddr := make(map[string]int)
+	p.cluster.mu.RLock()
+	for i := range cmds {
+		var addr string
+		if slot := slots[i]; slot >= 0 {
+			if addrs := p.cluster.mapping[slot]; len(addrs) > 0 {
+				addr = addrs[0]
+			}
+		}
+		gi, ok := byAddr[addr]
+		if !ok {
+			gi = len(groups)
+			byAddr[addr] = gi
+			groups = append(groups, nil)
+		}
+		groups[gi] = append(groups[gi], i)
+	}
+	p.cluster.mu.RUnlock()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	replies := make([]pipelineReply, len(cmds))
+	wg.Add(len(groups))
+	for _, ixs := range groups {
+		go func(ixs []int) {
+			defer wg.Done()
+
+			if err := p.run(cmds, ixs, replies); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}(ixs)
+	}
+	wg.Wait()
+
+	p.replies = append(p.replies, replies...)
+	return firstErr
+}
+
+// run sends the commands at indices ixs on a single connection and stores
+// their replies at the same indices. It returns the connection's error,
+// if any.
+func (p *Pipeline) run(cmds []pipelineCmd, ixs []int, replies []pipelineReply) error {
+	conn := p.cluster.Get()
+	defer conn.Close()
+
+	err := sendCmds(conn, cmds, ixs)
+	if err != nil {
+		for _, ix := range ixs {
+			replies[ix].err = err
+		}
+		return err
+	}
+	for _, ix := range ixs {
+		replies[ix].v, replies[ix].err = conn.Receive()
+	}
+	return conn.Err()
+}
+
+func sendCmds(conn redis.Conn, cmds []pipelineCmd, ixs []int) error {
+	for _, ix := range ixs {
+		if err := conn.Send(cmds[ix].cmd, cmds[ix].args...); err != nil {
+			return err
+		}
+	}
+	return conn.Flush()
+}
+
+// Receive returns the reply of the next command flushed with Flush, in
+// the order the commands were sent. It returns an error if there is no
+// such reply.
+func (p *Pipeline) Receive() (interface{}, error) {
+	if len(p.replies) == 0 {
+		return nil, errors.New("redisc: no pending reply in pipeline")
+	}
+	r := p.replies[0]
+	p.replies[0] = pipelineReply{}
+	p.replies = p.replies[1:]
+	return r.v, r.err
+}
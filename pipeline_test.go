@@ -0,0 +1,141 @@
+package redisc
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/mna/redisc/redistest"
+	"github.com/mna/redisc/redistest/resp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPipeline(t *testing.T) {
+	var s1, s2 *redistest.MockServer
+	handler := func(node string) func(string, ...string) interface{} {
+		return func(cmd string, args ...string) interface{} {
+			switch cmd {
+			case "CLUSTER":
+				return resp.Array{
+					0: resp.Array{0: int64(0), 1: int64(8000), 2: resp.Array{0: "127.0.0.1", 1: int64(mockPort(s1.Addr))}},
+					1: resp.Array{0: int64(8001), 1: int64(16383), 2: resp.Array{0: "127.0.0.1", 1: int64(mockPort(s2.Addr))}},
+				}
+			case "GET":
+				if args[0] == "moved" {
+					return resp.Error("MOVED 1234 127.0.0.1" + s1.Addr)
+				}
+				return node + ":" + args[0]
+			case "PING":
+				return "PONG"
+			}
+			return resp.Error("unexpected command " + cmd)
+		}
+	}
+	s1 = redistest.StartMockServer(t, handler("s1"))
+	defer s1.Close()
+	s2 = redistest.StartMockServer(t, handler("s2"))
+	defer s2.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s1.Addr},
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	// "b" and "f" are served by s1, "a" and "moved" by s2
+	p := c.Pipeline()
+	p.Send("GET", "a")
+	p.Send("GET", "b")
+	p.Send("GET", "moved")
+	p.Send("PING")
+	p.Send("GET", "f")
+	require.NoError(t, p.Flush(), "Flush")
+
+	for i, want := range []string{"s2:a", "s1:b", "", "PONG", "s1:f"} {
+		v, err := redis.String(p.Receive())
+		if want == "" {
+			if assert.Error(t, err, "Receive %d", i) {
+				assert.NotNil(t, ParseRedir(err), "MOVED error %d", i)
+			}
+			continue
+		}
+		if assert.NoError(t, err, "Receive %d", i) {
+			assert.Equal(t, want, v, "Receive %d", i)
+		}
+	}
+	_, err := p.Receive()
+	assert.Error(t, err, "no more replies")
+
+	// the pipeline can be reused
+	p.Send("GET", "b")
+	require.NoError(t, p.Flush(), "Flush")
+	v, err := redis.String(p.Receive())
+	if assert.NoError(t, err, "Receive") {
+		assert.Equal(t, "s1:b", v, "Receive")
+	}
+}
+
+func TestPipelineConnError(t *testing.T) {
+	// get an address where nothing listens
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err, "Listen")
+	addr := l.Addr().String()
+	l.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{addr},
+	}
+	defer c.Close()
+
+	p := c.Pipeline()
+	p.Send("GET", "a")
+	p.Send("GET", "b")
+	assert.Error(t, p.Flush(), "Flush")
+	for i := 0; i < 2; i++ {
+		_, err := p.Receive()
+		assert.Error(t, err, "Receive %d", i)
+	}
+}
+
+func TestPipelineServerKeyExtraction(t *testing.T) {
+	var s *redistest.MockServer
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return mockClusterSlots(s.Addr)
+		case "COMMAND":
+			return resp.Array{resp.Array{"mymod.get", int64(3), resp.Array{resp.SimpleString("readonly")}, int64(2), int64(2), int64(1)}}
+		case "MYMOD.GET":
+			return args[1]
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	c := &Cluster{
+		StartupNodes:           []string{s.Addr},
+		UseServerKeyExtraction: true,
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	// the key positions are queried from the server during Flush, which
+	// must not hold the cluster's lock while doing so
+	done := make(chan error, 1)
+	p := c.Pipeline()
+	p.Send("MYMOD.GET", "opt", "a")
+	go func() { done <- p.Flush() }()
+	select {
+	case err := <-done:
+		require.NoError(t, err, "Flush")
+	case <-time.After(5 * time.Second):
+		t.Fatal("Flush did not return")
+	}
+
+	v, err := redis.String(p.Receive())
+	if assert.NoError(t, err, "Receive") {
+		assert.Equal(t, "a", v, "Receive")
+	}
+}
@@ -0,0 +1,40 @@
+package redisc
+
+import (
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Defaults used by DefaultCreatePool.
+const (
+	defaultPoolMaxIdle     = 10
+	defaultPoolIdleTimeout = 5 * time.Minute
+	defaultPoolTestIdle    = time.Minute
+	defaultMaxConnLifetime = time.Hour
+)
+
+// DefaultCreatePool is a function that can be used as the CreatePool
+// field of a Cluster. It returns a redis.Pool for the node at address
+// that dials its connections with the provided options, keeps up to 10
+// idle connections, closes the connections idle for more than 5 minutes
+// and checks with a PING the connections idle for more than a minute
+// when they are taken from the pool. The connections are rotated after
+// an hour, unless the MaxConnLifetime field of the Cluster is set.
+func DefaultCreatePool(address string, options ...redis.DialOption) (*redis.Pool, error) {
+	return &redis.Pool{
+		MaxIdle:         defaultPoolMaxIdle,
+		IdleTimeout:     defaultPoolIdleTimeout,
+		MaxConnLifetime: defaultMaxConnLifetime,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", address, options...)
+		},
+		TestOnBorrow: func(c redis.Conn, t time.Time) error {
+			if time.Since(t) < defaultPoolTestIdle {
+				return nil
+			}
+			_, err := c.Do("PING")
+			return err
+		},
+	}, nil
+}
@@ -0,0 +1,76 @@
+package redisc
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/mna/redisc/redistest"
+	"github.com/mna/redisc/redistest/resp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClusterMaxConnLifetime(t *testing.T) {
+	var s *redistest.MockServer
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch strings.ToUpper(cmd) {
+		case "CLUSTER":
+			return mockClusterSlots(s.Addr)
+		case "PING":
+			return resp.Pong{}
+		case "GET":
+			return nil
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	for _, lifetime := range []time.Duration{0, 10 * time.Millisecond} {
+		var dials int32
+		c := &Cluster{
+			StartupNodes: []string{s.Addr},
+			CreatePool: func(addr string, opts ...redis.DialOption) (*redis.Pool, error) {
+				p, err := DefaultCreatePool(addr, opts...)
+				if err != nil {
+					return nil, err
+				}
+				dial := p.Dial
+				p.Dial = func() (redis.Conn, error) {
+					atomic.AddInt32(&dials, 1)
+					return dial()
+				}
+				return p, nil
+			},
+			MaxConnLifetime: lifetime,
+		}
+		require.NoError(t, c.Refresh(), "Refresh")
+
+		_, err := c.Do("GET", "a")
+		require.NoError(t, err, "GET")
+		c.mu.RLock()
+		p := c.pools[s.Addr]
+		c.mu.RUnlock()
+		require.NotNil(t, p, "pool")
+
+		want := defaultMaxConnLifetime
+		if lifetime > 0 {
+			want = lifetime
+		}
+		assert.Equal(t, want, p.MaxConnLifetime, "MaxConnLifetime %s", lifetime)
+		assert.Equal(t, 1, p.Stats().IdleCount, "idle connection %s", lifetime)
+
+		// the connection is reused unless it expired
+		time.Sleep(20 * time.Millisecond)
+		_, err = c.Do("GET", "a")
+		require.NoError(t, err, "GET")
+		wantDials := int32(1)
+		if lifetime > 0 {
+			wantDials = 2
+		}
+		assert.Equal(t, wantDials, atomic.LoadInt32(&dials), "dials %s", lifetime)
+		require.NoError(t, c.Close(), "Close")
+	}
+}
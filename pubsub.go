@@ -0,0 +1,413 @@
+package redisc
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Reconnection is the value returned by PubSubConn.Receive when the
+// connection to the node was lost and a new connection was successfully
+// established, with all subscriptions restored.
+type Reconnection struct {
+	// Err is the error that caused the reconnection.
+	Err error
+}
+
+// PubSubConn is a publish-subscribe connection to a redis cluster. It
+// wraps a redigo redis.PubSubConn and keeps track of the channels and
+// patterns it is subscribed to, so that if the connection to the node is
+// lost (e.g. because of a failover), the cluster's mapping is refreshed,
+// a new connection is made and the subscriptions are restored
+// automatically (or by the OnReconnect callback, if it is set). Such a
+// reconnection is reported to the caller by a Reconnection value returned
+// by Receive. Listen returns the values of Receive on a Go channel.
+//
+// The connection is never managed by a pool, it is created with
+// Cluster.Dial. Messages published with PUBLISH are broadcast to all
+// nodes of a cluster, so the connection can be made to any node.
+//
+// Sharded channels (redis 7+), subscribed to with SSubscribe, are
+// different: they are hashed to a slot like keys, and only the node
+// that owns that slot delivers the messages. A PubSubConn is connected
+// to a single node, so all its sharded channels must belong to the same
+// slot, and SSubscribe must be called before Subscribe or PSubscribe so
+// that the connection is bound to the node that owns that slot. When the
+// slot moves to another node, the server unsubscribes the connection
+// from the sharded channels; this is detected by Receive, which then
+// reconnects to the new owner of the slot and restores the subscriptions.
+//
+// The same concurrency rules as for redigo's redis.PubSubConn apply.
+type PubSubConn struct {
+	// OnReconnect, if not nil, is called when the connection to the node
+	// was lost and a new connection was established, before Receive
+	// returns the Reconnection value. The subscriptions are then not
+	// restored automatically: the new connection has no subscription, and
+	// OnReconnect is responsible for restoring those that are still
+	// needed. It receives a resubscribe function that subscribes the new
+	// connection to the channels, and the other methods of the PubSubConn
+	// (e.g. PSubscribe or SSubscribe) can be called too. It must be set
+	// before the first call to Receive.
+	OnReconnect func(resubscribe func(channels ...string) error)
+
+	cluster *Cluster
+
+	mu        sync.Mutex
+	psc       redis.PubSubConn
+	channels  map[string]bool
+	patterns  map[string]bool
+	schannels map[string]bool
+	closed    bool
+}
+
+// PubSub returns a publish-subscribe connection to the cluster. The
+// application must close the connection after use.
+func (c *Cluster) PubSub() (*PubSubConn, error) {
+	conn, err := c.Dial()
+	if err != nil {
+		return nil, err
+	}
+	return &PubSubConn{
+		cluster:   c,
+		psc:       redis.PubSubConn{Conn: conn},
+		channels:  make(map[string]bool),
+		patterns:  make(map[string]bool),
+		schannels: make(map[string]bool),
+	}, nil
+}
+
+// Subscribe subscribes the connection to the specified channels.
+func (p *PubSubConn) Subscribe(channel ...interface{}) error {
+	return p.subscribe(p.channels, true, nil, redis.PubSubConn.Subscribe, channel)
+}
+
+// PSubscribe subscribes the connection to the given patterns.
+func (p *PubSubConn) PSubscribe(channel ...interface{}) error {
+	return p.subscribe(p.patterns, true, nil, redis.PubSubConn.PSubscribe, channel)
+}
+
+// Unsubscribe unsubscribes the connection from the given channels, or
+// from all of them if none is given.
+func (p *PubSubConn) Unsubscribe(channel ...interface{}) error {
+	return p.subscribe(p.channels, false, nil, redis.PubSubConn.Unsubscribe, channel)
+}
+
+// PUnsubscribe unsubscribes the connection from the given patterns, or
+// from all of them if none is given.
+func (p *PubSubConn) PUnsubscribe(channel ...interface{}) error {
+	return p.subscribe(p.patterns, false, nil, redis.PubSubConn.PUnsubscribe, channel)
+}
+
+// SSubscribe subscribes the connection to the specified sharded
+// channels. All sharded channels of the connection must belong to the
+// same slot, otherwise an error is returned.
+func (p *PubSubConn) SSubscribe(channel ...interface{}) error {
+	return p.subscribe(p.schannels, true, p.checkSameSlot, sendPubSub("SSUBSCRIBE"), channel)
+}
+
+// checkSameSlot returns an error if the channels and the ones already in
+// set do not all belong to the same slot.
+func (p *PubSubConn) checkSameSlot(set map[string]bool, channels []interface{}) error {
+	slot := -1
+	var first string
+	for k := range set {
+		slot, first = p.cluster.slot(k), k
+		break
+	}
+	for _, ch := range channels {
+		name := fmt.Sprintf("%s", ch)
+		cs := p.cluster.slot(name)
+		if slot == -1 {
+			slot, first = cs, name
+		} else if cs != slot {
+			return fmt.Errorf("%w: sharded channels %q and %q", ErrCrossSlot, first, name)
+		}
+	}
+	return nil
+}
+
+// SUnsubscribe unsubscribes the connection from the given sharded
+// channels, or from all of them if none is given.
+func (p *PubSubConn) SUnsubscribe(channel ...interface{}) error {
+	return p.subscribe(p.schannels, false, nil, sendPubSub("SUNSUBSCRIBE"), channel)
+}
+
+// sendPubSub returns a function that sends and flushes the cmd pub-sub
+// command, for commands not supported by redigo's redis.PubSubConn.
+func sendPubSub(cmd string) func(redis.PubSubConn, ...interface{}) error {
+	return func(psc redis.PubSubConn, channel ...interface{}) error {
+		if err := psc.Conn.Send(cmd, channel...); err != nil {
+			return err
+		}
+		return psc.Conn.Flush()
+	}
+}
+
+// subscribe calls fn to (un)subscribe from the channels and updates set
+// accordingly. If check is not nil, it is called with set and the
+// channels before fn, and fn is not called if it returns an error.
+func (p *PubSubConn) subscribe(set map[string]bool, sub bool,
+	check func(map[string]bool, []interface{}) error,
+	fn func(redis.PubSubConn, ...interface{}) error, channels []interface{}) error {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return errors.New("redisc: closed")
+	}
+	if check != nil {
+		if err := check(set, channels); err != nil {
+			return err
+		}
+	}
+	if err := fn(p.psc, channels...); err != nil {
+		return err
+	}
+
+	if !sub && len(channels) == 0 {
+		for k := range set {
+			delete(set, k)
+		}
+	}
+	for _, ch := range channels {
+		if name := fmt.Sprintf("%s", ch); sub {
+			set[name] = true
+		} else {
+			delete(set, name)
+		}
+	}
+	return nil
+}
+
+// Ping sends a PING to the server with the specified data.
+func (p *PubSubConn) Ping(data string) error {
+	p.mu.Lock()
+	psc := p.psc
+	p.mu.Unlock()
+	return psc.Ping(data)
+}
+
+// Receive returns a pushed message as a redis.Subscription,
+// redis.Message, redis.PMessage, redis.Pong, Reconnection or error. The
+// return value is intended to be used directly in a type switch, as for
+// redigo's redis.PubSubConn. Messages received on sharded channels are
+// returned as redis.Message values, and the sharded subscription
+// notifications as redis.Subscription values with a Kind of
+// "ssubscribe" or "sunsubscribe".
+//
+// If the connection to the node is lost or if the slot of the sharded
+// channels moved to another node, it attempts to reconnect and to
+// restore the subscriptions, and returns a Reconnection value if it
+// succeeds, or the error if it fails. In the latter case, the next call
+// to Receive attempts to reconnect again.
+func (p *PubSubConn) Receive() interface{} {
+	p.mu.Lock()
+	psc := p.psc
+	p.mu.Unlock()
+
+	v := receivePubSub(psc.Conn.Receive())
+	if err, ok := v.(error); ok && psc.Conn.Err() != nil {
+		return p.reconnect(psc.Conn, err)
+	}
+	if sub, ok := v.(redis.Subscription); ok && sub.Kind == "sunsubscribe" {
+		p.mu.Lock()
+		moved := p.schannels[sub.Channel]
+		p.mu.Unlock()
+		if moved {
+			// the connection did not unsubscribe from that channel, the
+			// server did because the slot is now served by another node.
+			return p.reconnect(psc.Conn, errors.New("redisc: slot of sharded channel "+sub.Channel+" moved"))
+		}
+	}
+	return v
+}
+
+// receivePubSub converts the reply to a pub-sub notification, like
+// redigo's redis.PubSubConn.Receive, with support for the sharded
+// pub-sub notifications.
+func receivePubSub(reply interface{}, err error) interface{} {
+	vals, err := redis.Values(reply, err)
+	if err != nil {
+		return err
+	}
+
+	var kind string
+	vals, err = redis.Scan(vals, &kind)
+	if err != nil {
+		return err
+	}
+
+	switch kind {
+	case "message", "smessage":
+		var m redis.Message
+		if _, err := redis.Scan(vals, &m.Channel, &m.Data); err != nil {
+			return err
+		}
+		return m
+	case "pmessage":
+		var pm redis.PMessage
+		if _, err := redis.Scan(vals, &pm.Pattern, &pm.Channel, &pm.Data); err != nil {
+			return err
+		}
+		return pm
+	case "subscribe", "psubscribe", "unsubscribe", "punsubscribe", "ssubscribe", "sunsubscribe":
+		s := redis.Subscription{Kind: kind}
+		if _, err := redis.Scan(vals, &s.Channel, &s.Count); err != nil {
+			return err
+		}
+		return s
+	case "pong":
+		var pong redis.Pong
+		if _, err := redis.Scan(vals, &pong.Data); err != nil {
+			return err
+		}
+		return pong
+	}
+	return errors.New("redisc: unknown pubsub notification")
+}
+
+// reconnect replaces the connection conn, which failed with err, with a
+// new connection, unless it was already replaced concurrently.
+func (p *PubSubConn) reconnect(conn redis.Conn, err error) interface{} {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return err
+	}
+	if p.psc.Conn != conn {
+		// already reconnected concurrently
+		p.mu.Unlock()
+		return Reconnection{Err: err}
+	}
+	if p.OnReconnect != nil {
+		// the subscriptions are restored by OnReconnect
+		for _, set := range []map[string]bool{p.channels, p.patterns, p.schannels} {
+			for k := range set {
+				delete(set, k)
+			}
+		}
+	}
+	rerr := p.reconnectLocked()
+	p.mu.Unlock()
+	if rerr != nil {
+		return rerr
+	}
+
+	if p.OnReconnect != nil {
+		p.OnReconnect(func(channels ...string) error {
+			return p.Subscribe(redis.Args{}.AddFlat(channels)...)
+		})
+	}
+	return Reconnection{Err: err}
+}
+
+// reconnectLocked refreshes the cluster's mapping, replaces the current
+// connection with a new one and restores the subscriptions.
+func (p *PubSubConn) reconnectLocked() error {
+	// refresh may fail if the cluster is being reconfigured, attempt
+	// the new connection anyway.
+	p.cluster.Refresh()
+
+	conn, err := p.cluster.Dial()
+	if err != nil {
+		return err
+	}
+	psc := redis.PubSubConn{Conn: conn}
+
+	// sharded channels must be subscribed to on the node that owns
+	// their slot, so that is sent first to bind the connection.
+	for _, sub := range []struct {
+		cmd string
+		set map[string]bool
+	}{
+		{"SSUBSCRIBE", p.schannels},
+		{"SUBSCRIBE", p.channels},
+		{"PSUBSCRIBE", p.patterns},
+	} {
+		if len(sub.set) == 0 {
+			continue
+		}
+		if err := conn.Send(sub.cmd, setArgs(sub.set)...); err != nil {
+			conn.Close()
+			return err
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		conn.Close()
+		return err
+	}
+
+	p.psc.Close()
+	p.psc = psc
+	return nil
+}
+
+func setArgs(set map[string]bool) redis.Args {
+	args := make(redis.Args, 0, len(set))
+	for k := range set {
+		args = append(args, k)
+	}
+	return args
+}
+
+// listenRetryDelay is the delay before Listen calls Receive again after
+// Receive failed to reconnect.
+const listenRetryDelay = 100 * time.Millisecond
+
+// Listen starts a goroutine that calls Receive in a loop and sends the
+// values it returns on the returned channel, which is closed once the
+// connection is closed. As for Receive, the values are redis.Message,
+// redis.PMessage, redis.Subscription, redis.Pong, Reconnection or error
+// values. If Receive fails to reconnect, the error is sent and Receive
+// is called again after a short delay. The channel must be read until it
+// is closed, and Receive must not be called concurrently.
+func (p *PubSubConn) Listen() <-chan interface{} {
+	ch := make(chan interface{})
+	go func() {
+		defer close(ch)
+		for {
+			v := p.Receive()
+			_, isErr := v.(error)
+			if isErr && p.isClosed() {
+				return
+			}
+			ch <- v
+			if isErr {
+				time.Sleep(listenRetryDelay)
+			}
+		}
+	}()
+	return ch
+}
+
+func (p *PubSubConn) isClosed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closed
+}
+
+// Close closes the connection.
+func (p *PubSubConn) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return errors.New("redisc: closed")
+	}
+	p.closed = true
+	return p.psc.Close()
+}
+
+// SPublish posts the message to the sharded channel, on the node that
+// owns the slot of the channel. It returns the number of clients that
+// received the message.
+func (c *Cluster) SPublish(channel string, message interface{}) (int, error) {
+	conn := c.Get()
+	defer conn.Close()
+
+	return redis.Int(conn.Do("SPUBLISH", channel, message))
+}
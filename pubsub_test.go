@@ -0,0 +1,224 @@
+package redisc
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/mna/redisc/redistest"
+	"github.com/mna/redisc/redistest/resp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPubSubConnReconnect(t *testing.T) {
+	var s *redistest.MockServer
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return mockClusterSlots(s.Addr)
+		case "SUBSCRIBE":
+			// the mock server can only return one reply per command, so
+			// only the first channel's confirmation is sent.
+			return resp.Array{"subscribe", args[0], int64(len(args))}
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s.Addr},
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	p, err := c.PubSub()
+	require.NoError(t, err, "PubSub")
+
+	require.NoError(t, p.Subscribe("a"), "Subscribe")
+	v := p.Receive()
+	if sub, ok := v.(redis.Subscription); assert.True(t, ok, "Subscription") {
+		assert.Equal(t, "a", sub.Channel, "channel")
+	}
+
+	// break the underlying connection
+	p.mu.Lock()
+	conn := p.psc.Conn.(*Conn)
+	conn.mu.Lock()
+	conn.rc.Close()
+	conn.mu.Unlock()
+	p.mu.Unlock()
+
+	v = p.Receive()
+	if rec, ok := v.(Reconnection); assert.True(t, ok, "Reconnection") {
+		assert.Error(t, rec.Err, "Reconnection error")
+	}
+
+	// subscription is restored
+	v = p.Receive()
+	if sub, ok := v.(redis.Subscription); assert.True(t, ok, "Subscription after reconnection") {
+		assert.Equal(t, "a", sub.Channel, "channel")
+	}
+
+	require.NoError(t, p.Close(), "Close")
+	assert.Error(t, p.Close(), "Close after Close")
+	assert.Error(t, p.Subscribe("b"), "Subscribe after Close")
+}
+
+func TestPubSubConnOnReconnect(t *testing.T) {
+	var s *redistest.MockServer
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return mockClusterSlots(s.Addr)
+		case "SUBSCRIBE":
+			return resp.Array{"subscribe", args[0], int64(len(args))}
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s.Addr},
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	p, err := c.PubSub()
+	require.NoError(t, err, "PubSub")
+
+	var reconnects int
+	p.OnReconnect = func(resubscribe func(channels ...string) error) {
+		reconnects++
+		assert.NoError(t, resubscribe("b"), "resubscribe")
+	}
+	require.NoError(t, p.Subscribe("a"), "Subscribe")
+	ch := p.Listen()
+	if sub, ok := (<-ch).(redis.Subscription); assert.True(t, ok, "Subscription") {
+		assert.Equal(t, "a", sub.Channel, "channel")
+	}
+
+	// break the underlying connection
+	p.mu.Lock()
+	conn := p.psc.Conn.(*Conn)
+	conn.mu.Lock()
+	conn.rc.Close()
+	conn.mu.Unlock()
+	p.mu.Unlock()
+
+	_, ok := (<-ch).(Reconnection)
+	assert.True(t, ok, "Reconnection")
+	assert.Equal(t, 1, reconnects, "OnReconnect called")
+
+	// only the subscription restored by OnReconnect is active
+	if sub, ok := (<-ch).(redis.Subscription); assert.True(t, ok, "Subscription after reconnection") {
+		assert.Equal(t, "b", sub.Channel, "channel")
+	}
+	p.mu.Lock()
+	assert.Equal(t, map[string]bool{"b": true}, p.channels, "channels")
+	p.mu.Unlock()
+
+	require.NoError(t, p.Close(), "Close")
+	for v := range ch {
+		t.Errorf("unexpected value after Close: %v", v)
+	}
+}
+
+func TestPubSubConnSharded(t *testing.T) {
+	var s *redistest.MockServer
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return mockClusterSlots(s.Addr)
+		case "SSUBSCRIBE":
+			return resp.Array{"ssubscribe", args[0], int64(len(args))}
+		case "SPUBLISH":
+			return int64(1)
+		case "PING":
+			// simulate the server-side unsubscribe when the slot moves
+			return resp.Array{"sunsubscribe", "{a}1", int64(0)}
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s.Addr},
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	p, err := c.PubSub()
+	require.NoError(t, err, "PubSub")
+	defer p.Close()
+
+	assert.Error(t, p.SSubscribe("a", "b"), "SSubscribe to different slots")
+	require.NoError(t, p.SSubscribe("{a}1", "{a}2"), "SSubscribe")
+	v := p.Receive()
+	if sub, ok := v.(redis.Subscription); assert.True(t, ok, "Subscription") {
+		assert.Equal(t, "ssubscribe", sub.Kind, "kind")
+		assert.Equal(t, "{a}1", sub.Channel, "channel")
+	}
+	assert.Error(t, p.SSubscribe("b"), "SSubscribe to a different slot")
+
+	n, err := c.SPublish("{a}1", "x")
+	if assert.NoError(t, err, "SPublish") {
+		assert.Equal(t, 1, n, "SPublish receivers")
+	}
+
+	require.NoError(t, p.Ping(""), "Ping")
+	v = p.Receive()
+	if rec, ok := v.(Reconnection); assert.True(t, ok, "Reconnection") {
+		assert.Contains(t, rec.Err.Error(), "moved", "Reconnection error")
+	}
+	v = p.Receive()
+	if sub, ok := v.(redis.Subscription); assert.True(t, ok, "Subscription after reconnection") {
+		assert.Equal(t, "ssubscribe", sub.Kind, "kind")
+	}
+}
+
+func TestPubSubConnShardedConcurrent(t *testing.T) {
+	var s *redistest.MockServer
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return mockClusterSlots(s.Addr)
+		case "SSUBSCRIBE":
+			return resp.Array{"ssubscribe", args[0], int64(len(args))}
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s.Addr},
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	p, err := c.PubSub()
+	require.NoError(t, err, "PubSub")
+	defer p.Close()
+
+	const n = 20
+	var wg sync.WaitGroup
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = p.SSubscribe("ch" + strconv.Itoa(i))
+		}(i)
+	}
+	wg.Wait()
+
+	var ok int
+	for _, err := range errs {
+		if err == nil {
+			ok++
+		}
+	}
+	assert.Equal(t, 1, ok, "successful SSubscribe")
+	assert.Len(t, p.schannels, 1, "sharded channels")
+}
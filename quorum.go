@@ -0,0 +1,111 @@
+package redisc
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// getQuorumClusterSlots queries n nodes (or all nodes if there are fewer
+// addresses) for their mapping of slots and returns the mapping returned
+// by a majority of them. A node that fails is replaced by the next node
+// in addrs, if any.
+func (c *Cluster) getQuorumClusterSlots(ctx context.Context, addrs []string, n int) ([]slotMapping, error) {
+	if n > len(addrs) {
+		n = len(addrs)
+	}
+	need := n/2 + 1
+
+	type result struct {
+		m   []slotMapping
+		err error
+	}
+	// buffered so that the queries still running when this returns
+	// don't block, they close their connection once done.
+	ch := make(chan result, len(addrs))
+	query := func(addr string) {
+		conn, err := c.getConnForAddr(ctx, addr, true)
+		if err != nil {
+			ch <- result{nil, err}
+			return
+		}
+		defer conn.Close()
+
+		m, err := c.getClusterSlots(conn, addr)
+		ch <- result{m, err}
+	}
+
+	var next int
+	for ; next < n; next++ {
+		go query(addrs[next])
+	}
+
+	var replies int
+	var notReady bool
+	counts := make(map[string]int)
+	for pending := next; pending > 0; pending-- {
+		var res result
+		select {
+		case res = <-ch:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if res.err != nil {
+			if res.err == ErrClusterNotReady {
+				notReady = true
+			}
+			if next < len(addrs) {
+				go query(addrs[next])
+				next++
+				pending++
+			}
+			continue
+		}
+
+		replies++
+		key := slotMappingsKey(res.m)
+		counts[key]++
+		if counts[key] >= need {
+			return res.m, nil
+		}
+	}
+	if replies == 0 {
+		if notReady {
+			return nil, ErrClusterNotReady
+		}
+		return nil, errors.New("redisc: all nodes failed")
+	}
+	return nil, errors.New("redisc: no quorum for the mapping of slots")
+}
+
+// slotMappingsKey returns a string that identifies the mapping m, so that
+// mappings can be compared regardless of the order of the slot ranges
+// and of the replicas in the nodes' replies.
+func slotMappingsKey(m []slotMapping) string {
+	sorted := make([]slotMapping, len(m))
+	copy(sorted, m)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].start < sorted[j].start
+	})
+
+	var b strings.Builder
+	for _, sm := range sorted {
+		b.WriteString(strconv.Itoa(sm.start))
+		b.WriteByte('-')
+		b.WriteString(strconv.Itoa(sm.end))
+		if len(sm.nodes) > 0 {
+			replicas := append([]string(nil), sm.nodes[1:]...)
+			sort.Strings(replicas)
+			b.WriteByte(' ')
+			b.WriteString(sm.nodes[0])
+			for _, r := range replicas {
+				b.WriteByte(',')
+				b.WriteString(r)
+			}
+		}
+		b.WriteByte(';')
+	}
+	return b.String()
+}
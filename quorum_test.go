@@ -0,0 +1,70 @@
+package redisc
+
+import (
+	"testing"
+
+	"github.com/mna/redisc/redistest"
+	"github.com/mna/redisc/redistest/resp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClusterRefreshQuorum(t *testing.T) {
+	var s1, s2, s3 *redistest.MockServer
+	handler := func(stale bool) func(string, ...string) interface{} {
+		return func(cmd string, args ...string) interface{} {
+			switch cmd {
+			case "CLUSTER":
+				if stale {
+					return mockClusterSlots(s3.Addr)
+				}
+				return mockClusterSlots(s1.Addr)
+			}
+			return resp.Error("unexpected command " + cmd)
+		}
+	}
+	s1 = redistest.StartMockServer(t, handler(false))
+	defer s1.Close()
+	s2 = redistest.StartMockServer(t, handler(false))
+	defer s2.Close()
+	s3 = redistest.StartMockServer(t, handler(true))
+	defer s3.Close()
+
+	c := &Cluster{
+		StartupNodes:  []string{s3.Addr, s1.Addr, s2.Addr},
+		RefreshQuorum: 3,
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+	m := c.Mapping()
+	assert.Equal(t, []string{s1.Addr}, m[0], "mapping of the majority")
+
+	// two nodes that disagree
+	c = &Cluster{
+		StartupNodes:  []string{s3.Addr, s1.Addr},
+		RefreshQuorum: 2,
+	}
+	defer c.Close()
+	if err := c.Refresh(); assert.Error(t, err, "Refresh without quorum") {
+		assert.Contains(t, err.Error(), "no quorum", "expected message")
+	}
+	m = c.Mapping()
+	assert.Nil(t, m[0], "mapping not adopted")
+}
+
+func TestSlotMappingsKey(t *testing.T) {
+	m1 := []slotMapping{
+		{start: 0, end: 100, nodes: []string{"a", "b", "c"}},
+		{start: 101, end: 16383, nodes: []string{"d"}},
+	}
+	m2 := []slotMapping{
+		{start: 101, end: 16383, nodes: []string{"d"}},
+		{start: 0, end: 100, nodes: []string{"a", "c", "b"}},
+	}
+	m3 := []slotMapping{
+		{start: 0, end: 100, nodes: []string{"b", "a", "c"}},
+		{start: 101, end: 16383, nodes: []string{"d"}},
+	}
+	assert.Equal(t, slotMappingsKey(m1), slotMappingsKey(m2), "same mapping")
+	assert.NotEqual(t, slotMappingsKey(m1), slotMappingsKey(m3), "different master")
+}
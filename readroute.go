@@ -0,0 +1,62 @@
+package redisc
+
+import "strings"
+
+// readCmds is the default list of read commands used when the cluster's
+// RouteReadsToReplicas field is set and IsReadCommand is nil.
+var readCmds = map[string]bool{
+	"BITCOUNT":         true,
+	"BITPOS":           true,
+	"EXISTS":           true,
+	"GEODIST":          true,
+	"GEOHASH":          true,
+	"GEOPOS":           true,
+	"GET":              true,
+	"GETBIT":           true,
+	"GETRANGE":         true,
+	"HEXISTS":          true,
+	"HGET":             true,
+	"HGETALL":          true,
+	"HKEYS":            true,
+	"HLEN":             true,
+	"HMGET":            true,
+	"HSTRLEN":          true,
+	"HVALS":            true,
+	"LINDEX":           true,
+	"LLEN":             true,
+	"LRANGE":           true,
+	"MGET":             true,
+	"PFCOUNT":          true,
+	"PTTL":             true,
+	"SCARD":            true,
+	"SISMEMBER":        true,
+	"SMEMBERS":         true,
+	"SRANDMEMBER":      true,
+	"STRLEN":           true,
+	"TTL":              true,
+	"TYPE":             true,
+	"XLEN":             true,
+	"XRANGE":           true,
+	"XREVRANGE":        true,
+	"ZCARD":            true,
+	"ZCOUNT":           true,
+	"ZLEXCOUNT":        true,
+	"ZRANGE":           true,
+	"ZRANGEBYLEX":      true,
+	"ZRANGEBYSCORE":    true,
+	"ZRANK":            true,
+	"ZREVRANGE":        true,
+	"ZREVRANGEBYLEX":   true,
+	"ZREVRANGEBYSCORE": true,
+	"ZREVRANK":         true,
+	"ZSCORE":           true,
+}
+
+// isReadCommand returns true if cmd is a read command, as reported by the
+// cluster's IsReadCommand function or by the default list.
+func (c *Cluster) isReadCommand(cmd string) bool {
+	if c.IsReadCommand != nil {
+		return c.IsReadCommand(cmd)
+	}
+	return readCmds[strings.ToUpper(cmd)]
+}
@@ -0,0 +1,75 @@
+package redisc
+
+import (
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/mna/redisc/redistest"
+	"github.com/mna/redisc/redistest/resp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClusterRouteReadsToReplicas(t *testing.T) {
+	var master, replica *redistest.MockServer
+	master = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return mockClusterSlots(master.Addr, replica.Addr)
+		case "GET":
+			return "master"
+		case "SET":
+			return resp.OK{}
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer master.Close()
+	replica = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "READONLY", "READWRITE":
+			return resp.OK{}
+		case "GET":
+			return "replica"
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer replica.Close()
+
+	cases := []struct {
+		route  bool
+		isRead func(string) bool
+		want   string
+	}{
+		{false, nil, "master"},
+		{true, nil, "replica"},
+		{true, func(string) bool { return false }, "master"},
+	}
+	for _, tc := range cases {
+		c := &Cluster{
+			StartupNodes:         []string{master.Addr},
+			RouteReadsToReplicas: tc.route,
+			IsReadCommand:        tc.isRead,
+		}
+		require.NoError(t, c.Refresh(), "Refresh")
+
+		v, err := redis.String(c.Do("GET", "a"))
+		if assert.NoError(t, err, "GET") {
+			assert.Equal(t, tc.want, v, "GET with route=%t", tc.route)
+		}
+		v, err = redis.String(c.Do("SET", "a", "b"))
+		if assert.NoError(t, err, "SET") {
+			assert.Equal(t, "OK", v, "SET on master")
+		}
+		c.Close()
+	}
+}
+
+func TestClusterIsReadCommand(t *testing.T) {
+	c := &Cluster{}
+	for _, cmd := range []string{"GET", "get", "MGet", "HGETALL", "ZRANGE"} {
+		assert.True(t, c.isReadCommand(cmd), cmd)
+	}
+	for _, cmd := range []string{"SET", "DEL", "EVAL", "INCR"} {
+		assert.False(t, c.isReadCommand(cmd), cmd)
+	}
+}
@@ -0,0 +1,113 @@
+// Package redisctest provides a fake redisc cluster to unit-test code
+// that uses the redisc package, without a redis server. The commands are
+// routed by the redisc.Cluster as usual, but they are recorded and
+// replied to by the fake cluster instead of being sent to a node.
+package redisctest
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/mna/redisc"
+)
+
+// HandlerFunc is the function called to reply to a command sent to a
+// fake cluster.
+type HandlerFunc func(cmd redisc.DryRunCommand) (interface{}, error)
+
+// Cluster is a fake cluster. Its embedded redisc.Cluster is the cluster
+// to use in the code under test: its commands are recorded, along with
+// the address of the node and the slot they are routed to, and their
+// replies are returned by the handlers registered with Handle. It is safe
+// for concurrent use.
+type Cluster struct {
+	*redisc.Cluster
+
+	mapping redisc.Mapping
+
+	mu       sync.Mutex
+	cmds     []redisc.DryRunCommand
+	handlers map[string]HandlerFunc
+}
+
+// NewCluster returns a fake cluster made of the master nodes at addrs,
+// each serving an equal range of slots in the order of addrs. If no
+// address is provided, a single node at "127.0.0.1:6379" serves all the
+// slots. The addresses are only used to route the commands, no
+// connection is made. The caller should close the cluster after use.
+func NewCluster(addrs ...string) (*Cluster, error) {
+	if len(addrs) == 0 {
+		addrs = []string{"127.0.0.1:6379"}
+	}
+
+	c := &Cluster{handlers: make(map[string]HandlerFunc)}
+	n := len(c.mapping)
+	for i, addr := range addrs {
+		for slot := i * n / len(addrs); slot < (i+1)*n/len(addrs); slot++ {
+			c.mapping[slot] = []string{addr}
+		}
+	}
+
+	c.Cluster = &redisc.Cluster{
+		TopologyProvider: c,
+		DryRun:           c.run,
+	}
+	if err := c.Refresh(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Topology implements redisc.TopologyProvider for the embedded
+// redisc.Cluster.
+func (c *Cluster) Topology(ctx context.Context) (*redisc.Mapping, error) {
+	return &c.mapping, nil
+}
+
+// Handle registers the handler that replies to the command cmd, which is
+// case-insensitive. It replaces the handler already registered for that
+// command, if any. The commands without handler fail with an error reply.
+func (c *Cluster) Handle(cmd string, fn HandlerFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handlers[strings.ToUpper(cmd)] = fn
+}
+
+// Reply registers a handler that replies to the command cmd with v and
+// err, as for Handle.
+func (c *Cluster) Reply(cmd string, v interface{}, err error) {
+	c.Handle(cmd, func(redisc.DryRunCommand) (interface{}, error) {
+		return v, err
+	})
+}
+
+// Commands returns the commands received by the cluster, in the order in
+// which they were received.
+func (c *Cluster) Commands() []redisc.DryRunCommand {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]redisc.DryRunCommand(nil), c.cmds...)
+}
+
+// Reset clears the commands received by the cluster. The handlers are
+// kept.
+func (c *Cluster) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cmds = nil
+}
+
+func (c *Cluster) run(cmd redisc.DryRunCommand) (interface{}, error) {
+	c.mu.Lock()
+	c.cmds = append(c.cmds, cmd)
+	fn := c.handlers[strings.ToUpper(cmd.Cmd)]
+	c.mu.Unlock()
+
+	if fn == nil {
+		return nil, redis.Error("ERR no reply for command " + cmd.Cmd)
+	}
+	return fn(cmd)
+}
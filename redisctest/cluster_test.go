@@ -0,0 +1,54 @@
+package redisctest
+
+import (
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/mna/redisc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCluster(t *testing.T) {
+	c, err := NewCluster("127.0.0.1:7000", "127.0.0.1:7001")
+	require.NoError(t, err, "NewCluster")
+	defer c.Close()
+
+	c.Reply("SET", "OK", nil)
+	c.Handle("get", func(cmd redisc.DryRunCommand) (interface{}, error) {
+		return []byte(cmd.Addr), nil
+	})
+
+	// the commands are routed by slot, as for a real cluster
+	_, err = c.Do("SET", "a", 1)
+	require.NoError(t, err, "SET")
+	v, err := redis.String(c.Do("GET", "b"))
+	require.NoError(t, err, "GET")
+	assert.Equal(t, "127.0.0.1:7000", v, "node of b")
+	_, err = c.Do("DEL", "a")
+	assert.Error(t, err, "DEL without handler")
+
+	assert.Equal(t, []redisc.DryRunCommand{
+		{Addr: "127.0.0.1:7001", Slot: 15495, Cmd: "SET", Args: []interface{}{"a", 1}},
+		{Addr: "127.0.0.1:7000", Slot: 3300, Cmd: "GET", Args: []interface{}{"b"}},
+		{Addr: "127.0.0.1:7001", Slot: 15495, Cmd: "DEL", Args: []interface{}{"a"}},
+	}, c.Commands(), "commands")
+
+	c.Reset()
+	assert.Empty(t, c.Commands(), "commands after Reset")
+
+	addr, ok := c.NodeForKey("c")
+	assert.True(t, ok, "c is mapped")
+	assert.Equal(t, "127.0.0.1:7000", addr, "node of c")
+}
+
+func TestClusterDefaultNode(t *testing.T) {
+	c, err := NewCluster()
+	require.NoError(t, err, "NewCluster")
+	defer c.Close()
+
+	assert.Empty(t, c.UnassignedSlots(), "all slots assigned")
+	addr, ok := c.NodeForKey("a")
+	assert.True(t, ok, "a is mapped")
+	assert.Equal(t, "127.0.0.1:6379", addr, "node of a")
+}
@@ -52,21 +52,72 @@ func StartServer(t *testing.T, w io.Writer, conf string) (*exec.Cmd, string) {
 	return startServerWithConfig(t, port, w, conf), port
 }
 
+// ClusterOptions is the configuration of a test cluster started with
+// StartClusterWithOptions.
+type ClusterOptions struct {
+	// Password, if set, is configured as requirepass and masterauth on
+	// all nodes of the cluster, so that clients must authenticate.
+	Password string
+
+	// Username, if set, is created as an ACL user (redis 6+) with all
+	// permissions and the Password, and the default user is disabled,
+	// so that clients must authenticate with that user.
+	Username string
+
+	// Replicas, if true, starts 1 replica per cluster node as done by
+	// StartClusterWithReplicas.
+	Replicas bool
+
+	// TLS, if not nil, makes all nodes accept only TLS connections using
+	// those certificates, including for the cluster bus and replication.
+	// Clients must connect with redis.DialUseTLS and a TLS configuration
+	// that trusts the CA, such as the one returned by TLS.ClientConfig.
+	TLS *TLSCerts
+
+	// AnnounceHostname, if set, is announced by all nodes as their
+	// hostname (the cluster-announce-hostname configuration, which
+	// requires redis 7+).
+	AnnounceHostname string
+}
+
 // StartClusterWithReplicas starts a redis cluster of NumClusterNodes with
 // 1 replica each. It returns the cleanup function to call after use
 // (typically in a defer) and the list of ports for each node,
 // masters first, then replicas.
 func StartClusterWithReplicas(t *testing.T, w io.Writer) (func(), []string) {
-	fn, ports := StartCluster(t, w)
-	mapping := getClusterNodeIDs(t, ports...)
+	return StartClusterWithOptions(t, w, ClusterOptions{Replicas: true})
+}
+
+// StartCluster starts a redis cluster of NumClusterNodes using the
+// ClusterConfig variable as configuration. If w is not nil,
+// stdout and stderr of each node will be written to it.
+//
+// It returns a function that should be called after the test
+// (typically in a defer), and the list of ports for all nodes
+// in the cluster.
+func StartCluster(t *testing.T, w io.Writer) (func(), []string) {
+	return StartClusterWithOptions(t, w, ClusterOptions{})
+}
+
+// StartClusterWithOptions starts a redis cluster of NumClusterNodes
+// configured as specified by opts. It returns the cleanup function to
+// call after use (typically in a defer) and the list of ports for each
+// node, masters first, then replicas if opts.Replicas is true.
+func StartClusterWithOptions(t *testing.T, w io.Writer, opts ClusterOptions) (func(), []string) {
+	fn, ports := startCluster(t, w, opts)
+	if !opts.Replicas {
+		return fn, ports
+	}
+
+	mapping := getClusterNodeIDs(t, opts, ports...)
 
 	var replicaPorts []string
 	var replicaCmds []*exec.Cmd
 	replicaMaster := make(map[string]string)
 	for _, master := range ports {
 		port := getClusterFreePort(t)
-		cmd := startServerWithConfig(t, port, w, fmt.Sprintf(ClusterConfig, port))
-		joinCluster(t, port, master)
+		cmd := startServerWithConfig(t, port, w, clusterConfig(port, opts))
+		joinCluster(t, opts, port, master)
 
 		replicaPorts = append(replicaPorts, port)
 		replicaCmds = append(replicaCmds, cmd)
@@ -74,12 +125,12 @@ func StartClusterWithReplicas(t *testing.T, w io.Writer) (func(), []string) {
 	}
 
 	// wait for the cluster to stabilize
-	require.True(t, waitForCluster(t, 10*time.Second, replicaPorts...), "wait for cluster replicas")
+	require.True(t, waitForCluster(t, 10*time.Second, opts, replicaPorts...), "wait for cluster replicas")
 	for _, port := range replicaPorts {
-		setupReplica(t, port, mapping[replicaMaster[port]])
+		setupReplica(t, opts, port, mapping[replicaMaster[port]])
 	}
 	// wait for replicas to join
-	require.True(t, waitForReplicas(t, 10*time.Second, append(ports, replicaPorts...)...), "wait for cluster replicas")
+	require.True(t, waitForReplicas(t, 10*time.Second, opts, append(ports, replicaPorts...)...), "wait for cluster replicas")
 
 	return func() {
 		for _, c := range replicaCmds {
@@ -95,14 +146,7 @@ func StartClusterWithReplicas(t *testing.T, w io.Writer) (func(), []string) {
 	}, append(ports, replicaPorts...)
 }
 
-// StartCluster starts a redis cluster of NumClusterNodes using the
-// ClusterConfig variable as configuration. If w is not nil,
-// stdout and stderr of each node will be written to it.
-//
-// It returns a function that should be called after the test
-// (typically in a defer), and the list of ports for all nodes
-// in the cluster.
-func StartCluster(t *testing.T, w io.Writer) (func(), []string) {
+func startCluster(t *testing.T, w io.Writer, opts ClusterOptions) (func(), []string) {
 	if _, err := exec.LookPath("redis-server"); err != nil {
 		t.Skip("redis-server not found in $PATH")
 	}
@@ -115,7 +159,7 @@ func StartCluster(t *testing.T, w io.Writer) (func(), []string) {
 
 	for i := 0; i < NumClusterNodes; i++ {
 		port := getClusterFreePort(t)
-		cmd := startServerWithConfig(t, port, w, fmt.Sprintf(ClusterConfig, port))
+		cmd := startServerWithConfig(t, port, w, clusterConfig(port, opts))
 		cmds[i], ports[i] = cmd, port
 
 		// configure the cluster - add the slots and join
@@ -128,14 +172,14 @@ func StartCluster(t *testing.T, w io.Writer) (func(), []string) {
 			// add all remaining slots in the last node
 			countSlots = hashSlots - (i * slotsPerNode)
 		}
-		setupClusterNode(t, port, i*slotsPerNode, countSlots)
+		setupClusterNode(t, opts, port, i*slotsPerNode, countSlots)
 		if meetPort != "" {
-			joinCluster(t, port, meetPort)
+			joinCluster(t, opts, port, meetPort)
 		}
 	}
 
 	// wait for the cluster to catch up
-	require.True(t, waitForCluster(t, 10*time.Second, ports...), "wait for cluster")
+	require.True(t, waitForCluster(t, 10*time.Second, opts, ports...), "wait for cluster")
 
 	return func() {
 		for _, c := range cmds {
@@ -150,6 +194,57 @@ func StartCluster(t *testing.T, w io.Writer) (func(), []string) {
 	}, ports
 }
 
+// clusterConfig returns the configuration of a cluster node listening on
+// port, with the additional settings required by opts.
+func clusterConfig(port string, opts ClusterOptions) string {
+	conf := fmt.Sprintf(ClusterConfig, port)
+	if opts.Username != "" {
+		conf += fmt.Sprintf(`user default off
+user %[1]s on >%[2]s ~* &* +@all
+masteruser %[1]s
+masterauth %[2]s
+`, opts.Username, opts.Password)
+	} else if opts.Password != "" {
+		conf += fmt.Sprintf("requirepass %[1]s\nmasterauth %[1]s\n", opts.Password)
+	}
+	if opts.TLS != nil {
+		// the node only accepts TLS connections on port
+		conf += fmt.Sprintf(`port 0
+tls-port %s
+tls-cert-file %s
+tls-key-file %s
+tls-ca-cert-file %s
+tls-auth-clients no
+tls-cluster yes
+tls-replication yes
+`, port, opts.TLS.CertFile, opts.TLS.KeyFile, opts.TLS.CAFile)
+	}
+	if opts.AnnounceHostname != "" {
+		conf += fmt.Sprintf("cluster-announce-hostname %s\n", opts.AnnounceHostname)
+	}
+	return conf
+}
+
+// dialNode connects to the node listening on port, using the user,
+// password and TLS settings of opts.
+func dialNode(t *testing.T, opts ClusterOptions, port string) redis.Conn {
+	var dopts []redis.DialOption
+	if opts.Username == "" {
+		dopts = append(dopts, redis.DialPassword(opts.Password))
+	}
+	if opts.TLS != nil {
+		dopts = append(dopts, redis.DialUseTLS(true), redis.DialTLSConfig(opts.TLS.ClientConfig()))
+	}
+	// the host is required to validate the certificate of the node
+	conn, err := redis.Dial("tcp", "127.0.0.1:"+port, dopts...)
+	require.NoError(t, err, "Dial to node")
+	if opts.Username != "" {
+		_, err = conn.Do("AUTH", opts.Username, opts.Password)
+		require.NoError(t, err, "AUTH with ACL user")
+	}
+	return conn
+}
+
 func printClusterNodes(t *testing.T, port string) {
 	conn, err := redis.Dial("tcp", ":"+port)
 	require.NoError(t, err, "Dial to cluster node")
@@ -171,23 +266,21 @@ func printClusterSlots(t *testing.T, port string) {
 	fmt.Println(string(b))
 }
 
-func joinCluster(t *testing.T, nodePort, clusterPort string) {
-	conn, err := redis.Dial("tcp", ":"+nodePort)
-	require.NoError(t, err, "Dial to node")
+func joinCluster(t *testing.T, opts ClusterOptions, nodePort, clusterPort string) {
+	conn := dialNode(t, opts, nodePort)
 	defer conn.Close()
 
 	// join the cluster
-	_, err = conn.Do("CLUSTER", "MEET", "127.0.0.1", clusterPort)
+	_, err := conn.Do("CLUSTER", "MEET", "127.0.0.1", clusterPort)
 	require.NoError(t, err, "CLUSTER MEET")
 }
 
-func getClusterNodeIDs(t *testing.T, ports ...string) map[string]string {
+func getClusterNodeIDs(t *testing.T, opts ClusterOptions, ports ...string) map[string]string {
 	if len(ports) == 0 {
 		return nil
 	}
 
-	conn, err := redis.Dial("tcp", ":"+ports[0])
-	require.NoError(t, err, "Dial to node")
+	conn := dialNode(t, opts, ports[0])
 	defer conn.Close()
 
 	nodes, err := redis.String(conn.Do("CLUSTER", "NODES"))
@@ -212,18 +305,16 @@ func getClusterNodeIDs(t *testing.T, ports ...string) map[string]string {
 	return mapping
 }
 
-func setupReplica(t *testing.T, replicaPort, masterID string) {
-	conn, err := redis.Dial("tcp", ":"+replicaPort)
-	require.NoError(t, err, "Dial to replica node")
+func setupReplica(t *testing.T, opts ClusterOptions, replicaPort, masterID string) {
+	conn := dialNode(t, opts, replicaPort)
 	defer conn.Close()
 
-	_, err = conn.Do("CLUSTER", "REPLICATE", masterID)
+	_, err := conn.Do("CLUSTER", "REPLICATE", masterID)
 	require.NoError(t, err, "CLUSTER REPLICATE")
 }
 
-func setupClusterNode(t *testing.T, port string, start, count int) {
-	conn, err := redis.Dial("tcp", ":"+port)
-	require.NoError(t, err, "Dial to cluster node")
+func setupClusterNode(t *testing.T, opts ClusterOptions, port string, start, count int) {
+	conn := dialNode(t, opts, port)
 	defer conn.Close()
 
 	args := redis.Args{"ADDSLOTS"}
@@ -231,16 +322,15 @@ func setupClusterNode(t *testing.T, port string, start, count int) {
 		args = args.Add(i)
 	}
 
-	_, err = conn.Do("CLUSTER", args...)
+	_, err := conn.Do("CLUSTER", args...)
 	require.NoError(t, err, "CLUSTER ADDSLOTS")
 }
 
-func waitForReplicas(t *testing.T, timeout time.Duration, ports ...string) bool {
+func waitForReplicas(t *testing.T, timeout time.Duration, opts ClusterOptions, ports ...string) bool {
 	deadline := time.Now().Add(timeout)
 
 	for _, port := range ports {
-		conn, err := redis.Dial("tcp", ":"+port)
-		require.NoError(t, err, "Dial")
+		conn := dialNode(t, opts, port)
 
 		for time.Now().Before(deadline) {
 			v, err := redis.String(conn.Do("CLUSTER", "NODES"))
@@ -273,12 +363,11 @@ func waitForReplicas(t *testing.T, timeout time.Duration, ports ...string) bool
 	return true
 }
 
-func waitForCluster(t *testing.T, timeout time.Duration, ports ...string) bool {
+func waitForCluster(t *testing.T, timeout time.Duration, opts ClusterOptions, ports ...string) bool {
 	deadline := time.Now().Add(timeout)
 
 	for _, port := range ports {
-		conn, err := redis.Dial("tcp", ":"+port)
-		require.NoError(t, err, "Dial")
+		conn := dialNode(t, opts, port)
 
 		for time.Now().Before(deadline) {
 			vals, err := redis.Bytes(conn.Do("CLUSTER", "INFO"))
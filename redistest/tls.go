@@ -0,0 +1,106 @@
+package redistest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TLSCerts holds the files of a test certificate authority and of a
+// server certificate signed by it.
+type TLSCerts struct {
+	// CAFile is the path of the PEM-encoded CA certificate.
+	CAFile string
+	// CertFile is the path of the PEM-encoded server certificate.
+	CertFile string
+	// KeyFile is the path of the PEM-encoded server private key.
+	KeyFile string
+	// CertPool is a pool that contains the CA certificate.
+	CertPool *x509.CertPool
+}
+
+// ClientConfig returns a TLS configuration that trusts the CA, to use
+// with the redis.DialTLSConfig option.
+func (c *TLSCerts) ClientConfig() *tls.Config {
+	return &tls.Config{RootCAs: c.CertPool}
+}
+
+// GenerateTLSCerts generates a CA and a server certificate signed by it,
+// valid for 127.0.0.1, localhost and the additional hosts. It returns
+// the certificates and a function to call after use (typically in a
+// defer) to remove the files.
+func GenerateTLSCerts(t *testing.T, hosts ...string) (*TLSCerts, func()) {
+	dir, err := ioutil.TempDir("", "redistest")
+	require.NoError(t, err, "create certificates directory")
+
+	notBefore := time.Now().Add(-time.Hour)
+	notAfter := notBefore.Add(24 * time.Hour)
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err, "generate CA key")
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "redistest CA"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	require.NoError(t, err, "create CA certificate")
+	caCert, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err, "parse CA certificate")
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err, "generate server key")
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "redistest"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		// the nodes also act as clients on the cluster bus
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:    append([]string{"localhost"}, hosts...),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, &key.PublicKey, caKey)
+	require.NoError(t, err, "create server certificate")
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err, "marshal server key")
+
+	certs := &TLSCerts{
+		CAFile:   filepath.Join(dir, "ca.crt"),
+		CertFile: filepath.Join(dir, "redis.crt"),
+		KeyFile:  filepath.Join(dir, "redis.key"),
+		CertPool: x509.NewCertPool(),
+	}
+	certs.CertPool.AddCert(caCert)
+
+	writePEM(t, certs.CAFile, "CERTIFICATE", caDER)
+	writePEM(t, certs.CertFile, "CERTIFICATE", der)
+	writePEM(t, certs.KeyFile, "EC PRIVATE KEY", keyDER)
+
+	return certs, func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func writePEM(t *testing.T, path, typ string, b []byte) {
+	data := pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: b})
+	require.NoError(t, ioutil.WriteFile(path, data, 0600), "write %s", path)
+}
@@ -0,0 +1,45 @@
+package redistest
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateTLSCerts(t *testing.T) {
+	certs, fn := GenerateTLSCerts(t, "node.redis.test")
+	defer fn()
+
+	cert, err := tls.LoadX509KeyPair(certs.CertFile, certs.KeyFile)
+	require.NoError(t, err, "LoadX509KeyPair")
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	require.NoError(t, err, "Listen")
+	defer l.Close()
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	for _, host := range []string{"127.0.0.1", "localhost", "node.redis.test"} {
+		cfg := certs.ClientConfig()
+		cfg.ServerName = host
+		conn, err := tls.Dial("tcp", l.Addr().String(), cfg)
+		if assert.NoError(t, err, "Dial %s", host) {
+			conn.Close()
+		}
+	}
+
+	cfg := certs.ClientConfig()
+	cfg.ServerName = "other.redis.test"
+	_, err = tls.Dial("tcp", l.Addr().String(), cfg)
+	assert.Error(t, err, "Dial with unknown host")
+}
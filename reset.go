@@ -0,0 +1,39 @@
+package redisc
+
+import (
+	"strings"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// resetConn sends the RESET command on conn, a connection to the node at
+// addr, and authenticates it again if the cluster's Username is set. It
+// returns false if the RESET command failed, e.g. because the node
+// doesn't support it, in which case the node is not sent RESET again.
+func (c *Cluster) resetConn(conn redis.Conn, addr string) bool {
+	c.mu.RLock()
+	noReset := c.noReset[addr]
+	c.mu.RUnlock()
+	if noReset {
+		return false
+	}
+
+	if _, err := conn.Do("RESET"); err != nil {
+		if re, ok := err.(redis.Error); ok && strings.Contains(string(re), "unknown command") {
+			c.mu.Lock()
+			if c.noReset == nil {
+				c.noReset = make(map[string]bool)
+			}
+			c.noReset[addr] = true
+			c.mu.Unlock()
+		}
+		return false
+	}
+
+	if c.Username != "" {
+		if _, err := conn.Do("AUTH", c.Username, c.Password); err != nil {
+			c.logf("redisc: failed to authenticate %s after RESET: %v", addr, err)
+		}
+	}
+	return true
+}
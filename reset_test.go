@@ -0,0 +1,81 @@
+package redisc
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mna/redisc/redistest"
+	"github.com/mna/redisc/redistest/resp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClusterResetOnReturn(t *testing.T) {
+	cases := []struct {
+		support  bool
+		username string
+		want     []string
+	}{
+		{true, "", []string{"GET", "RESET", "GET", "RESET"}},
+		// AUTH of the refresh connection, of the pool connection, then after RESET
+		{true, "user", []string{"AUTH", "AUTH", "GET", "RESET", "AUTH", "GET", "RESET", "AUTH"}},
+		{false, "", []string{"GET", "RESET", "READWRITE", "GET", "READWRITE"}},
+	}
+	for _, tc := range cases {
+		var (
+			s    *redistest.MockServer
+			mu   sync.Mutex
+			cmds []string
+		)
+		s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+			switch cmd = strings.ToUpper(cmd); cmd {
+			case "CLUSTER":
+				return mockClusterSlots(s.Addr)
+			case "READONLY":
+				return resp.OK{}
+			case "PING":
+				return resp.Pong{}
+			}
+
+			mu.Lock()
+			cmds = append(cmds, cmd)
+			mu.Unlock()
+			switch cmd {
+			case "RESET":
+				if !tc.support {
+					return resp.Error("ERR unknown command 'RESET'")
+				}
+				return resp.SimpleString("RESET")
+			case "AUTH", "READWRITE":
+				return resp.OK{}
+			case "GET":
+				return "v"
+			}
+			return resp.Error("unexpected command " + cmd)
+		})
+
+		c := &Cluster{
+			StartupNodes:  []string{s.Addr},
+			CreatePool:    createPool,
+			ResetOnReturn: true,
+			Username:      tc.username,
+		}
+		require.NoError(t, c.Refresh(), "Refresh")
+
+		for i := 0; i < 2; i++ {
+			conn := c.Get()
+			require.NoError(t, ReadOnlyConn(conn), "ReadOnly")
+			_, err := conn.Do("GET", "a")
+			require.NoError(t, err, "GET")
+			require.NoError(t, conn.Close(), "Close")
+		}
+
+		mu.Lock()
+		assert.Equal(t, tc.want, cmds, "commands with support=%t, username=%q", tc.support, tc.username)
+		mu.Unlock()
+
+		c.Close()
+		s.Close()
+	}
+}
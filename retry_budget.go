@@ -0,0 +1,37 @@
+package redisc
+
+import "time"
+
+// allowRetry returns true if a retry is allowed by the retry budget of the
+// cluster, taking a token from that budget, false otherwise. See the
+// RetryBudgetRate field.
+func (c *Cluster) allowRetry() bool {
+	if c.RetryBudgetRate <= 0 {
+		return true
+	}
+	burst := float64(c.RetryBudgetBurst)
+	if burst < 1 {
+		burst = 1
+	}
+
+	c.budgetMu.Lock()
+	defer c.budgetMu.Unlock()
+
+	now := time.Now()
+	if c.budgetTime.IsZero() {
+		c.budgetTokens = burst
+	} else {
+		c.budgetTokens += now.Sub(c.budgetTime).Seconds() * c.RetryBudgetRate
+		if c.budgetTokens > burst {
+			c.budgetTokens = burst
+		}
+	}
+	c.budgetTime = now
+
+	if c.budgetTokens < 1 {
+		c.logf("redisc: retry budget exhausted")
+		return false
+	}
+	c.budgetTokens--
+	return true
+}
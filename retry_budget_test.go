@@ -0,0 +1,72 @@
+package redisc
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mna/redisc/redistest"
+	"github.com/mna/redisc/redistest/resp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryConnBudget(t *testing.T) {
+	var s *redistest.MockServer
+	var calls int32
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return mockClusterSlots(s.Addr)
+		case "GET":
+			atomic.AddInt32(&calls, 1)
+			return resp.Error("TRYAGAIN Multiple keys request during rehashing of slot")
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	c := &Cluster{
+		StartupNodes:     []string{s.Addr},
+		RetryBudgetRate:  10,
+		RetryBudgetBurst: 2,
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	do := func() (int32, error) {
+		conn := c.Get()
+		defer conn.Close()
+		rc, err := RetryConn(conn, 10, time.Millisecond)
+		require.NoError(t, err, "RetryConn")
+
+		before := atomic.LoadInt32(&calls)
+		_, err = rc.Do("GET", "a")
+		return atomic.LoadInt32(&calls) - before, err
+	}
+
+	// the burst allows 2 retries, then the error is returned
+	n, err := do()
+	assert.True(t, IsTryAgain(err), "TRYAGAIN returned")
+	assert.Equal(t, int32(3), n, "attempts with full budget")
+
+	n, err = do()
+	assert.True(t, IsTryAgain(err), "TRYAGAIN returned")
+	assert.Equal(t, int32(1), n, "attempts with exhausted budget")
+
+	// the budget is refilled over time, up to the burst
+	time.Sleep(300 * time.Millisecond)
+	n, _ = do()
+	assert.Equal(t, int32(3), n, "attempts with refilled budget")
+}
+
+func TestClusterAllowRetry(t *testing.T) {
+	c := &Cluster{}
+	for i := 0; i < 100; i++ {
+		require.True(t, c.allowRetry(), "no budget")
+	}
+
+	c.RetryBudgetRate = 1
+	assert.True(t, c.allowRetry(), "burst of 1")
+	assert.False(t, c.allowRetry(), "budget exhausted")
+}
@@ -2,6 +2,7 @@ package redisc
 
 import (
 	"errors"
+	"math"
 	"time"
 
 	"github.com/garyburd/redigo/redis"
@@ -9,52 +10,222 @@ import (
 
 // RetryConn wraps the connection c (which must be a *Conn)
 // into a connection that automatically handles cluster redirections
-// (MOVED and ASK replies) and retries for TRYAGAIN errors.
+// (MOVED and ASK replies) and retries for TRYAGAIN, LOADING and
+// MASTERDOWN errors (and optionally CLUSTERDOWN and connection errors,
+// see RetryClusterDown, RetryConnErrors and RetryRefreshOnConnErrors).
+// Those errors are retried on the same node, without refreshing the
+// cluster's mapping.
 // Only Do, Close and Err can be called on that connection,
-// all other methods return an error.
+// all other methods return an error, unless the RetryPipeline option
+// is set. The connection also has a DoRouted method, as for
+// Conn.DoRouted, that can be called with a type assertion.
+//
+// A MOVED redirection binds the connection to the new node for that
+// slot. An ASK redirection means that the slot is being migrated, so
+// the command is sent to the importing node preceded by ASKING, but
+// only for that call: the connection stays bound to the same node and
+// the cluster's mapping is not updated.
 //
 // The maxAtt parameter indicates the maximum number of attempts
 // to successfully execute the command. The tryAgainDelay is the
-// duration to wait before retrying a TRYAGAIN error.
-func RetryConn(c redis.Conn, maxAtt int, tryAgainDelay time.Duration) (redis.Conn, error) {
+// duration to wait before retrying those errors, unless a
+// different Backoff policy is set with the RetryBackoff option.
+// Redirections are always retried immediately. The retries are limited
+// by the retry budget of the cluster, see Cluster.RetryBudgetRate.
+func RetryConn(c redis.Conn, maxAtt int, tryAgainDelay time.Duration, opts ...RetryOption) (redis.Conn, error) {
 	cc, ok := c.(*Conn)
 	if !ok {
 		return nil, errors.New("redisc: connection is not a *Conn")
 	}
-	return &retryConn{c: cc, maxAttempts: maxAtt, tryAgainDelay: tryAgainDelay}, nil
+	rc := &retryConn{c: cc, maxAttempts: maxAtt, backoff: constantBackoff(tryAgainDelay)}
+	for _, opt := range opts {
+		opt.f(rc)
+	}
+	return rc, nil
+}
+
+// RetryOption specifies an option for a connection returned by
+// RetryConn.
+type RetryOption struct {
+	f func(*retryConn)
+}
+
+// RetryBackoff sets the Backoff policy that determines how long to wait
+// before retrying a failed attempt. It replaces the fixed tryAgainDelay
+// passed to RetryConn.
+func RetryBackoff(b Backoff) RetryOption {
+	return RetryOption{func(rc *retryConn) {
+		rc.backoff = b
+	}}
+}
+
+// RetryClusterDown makes the connection retry the commands that fail
+// with a CLUSTERDOWN error, waiting before each attempt as for TRYAGAIN
+// errors. The cluster's mapping is not refreshed for those errors, as
+// the cluster is usually down because a failover is in progress.
+func RetryClusterDown() RetryOption {
+	return RetryOption{func(rc *retryConn) {
+		rc.retryClusterDown = true
+	}}
+}
+
+// RetryConnErrors makes the connection retry the commands that fail
+// because the connection to the node is broken (e.g. because the node
+// restarted or failed), waiting before each attempt as for TRYAGAIN
+// errors. The broken connection is released, a refresh of the cluster's
+// mapping is triggered and the next attempt binds the connection again
+// to the node that serves the command's slot. Because the command may
+// have been executed before the connection broke, this should only be
+// used for idempotent commands.
+func RetryConnErrors() RetryOption {
+	return RetryOption{func(rc *retryConn) {
+		rc.retryConnErrors = true
+	}}
+}
+
+// RetryRefreshOnConnErrors is like RetryConnErrors, but the cluster's
+// mapping is refreshed synchronously before the next attempt instead of
+// in the background, so that the next attempt is made on the new owner
+// of the command's slot (e.g. when a master failed and one of its
+// replicas was promoted). Each retried connection error then waits for a
+// full refresh, so it should be used with a Backoff that limits how
+// often this happens.
+func RetryRefreshOnConnErrors() RetryOption {
+	return RetryOption{func(rc *retryConn) {
+		rc.retryConnErrors = true
+		rc.syncRefresh = true
+	}}
+}
+
+// Backoff is the interface that defines how long to wait before
+// retrying a failed attempt.
+type Backoff interface {
+	// NextDelay returns the duration to wait before the next attempt. The
+	// attempt parameter is the number of attempts made so far, starting
+	// at 1.
+	NextDelay(attempt int) time.Duration
+}
+
+// BackoffFunc is a function that implements the Backoff interface.
+type BackoffFunc func(attempt int) time.Duration
+
+// NextDelay returns the result of calling fn(attempt).
+func (fn BackoffFunc) NextDelay(attempt int) time.Duration {
+	return fn(attempt)
+}
+
+type constantBackoff time.Duration
+
+func (b constantBackoff) NextDelay(attempt int) time.Duration {
+	return time.Duration(b)
+}
+
+// ExponentialBackoff is a Backoff that doubles the delay after each
+// failed attempt, starting with the Initial delay, up to the Max delay.
+type ExponentialBackoff struct {
+	// Initial is the delay after the first failed attempt.
+	Initial time.Duration
+
+	// Max is the maximum delay. If it is 0, the delay is not limited.
+	Max time.Duration
+
+	// Jitter indicates if the delay should be randomized. If true, the
+	// actual delay is a random duration between 0 and the computed delay,
+	// so that clients that failed at the same time don't retry in sync.
+	Jitter bool
+}
+
+// NextDelay returns the delay to wait before the next attempt.
+func (b ExponentialBackoff) NextDelay(attempt int) time.Duration {
+	d := b.Initial
+	for i := 1; i < attempt && d > 0; i++ {
+		if b.Max > 0 && d >= b.Max {
+			break
+		}
+		if d > math.MaxInt64/2 {
+			d = math.MaxInt64
+			break
+		}
+		d *= 2
+	}
+	if b.Max > 0 && d > b.Max {
+		d = b.Max
+	}
+
+	if b.Jitter && d > 0 {
+		rnd.Lock()
+		d = time.Duration(rnd.Int63n(int64(d) + 1))
+		rnd.Unlock()
+	}
+	return d
 }
 
 type retryConn struct {
 	c *Conn
 
-	maxAttempts   int
-	tryAgainDelay time.Duration
+	maxAttempts      int
+	backoff          Backoff
+	retryClusterDown bool
+	retryConnErrors  bool
+	syncRefresh      bool
+
+	// pipelining, see RetryPipeline
+	pipeline    bool
+	maxPipeline int
+	pending     []pipelineCmd
+	replies     []pipelineReply
 }
 
 func (rc *retryConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if len(rc.pending) > 0 || len(rc.replies) > 0 {
+		return nil, errors.New("redisc: pending pipelined commands")
+	}
 	return rc.do(cmd, args...)
 }
 
-func (rc *retryConn) do(cmd string, args ...interface{}) (interface{}, error) {
-	var att int
-	var asking bool
+// DoRouted is like Do, but it also returns the address of the node that
+// executed the command, as for Conn.DoRouted.
+func (rc *retryConn) DoRouted(cmd string, args ...interface{}) (interface{}, string, error) {
+	return rc.c.routed(func() (interface{}, error) {
+		return rc.Do(cmd, args...)
+	})
+}
 
+func (rc *retryConn) do(cmd string, args ...interface{}) (interface{}, error) {
 	cluster := rc.c.cluster
-	for rc.maxAttempts <= 0 || att < rc.maxAttempts {
-		if asking {
-			if err := rc.c.Send("ASKING"); err != nil {
-				return nil, err
-			}
-			asking = false
+	if ask := cluster.cachedAsk(cmd, args); ask != nil {
+		// the key is being migrated, see AskCacheTTL
+		if v, err := rc.c.doAsking(ask, cmd, args...); !askFailed(err) {
+			return v, err
 		}
+	}
 
-		v, err := rc.c.Do(cmd, args...)
+	var att int
+	var ask *RedirError
+	for rc.maxAttempts <= 0 || att < rc.maxAttempts {
+		var v interface{}
+		var err error
+		if ask != nil {
+			v, err = rc.c.doAsking(ask, cmd, args...)
+			ask = nil
+		} else {
+			v, err = rc.c.Do(cmd, args...)
+		}
 		re := ParseRedir(err)
 		if re == nil {
-			if IsTryAgain(err) {
+			if IsTryAgain(err) || IsLoading(err) || IsMasterDown(err) ||
+				(rc.retryClusterDown && IsClusterDown(err)) ||
+				(rc.retryConnErrors && err != nil && rc.unbindBroken()) {
+				if !cluster.allowRetry() {
+					return v, err
+				}
+
 				// handle retry
-				time.Sleep(rc.tryAgainDelay)
 				att++
+				if obs := cluster.Observer; obs != nil {
+					obs.OnRetry(cmd, att+1, err)
+				}
+				time.Sleep(rc.backoff.NextDelay(att))
 				continue
 			}
 
@@ -62,47 +233,61 @@ func (rc *retryConn) do(cmd string, args ...interface{}) (interface{}, error) {
 			return v, err
 		}
 
-		// handle redirection
-		rc.c.mu.Lock()
-		readOnly := rc.c.readOnly
-		connAddr := rc.c.boundAddr
-		rc.c.mu.Unlock()
-		if readOnly {
-			// check if the connection was already made to that slot, meaning
-			// that the redirection is because the command can't be served
-			// by the replica and a non-readonly connection must be made to
-			// the slot's master. If that's not the case, then keep the
-			// readonly flag to true, meaning that it will attempt a connection
-			// to a replica for the new slot.
-			cluster.mu.Lock()
-			slotMappings := cluster.mapping[re.NewSlot]
-			cluster.mu.Unlock()
-			if isIn(slotMappings, connAddr) {
-				readOnly = false
-			}
+		if re.Type == "MOVED" && cluster.DisableAutoRefreshOnMoved {
+			// the caller handles the redirections, see DisableAutoRefreshOnMoved
+			return v, err
+		}
+		if re.Type == "ASK" {
+			cluster.logf("redisc: %s redirection for slot %d to %s", re.Type, re.NewSlot, re.Addr)
+			// the slot is being migrated, only this command must be sent to
+			// the importing node, the mapping and binding stay the same.
+			ask = re
+			att++
+			continue
 		}
 
-		// forceDial doesn't require locking (immutable)
-		conn, addr, err := cluster.getConnForSlot(re.NewSlot, rc.c.forceDial, readOnly)
-		if err != nil {
+		// handle redirection
+		if _, err := rc.c.followMoved(re); err != nil {
 			// could not get connection to that node, return that error
 			return nil, err
 		}
 
-		rc.c.mu.Lock()
-		// close and replace the old connection (close must come before assignments)
-		rc.c.closeLocked()
-		rc.c.rc = conn
-		rc.c.boundAddr = addr
-		rc.c.readOnly = readOnly
-		rc.c.mu.Unlock()
-
-		asking = re.Type == "ASK"
 		att++
 	}
 	return nil, errors.New("redisc: too many attempts")
 }
 
+// unbindBroken releases the node connection if it is broken, so that the
+// next attempt binds the connection again, and refreshes the mapping (in
+// the background unless syncRefresh is set). It returns true if the
+// connection is broken or if it could not be bound, false otherwise
+// (including if the connection is closed).
+func (rc *retryConn) unbindBroken() bool {
+	rc.c.mu.Lock()
+	if rc.c.err != nil {
+		rc.c.mu.Unlock()
+		return false
+	}
+	if rc.c.rc != nil {
+		if rc.c.rc.Err() == nil {
+			rc.c.mu.Unlock()
+			return false
+		}
+		rc.c.closeLocked()
+		rc.c.rc = nil
+		rc.c.boundAddr = ""
+	}
+	rc.c.mu.Unlock()
+
+	if rc.syncRefresh {
+		// if it fails, the next attempt uses the current mapping
+		rc.c.cluster.Refresh()
+	} else {
+		rc.c.cluster.needsRefresh(nil)
+	}
+	return true
+}
+
 func (rc *retryConn) Err() error {
 	return rc.c.Err()
 }
@@ -112,15 +297,15 @@ func (rc *retryConn) Close() error {
 }
 
 func (rc *retryConn) Send(cmd string, args ...interface{}) error {
-	return errors.New("redisc: unsupported call to Send")
+	return rc.send(cmd, args...)
 }
 
 func (rc *retryConn) Receive() (interface{}, error) {
-	return nil, errors.New("redisc: unsupported call to Receive")
+	return rc.receive()
 }
 
 func (rc *retryConn) Flush() error {
-	return errors.New("redisc: unsupported call to Flush")
+	return rc.flush()
 }
 
 func isIn(list []string, v string) bool {
@@ -65,6 +65,76 @@ func TestRetryConnAsk(t *testing.T) {
 	}
 }
 
+func TestRetryConnAskDuringMigration(t *testing.T) {
+	var src, dst *redistest.MockServer
+	var clusterCalls, asking int32
+
+	// src owns all slots and migrates slot of key "x" to dst
+	src = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			if args[0] == "SLOTS" {
+				atomic.AddInt32(&clusterCalls, 1)
+			}
+			return mockClusterSlots(src.Addr)
+		case "GET":
+			if args[0] == "x" {
+				return resp.Error("ASK " + strconv.Itoa(Slot("x")) + " " + dst.Addr)
+			}
+			return "src"
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer src.Close()
+	dst = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "ASKING":
+			atomic.AddInt32(&asking, 1)
+			return resp.OK{}
+		case "GET":
+			if atomic.LoadInt32(&asking) == 0 {
+				return resp.Error("MOVED " + strconv.Itoa(Slot(args[0])) + " " + src.Addr)
+			}
+			return "dst"
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer dst.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{src.Addr},
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	conn := c.Get()
+	defer conn.Close()
+	rc, err := RetryConn(conn, 3, time.Millisecond)
+	require.NoError(t, err, "RetryConn")
+
+	v, err := redis.String(rc.Do("GET", "x"))
+	if assert.NoError(t, err, "GET x") {
+		assert.Equal(t, "dst", v, "served by the importing node")
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&asking), "ASKING sent")
+
+	// the connection is still bound to the source node, and the mapping
+	// is unchanged
+	cc := conn.(*Conn)
+	cc.mu.Lock()
+	assert.Equal(t, src.Addr, cc.boundAddr, "still bound to source")
+	cc.mu.Unlock()
+	c.mu.Lock()
+	assert.Equal(t, []string{src.Addr}, c.mapping[Slot("x")], "mapping unchanged")
+	c.mu.Unlock()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&clusterCalls), "no refresh")
+
+	v, err = redis.String(rc.Do("GET", "y"))
+	if assert.NoError(t, err, "GET y") {
+		assert.Equal(t, "src", v, "served by the source node")
+	}
+}
+
 func TestRetryConnTryAgain(t *testing.T) {
 	var s *redistest.MockServer
 	var tryagain int32
@@ -108,6 +178,47 @@ func TestRetryConnTryAgain(t *testing.T) {
 	if assert.NoError(t, err, "GET with retry") {
 		assert.Equal(t, []byte("ok"), v, "expected result")
 	}
+
+	// with a backoff policy
+	atomic.StoreInt32(&tryagain, 0)
+	var attempts []int
+	rc, err = RetryConn(conn, 3, time.Hour, RetryBackoff(BackoffFunc(func(att int) time.Duration {
+		attempts = append(attempts, att)
+		return time.Millisecond
+	})))
+	require.NoError(t, err, "RetryConn with backoff")
+	v, err = rc.Do("GET", "x")
+	if assert.NoError(t, err, "GET with retry and backoff") {
+		assert.Equal(t, []byte("ok"), v, "expected result")
+	}
+	assert.Equal(t, []int{1, 2}, attempts, "backoff attempts")
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	b := ExponentialBackoff{Initial: 10 * time.Millisecond, Max: 100 * time.Millisecond}
+	cases := []struct {
+		att int
+		out time.Duration
+	}{
+		{1, 10 * time.Millisecond},
+		{2, 20 * time.Millisecond},
+		{3, 40 * time.Millisecond},
+		{4, 80 * time.Millisecond},
+		{5, 100 * time.Millisecond},
+		{100, 100 * time.Millisecond},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.out, b.NextDelay(c.att), "attempt %d", c.att)
+	}
+
+	b.Max = 0
+	assert.True(t, b.NextDelay(1000) > 0, "no overflow")
+
+	b = ExponentialBackoff{Initial: 10 * time.Millisecond, Jitter: true}
+	for i := 1; i < 10; i++ {
+		d := b.NextDelay(i)
+		assert.True(t, d >= 0 && d <= (10*time.Millisecond)<<uint(i-1), "jitter for attempt %d: %s", i, d)
+	}
 }
 
 func TestRetryConnErrs(t *testing.T) {
@@ -220,3 +331,268 @@ func TestRetryConnMoved(t *testing.T) {
 		assert.Equal(t, "x", v, "GET value")
 	}
 }
+
+func TestRetryConnLoading(t *testing.T) {
+	var s *redistest.MockServer
+	var calls, refreshes int32
+
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			if args[0] == "SLOTS" {
+				atomic.AddInt32(&refreshes, 1)
+			}
+			return mockClusterSlots(s.Addr)
+		case "GET":
+			switch atomic.AddInt32(&calls, 1) {
+			case 1:
+				return resp.Error("LOADING Redis is loading the dataset in memory")
+			case 2:
+				return resp.Error("MASTERDOWN Link with MASTER is down")
+			}
+			return "ok"
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s.Addr},
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	conn := c.Get()
+	defer conn.Close()
+
+	var attempts []int
+	rc, err := RetryConn(conn, 3, time.Hour, RetryBackoff(BackoffFunc(func(att int) time.Duration {
+		attempts = append(attempts, att)
+		return time.Millisecond
+	})))
+	require.NoError(t, err, "RetryConn")
+	v, err := redis.String(rc.Do("GET", "x"))
+	if assert.NoError(t, err, "GET with retry") {
+		assert.Equal(t, "ok", v, "expected result")
+	}
+	assert.Equal(t, []int{1, 2}, attempts, "backoff attempts")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&refreshes), "no refresh")
+}
+
+func TestRetryConnClusterDown(t *testing.T) {
+	var s *redistest.MockServer
+	var down int32
+
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return mockClusterSlots(s.Addr)
+		case "GET":
+			if atomic.AddInt32(&down, 1) <= 2 {
+				return resp.Error("CLUSTERDOWN The cluster is down")
+			}
+			return "ok"
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s.Addr},
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	conn := c.Get()
+	defer conn.Close()
+
+	// not retried by default
+	rc, err := RetryConn(conn, 3, time.Millisecond)
+	require.NoError(t, err, "RetryConn")
+	_, err = rc.Do("GET", "x")
+	if assert.Error(t, err, "GET without RetryClusterDown") {
+		assert.True(t, IsClusterDown(err), "IsClusterDown")
+	}
+
+	atomic.StoreInt32(&down, 0)
+	rc, err = RetryConn(conn, 3, time.Millisecond, RetryClusterDown())
+	require.NoError(t, err, "RetryConn")
+	v, err := rc.Do("GET", "x")
+	if assert.NoError(t, err, "GET with RetryClusterDown") {
+		assert.Equal(t, []byte("ok"), v, "expected result")
+	}
+
+	atomic.StoreInt32(&down, -10)
+	_, err = rc.Do("GET", "x")
+	if assert.Error(t, err, "GET with too many attempts") {
+		assert.Contains(t, err.Error(), "too many attempts", "expected message")
+	}
+}
+
+func TestRetryConnConnErrors(t *testing.T) {
+	var s1, s2 *redistest.MockServer
+	var failover int32
+	handler := func(name string) func(string, ...string) interface{} {
+		return func(cmd string, args ...string) interface{} {
+			switch cmd {
+			case "CLUSTER":
+				if atomic.LoadInt32(&failover) == 1 {
+					return mockClusterSlots(s2.Addr)
+				}
+				return mockClusterSlots(s1.Addr, s2.Addr)
+			case "GET":
+				return name
+			}
+			return resp.Error("unexpected command " + cmd)
+		}
+	}
+	s1 = redistest.StartMockServer(t, handler("s1"))
+	defer s1.Close()
+	s2 = redistest.StartMockServer(t, handler("s2"))
+	defer s2.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s1.Addr},
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	conn := c.Get()
+	defer conn.Close()
+	rc, err := RetryConn(conn, 10, 10*time.Millisecond, RetryConnErrors())
+	require.NoError(t, err, "RetryConn")
+
+	v, err := redis.String(rc.Do("GET", "x"))
+	require.NoError(t, err, "GET")
+	assert.Equal(t, "s1", v, "bound to s1")
+
+	// the master fails, the replica is promoted
+	atomic.StoreInt32(&failover, 1)
+	s1.Close()
+
+	v, err = redis.String(rc.Do("GET", "x"))
+	if assert.NoError(t, err, "GET after failover") {
+		assert.Equal(t, "s2", v, "bound to s2")
+	}
+
+	// without the option, the connection error is returned
+	conn2 := c.Get()
+	defer conn2.Close()
+	_, err = conn2.Do("GET", "x")
+	require.NoError(t, err, "GET")
+	s2.Close()
+	rc, err = RetryConn(conn2, 10, 10*time.Millisecond)
+	require.NoError(t, err, "RetryConn")
+	_, err = rc.Do("GET", "x")
+	assert.Error(t, err, "GET without RetryConnErrors")
+	assert.Error(t, conn2.Err(), "connection broken")
+}
+
+func TestRetryConnRefreshOnConnErrors(t *testing.T) {
+	var s1, s2 *redistest.MockServer
+	var failover int32
+	handler := func(name string) func(string, ...string) interface{} {
+		return func(cmd string, args ...string) interface{} {
+			switch cmd {
+			case "CLUSTER":
+				if atomic.LoadInt32(&failover) == 1 {
+					return mockClusterSlots(s2.Addr)
+				}
+				return mockClusterSlots(s1.Addr, s2.Addr)
+			case "GET":
+				return name
+			}
+			return resp.Error("unexpected command " + cmd)
+		}
+	}
+	s1 = redistest.StartMockServer(t, handler("s1"))
+	defer s1.Close()
+	s2 = redistest.StartMockServer(t, handler("s2"))
+	defer s2.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s1.Addr},
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	conn := c.Get()
+	defer conn.Close()
+	rc, err := RetryConn(conn, 2, 0, RetryRefreshOnConnErrors())
+	require.NoError(t, err, "RetryConn")
+
+	v, err := redis.String(rc.Do("GET", "x"))
+	require.NoError(t, err, "GET")
+	assert.Equal(t, "s1", v, "bound to s1")
+
+	// the master fails, the replica is promoted
+	atomic.StoreInt32(&failover, 1)
+	s1.Close()
+
+	// a single retry, without delay, is enough because the mapping is
+	// refreshed before it
+	v, err = redis.String(rc.Do("GET", "x"))
+	if assert.NoError(t, err, "GET after failover") {
+		assert.Equal(t, "s2", v, "bound to s2")
+	}
+}
+
+func TestRetryConnDisableAutoRefreshOnMoved(t *testing.T) {
+	var s1, s2 *redistest.MockServer
+	var refreshes, calls int32
+	s1 = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			atomic.AddInt32(&refreshes, 1)
+			return mockClusterSlots(s1.Addr)
+		case "GET":
+			atomic.AddInt32(&calls, 1)
+			return resp.Error("MOVED " + strconv.Itoa(Slot(args[0])) + " " + s2.Addr)
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s1.Close()
+	s2 = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s2.Close()
+
+	c := &Cluster{
+		StartupNodes:              []string{s1.Addr},
+		DisableAutoRefreshOnMoved: true,
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+	refreshed := atomic.LoadInt32(&refreshes)
+
+	conn := c.Get()
+	defer conn.Close()
+	_, err := conn.Do("GET", "a")
+	if re := ParseRedir(err); assert.NotNil(t, re, "MOVED from Conn") {
+		assert.Equal(t, s2.Addr, re.Addr, "redirection address")
+	}
+
+	rc, err := RetryConn(c.Get(), 3, time.Millisecond)
+	require.NoError(t, err, "RetryConn")
+	defer rc.Close()
+	_, err = rc.Do("GET", "a")
+	if re := ParseRedir(err); assert.NotNil(t, re, "MOVED from RetryConn") {
+		assert.Equal(t, "MOVED", re.Type, "redirection type")
+	}
+
+	pc, err := RetryConn(c.Get(), 3, time.Millisecond, RetryPipeline(0))
+	require.NoError(t, err, "RetryConn with pipeline")
+	defer pc.Close()
+	require.NoError(t, pc.Send("GET", "a"), "Send")
+	require.NoError(t, pc.Flush(), "Flush")
+	_, err = pc.Receive()
+	assert.NotNil(t, ParseRedir(err), "MOVED from pipeline")
+
+	// the redirections were not followed and the mapping was not updated
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls), "calls")
+	assert.Equal(t, refreshed, atomic.LoadInt32(&refreshes), "refreshes")
+	addr, ok := c.NodeForKey("a")
+	assert.True(t, ok, "mapped")
+	assert.Equal(t, s1.Addr, addr, "mapping of a")
+}
@@ -0,0 +1,192 @@
+package redisc
+
+import (
+	"errors"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// RetryPipeline makes the connection support pipelining with Send, Flush
+// and Receive, with the same handling of redirections and retries as for
+// Do. The commands are buffered by Send, up to maxCmds commands (if
+// maxCmds is greater than 0, Send fails past that limit), and Flush
+// sends them to the node and reads all their replies. The commands that
+// fail with a redirection or a retried error are sent again, in their
+// original order: a MOVED redirection binds the connection to the new
+// node of the slot before the commands are sent again, an ASK
+// redirection sends the command to the importing node preceded by
+// ASKING, and the other errors are retried after the Backoff delay. The
+// replies are then returned by Receive, in the order of the commands.
+//
+// The commands that succeeded (or failed with a non-retried error) are
+// not sent again, so a retried command may execute after commands that
+// were sent after it. Commands that depend on each other should not be
+// pipelined with this option. If RetryConnErrors is also set, all the
+// commands without a reply when the connection broke are sent again,
+// even though they may have been executed, so they must be idempotent.
+//
+// Do fails if commands were sent but not flushed, or if replies were not
+// received yet.
+func RetryPipeline(maxCmds int) RetryOption {
+	return RetryOption{func(rc *retryConn) {
+		rc.pipeline = true
+		rc.maxPipeline = maxCmds
+	}}
+}
+
+func (rc *retryConn) send(cmd string, args ...interface{}) error {
+	if !rc.pipeline {
+		return errors.New("redisc: unsupported call to Send")
+	}
+	if err := rc.c.Err(); err != nil {
+		return err
+	}
+	if rc.maxPipeline > 0 && len(rc.pending) >= rc.maxPipeline {
+		return errors.New("redisc: too many pipelined commands")
+	}
+	rc.pending = append(rc.pending, pipelineCmd{cmd: cmd, args: args})
+	return nil
+}
+
+func (rc *retryConn) flush() error {
+	if !rc.pipeline {
+		return errors.New("redisc: unsupported call to Flush")
+	}
+	cmds := rc.pending
+	rc.pending = nil
+	if len(cmds) == 0 {
+		return rc.c.Err()
+	}
+
+	replies, err := rc.doPipeline(cmds)
+	rc.replies = append(rc.replies, replies...)
+	return err
+}
+
+func (rc *retryConn) receive() (interface{}, error) {
+	if !rc.pipeline {
+		return nil, errors.New("redisc: unsupported call to Receive")
+	}
+	if len(rc.replies) == 0 {
+		return nil, errors.New("redisc: no pending reply in pipeline")
+	}
+	r := rc.replies[0]
+	rc.replies[0] = pipelineReply{}
+	rc.replies = rc.replies[1:]
+	return r.v, r.err
+}
+
+// doPipeline executes the commands on the connection and returns their
+// replies, retrying the commands that failed as documented for
+// RetryPipeline. The returned error is the error of the connection to
+// the node, if it failed.
+func (rc *retryConn) doPipeline(cmds []pipelineCmd) ([]pipelineReply, error) {
+	replies := make([]pipelineReply, len(cmds))
+	todo := make([]int, len(cmds))
+	for i := range todo {
+		todo[i] = i
+	}
+
+	var att int
+	cluster := rc.c.cluster
+	for rc.maxAttempts <= 0 || att < rc.maxAttempts {
+		connErr := rc.sendPipeline(cmds, todo, replies)
+
+		var retry []int
+		var moved *RedirError
+		var redirected bool
+		for _, i := range todo {
+			err := replies[i].err
+			re := ParseRedir(err)
+			if re != nil && re.Type == "ASK" {
+				cluster.logf("redisc: %s redirection for slot %d to %s", re.Type, re.NewSlot, re.Addr)
+				replies[i].v, replies[i].err = rc.c.doAsking(re, cmds[i].cmd, cmds[i].args...)
+				err = replies[i].err
+				re = ParseRedir(err)
+			}
+
+			switch {
+			case re != nil && re.Type == "MOVED" && cluster.DisableAutoRefreshOnMoved:
+				// the caller handles the redirections, see DisableAutoRefreshOnMoved
+			case re != nil:
+				if re.Type == "MOVED" {
+					moved = re
+				}
+				redirected = true
+				retry = append(retry, i)
+			case IsTryAgain(err) || IsLoading(err) || IsMasterDown(err) ||
+				(rc.retryClusterDown && IsClusterDown(err)):
+				retry = append(retry, i)
+			case connErr != nil && err == connErr && rc.retryConnErrors:
+				retry = append(retry, i)
+			}
+		}
+		if len(retry) == 0 {
+			return replies, connErr
+		}
+		if connErr != nil && (!rc.retryConnErrors || !rc.unbindBroken()) {
+			return replies, connErr
+		}
+		if !redirected && !cluster.allowRetry() {
+			// the retries of a round take a single token of the budget
+			return replies, connErr
+		}
+
+		att++
+		if obs := cluster.Observer; obs != nil {
+			for _, i := range retry {
+				obs.OnRetry(cmds[i].cmd, att+1, replies[i].err)
+			}
+		}
+		if moved != nil {
+			if _, err := rc.c.followMoved(moved); err != nil {
+				// could not get connection to that node, return that error
+				for _, i := range retry {
+					replies[i] = pipelineReply{err: err}
+				}
+				return replies, err
+			}
+		}
+		if !redirected {
+			// redirections are retried immediately
+			time.Sleep(rc.backoff.NextDelay(att))
+		}
+		todo = retry
+	}
+
+	err := errors.New("redisc: too many attempts")
+	for _, i := range todo {
+		replies[i] = pipelineReply{err: err}
+	}
+	return replies, nil
+}
+
+// sendPipeline sends the commands at the todo indices on the connection
+// and stores their replies. If the connection fails, its error is
+// stored as reply of all the commands without a reply, and returned.
+func (rc *retryConn) sendPipeline(cmds []pipelineCmd, todo []int, replies []pipelineReply) error {
+	setErr := func(from int, err error) error {
+		for _, i := range todo[from:] {
+			replies[i] = pipelineReply{err: err}
+		}
+		return err
+	}
+
+	for _, i := range todo {
+		if err := rc.c.Send(cmds[i].cmd, cmds[i].args...); err != nil {
+			return setErr(0, err)
+		}
+	}
+	if err := rc.c.Flush(); err != nil {
+		return setErr(0, err)
+	}
+	for j, i := range todo {
+		v, err := rc.c.Receive()
+		if _, ok := err.(redis.Error); err != nil && !ok {
+			return setErr(j, err)
+		}
+		replies[i] = pipelineReply{v: v, err: err}
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package redisc
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/mna/redisc/redistest"
+	"github.com/mna/redisc/redistest/resp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryPipelineAskTryAgain(t *testing.T) {
+	var src, dst *redistest.MockServer
+	var tryAgain int32
+
+	src = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return mockClusterSlots(src.Addr)
+		case "GET":
+			if args[0] == "x" {
+				return resp.Error("ASK " + strconv.Itoa(Slot("x")) + " " + dst.Addr)
+			}
+			return "src"
+		case "INCR":
+			if atomic.AddInt32(&tryAgain, 1) == 1 {
+				return resp.Error("TRYAGAIN")
+			}
+			return int64(1)
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer src.Close()
+	dst = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "ASKING":
+			return resp.OK{}
+		case "GET":
+			return "dst"
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer dst.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{src.Addr},
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	conn := c.Get()
+	defer conn.Close()
+	rc, err := RetryConn(conn, 3, time.Millisecond, RetryPipeline(0))
+	require.NoError(t, err, "RetryConn")
+
+	require.NoError(t, rc.Send("GET", "y"), "Send GET y")
+	require.NoError(t, rc.Send("GET", "x"), "Send GET x")
+	require.NoError(t, rc.Send("INCR", "y"), "Send INCR y")
+
+	_, err = rc.Do("GET", "y")
+	assert.Error(t, err, "Do with pending commands")
+
+	require.NoError(t, rc.Flush(), "Flush")
+	for i, want := range []interface{}{"src", "dst", int64(1)} {
+		v, err := rc.Receive()
+		if assert.NoError(t, err, "Receive %d", i) {
+			if s, ok := v.([]byte); ok {
+				v = string(s)
+			}
+			assert.Equal(t, want, v, "reply %d", i)
+		}
+	}
+	_, err = rc.Receive()
+	assert.Error(t, err, "Receive without pending reply")
+	assert.Equal(t, int32(2), atomic.LoadInt32(&tryAgain), "INCR retried")
+}
+
+func TestRetryPipelineMoved(t *testing.T) {
+	var s1, s2 *redistest.MockServer
+	s1 = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return mockClusterSlots(s1.Addr)
+		case "GET":
+			return resp.Error("MOVED " + strconv.Itoa(Slot(args[0])) + " " + s2.Addr)
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s1.Close()
+	s2 = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return mockClusterSlots(s2.Addr)
+		case "GET":
+			return "s2"
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s2.Close()
+
+	c := &Cluster{
+		StartupNodes:    []string{s1.Addr},
+		RefreshCooldown: time.Minute,
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	conn := c.Get()
+	defer conn.Close()
+	rc, err := RetryConn(conn, 3, time.Millisecond, RetryPipeline(2))
+	require.NoError(t, err, "RetryConn")
+
+	require.NoError(t, rc.Send("GET", "a"), "Send")
+	require.NoError(t, rc.Send("GET", "a"), "Send")
+	if err := rc.Send("GET", "a"); assert.Error(t, err, "Send past the limit") {
+		assert.Contains(t, err.Error(), "too many pipelined commands", "expected message")
+	}
+	require.NoError(t, rc.Flush(), "Flush")
+	for i := 0; i < 2; i++ {
+		v, err := redis.String(rc.Receive())
+		if assert.NoError(t, err, "Receive %d", i) {
+			assert.Equal(t, "s2", v, "reply %d", i)
+		}
+	}
+
+	addr, ok := conn.(*Conn).BoundAddr()
+	assert.True(t, ok, "bound")
+	assert.Equal(t, s2.Addr, addr, "bound to the new node")
+}
+
+func TestRetryPipelineTooManyAttempts(t *testing.T) {
+	var s *redistest.MockServer
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return mockClusterSlots(s.Addr)
+		case "GET":
+			return resp.Error("TRYAGAIN")
+		case "SET":
+			return resp.OK{}
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s.Addr},
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	conn := c.Get()
+	defer conn.Close()
+	rc, err := RetryConn(conn, 2, time.Millisecond, RetryPipeline(0))
+	require.NoError(t, err, "RetryConn")
+
+	require.NoError(t, rc.Send("SET", "a", "1"), "Send SET")
+	require.NoError(t, rc.Send("GET", "a"), "Send GET")
+	require.NoError(t, rc.Flush(), "Flush")
+
+	v, err := redis.String(rc.Receive())
+	if assert.NoError(t, err, "SET") {
+		assert.Equal(t, "OK", v, "SET")
+	}
+	if _, err := rc.Receive(); assert.Error(t, err, "GET") {
+		assert.Contains(t, err.Error(), "too many attempts", "expected message")
+	}
+}
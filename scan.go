@@ -0,0 +1,134 @@
+package redisc
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Scan iterates over the keys of the whole cluster, by running the SCAN
+// command on each master node, one node after the other. It returns the
+// cursor to use for the next call, along with the keys returned by that
+// call. The iteration starts with an empty (or "0") cursor and is
+// complete when the returned cursor is "0", as for the SCAN command.
+// The match and count parameters are passed to the SCAN command, if
+// they are not empty or zero.
+//
+// The cursor is opaque, it encodes both the address of the node being
+// scanned and that node's SCAN cursor, so that an iteration can be
+// resumed at any time. The list of master nodes is read on each call,
+// so nodes that are added or removed during the iteration are taken into
+// account: if the node of the cursor is gone, the iteration resumes at
+// the start of the next node. The same guarantees as for the SCAN command
+// apply for each node, but keys that move to another node during the
+// iteration may be missed or returned more than once.
+func (c *Cluster) Scan(cursor, match string, count int) (string, []string, error) {
+	return c.scan(cursor, match, count, func() []string {
+		return c.getNodeAddrs(false)
+	})
+}
+
+// ScanNodes is like Scan, but it iterates over the keys of the nodes for
+// which filter returns true, e.g. the masters that serve some slots. The
+// nodes are those returned by the Nodes method, and they are read on each
+// call, as for Scan. If the filter selects both a master and its
+// replicas, their keys are returned for each of them.
+func (c *Cluster) ScanNodes(filter NodeFilter, cursor, match string, count int) (string, []string, error) {
+	return c.scan(cursor, match, count, func() []string {
+		return c.filterNodeAddrs(filter)
+	})
+}
+
+// scan implements Scan and ScanNodes, nodeAddrs returns the addresses of
+// the nodes to scan.
+func (c *Cluster) scan(cursor, match string, count int, nodeAddrs func() []string) (string, []string, error) {
+	c.mu.Lock()
+	err := c.err
+	c.mu.Unlock()
+	if err != nil {
+		return "", nil, err
+	}
+
+	addr, nodeCursor, err := parseScanCursor(cursor)
+	if err != nil {
+		return "", nil, err
+	}
+
+	nodes := nodeAddrs()
+	sort.Strings(nodes)
+	if len(nodes) == 0 {
+		return "", nil, errors.New("redisc: no node to scan")
+	}
+	if addr == "" {
+		addr = nodes[0]
+	} else if !isIn(nodes, addr) {
+		// that node is not scanned anymore, continue with the next one
+		if addr = nextScanAddr(nodes, addr); addr == "" {
+			return "0", nil, nil
+		}
+		nodeCursor = "0"
+	}
+
+	conn, err := c.getConnForAddr(context.Background(), addr, false)
+	if err != nil {
+		// node may be gone, make sure the list of nodes gets updated
+		c.needsRefresh(nil)
+		return "", nil, err
+	}
+	defer conn.Close()
+
+	args := redis.Args{nodeCursor}
+	if match != "" {
+		args = args.Add("MATCH", match)
+	}
+	if count > 0 {
+		args = args.Add("COUNT", count)
+	}
+	vals, err := redis.Values(conn.Do("SCAN", args...))
+	if err != nil {
+		return "", nil, err
+	}
+
+	var keys []string
+	if _, err := redis.Scan(vals, &nodeCursor, &keys); err != nil {
+		return "", nil, err
+	}
+
+	if nodeCursor == "0" {
+		// done with this node, move on to the next one
+		if addr = nextScanAddr(nodes, addr); addr == "" {
+			return "0", keys, nil
+		}
+	}
+	return addr + "/" + nodeCursor, keys, nil
+}
+
+// nextScanAddr returns the first address in the sorted addrs list that
+// comes after addr, or an empty string if there is none.
+func nextScanAddr(addrs []string, addr string) string {
+	ix := sort.SearchStrings(addrs, addr)
+	if ix < len(addrs) && addrs[ix] == addr {
+		ix++
+	}
+	if ix < len(addrs) {
+		return addrs[ix]
+	}
+	return ""
+}
+
+// parseScanCursor parses a cursor returned by Cluster.Scan and returns
+// the node address and its SCAN cursor. An empty address is returned
+// for the initial cursor.
+func parseScanCursor(cursor string) (addr, nodeCursor string, err error) {
+	if cursor == "" || cursor == "0" {
+		return "", "0", nil
+	}
+	ix := strings.LastIndex(cursor, "/")
+	if ix <= 0 || ix == len(cursor)-1 {
+		return "", "", errors.New("redisc: invalid scan cursor")
+	}
+	return cursor[:ix], cursor[ix+1:], nil
+}
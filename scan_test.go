@@ -0,0 +1,102 @@
+package redisc
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/mna/redisc/redistest"
+	"github.com/mna/redisc/redistest/resp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClusterScan(t *testing.T) {
+	var s1, s2 *redistest.MockServer
+
+	clusterSlots := func() interface{} {
+		host1, port1, _ := net.SplitHostPort(s1.Addr)
+		host2, port2, _ := net.SplitHostPort(s2.Addr)
+		nPort1, _ := strconv.Atoi(port1)
+		nPort2, _ := strconv.Atoi(port2)
+		return resp.Array{
+			0: resp.Array{0: int64(0), 1: int64(8191), 2: resp.Array{0: host1, 1: int64(nPort1)}},
+			1: resp.Array{0: int64(8192), 1: int64(16383), 2: resp.Array{0: host2, 1: int64(nPort2)}},
+		}
+	}
+	scanHandler := func(prefix string) func(string, ...string) interface{} {
+		return func(cmd string, args ...string) interface{} {
+			switch cmd {
+			case "CLUSTER":
+				return clusterSlots()
+			case "SCAN":
+				if args[0] == "0" {
+					return resp.Array{"7", resp.Array{prefix + "1", prefix + "2"}}
+				}
+				return resp.Array{"0", resp.Array{prefix + "3"}}
+			}
+			return resp.Error("unexpected command " + cmd)
+		}
+	}
+	s1 = redistest.StartMockServer(t, scanHandler("a"))
+	defer s1.Close()
+	s2 = redistest.StartMockServer(t, scanHandler("b"))
+	defer s2.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s1.Addr},
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	var all []string
+	var calls int
+	cursor := "0"
+	for {
+		next, keys, err := c.Scan(cursor, "*", 10)
+		require.NoError(t, err, "Scan")
+		all = append(all, keys...)
+		calls++
+		if next == "0" {
+			break
+		}
+		require.NotEqual(t, cursor, next, "cursor moves forward")
+		cursor = next
+	}
+	sort.Strings(all)
+	assert.Equal(t, []string{"a1", "a2", "a3", "b1", "b2", "b3"}, all, "scanned keys")
+	assert.Equal(t, 4, calls, "number of calls")
+
+	// cursor of a node that is not in the cluster resumes with the next node
+	_, _, err := c.Scan("x/12", "", 0)
+	assert.NoError(t, err, "Scan with unknown node")
+	_, _, err = c.Scan("invalid", "", 0)
+	assert.Error(t, err, "Scan with invalid cursor")
+
+	// scan only the node that serves slot 10000
+	filter := func(n Node) bool {
+		for _, r := range n.Slots {
+			if r.Start <= 10000 && 10000 <= r.End {
+				return true
+			}
+		}
+		return false
+	}
+	all = nil
+	cursor = "0"
+	for {
+		next, keys, err := c.ScanNodes(filter, cursor, "", 0)
+		require.NoError(t, err, "ScanNodes")
+		all = append(all, keys...)
+		if next == "0" {
+			break
+		}
+		cursor = next
+	}
+	sort.Strings(all)
+	assert.Equal(t, []string{"b1", "b2", "b3"}, all, "scanned keys of filtered node")
+
+	_, _, err = c.ScanNodes(func(Node) bool { return false }, "0", "", 0)
+	assert.Error(t, err, "ScanNodes without node")
+}
@@ -0,0 +1,130 @@
+package redisc
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Eval runs the Lua script with the EVAL command on the node that owns
+// the slot of the keys. All keys must belong to the same slot, otherwise
+// an error is returned before the script is sent. If no key is provided,
+// the script runs on a random node.
+func (c *Cluster) Eval(script string, keys []string, args ...interface{}) (interface{}, error) {
+	return c.doWithKeys(keys, func(conn redis.Conn) (interface{}, error) {
+		return conn.Do("EVAL", evalArgs(script, keys, args)...)
+	})
+}
+
+// EvalSha runs the script identified by its SHA1 digest with the EVALSHA
+// command on the node that owns the slot of the keys. It fails with a
+// NOSCRIPT error if the script is not loaded on that node, use EvalScript
+// to automatically load the script in that case, or ScriptLoad to load
+// it on all masters beforehand.
+func (c *Cluster) EvalSha(sha string, keys []string, args ...interface{}) (interface{}, error) {
+	return c.doWithKeys(keys, func(conn redis.Conn) (interface{}, error) {
+		return conn.Do("EVALSHA", evalArgs(sha, keys, args)...)
+	})
+}
+
+// EvalScript runs the redigo script with its Do method on the node that
+// owns the slot of the keys. It first tries with the EVALSHA command and
+// if the script is not loaded on that node, it sends the full script with
+// EVAL, loading it on that node. The script must have been created with
+// the same number of keys as provided in keys.
+func (c *Cluster) EvalScript(script *redis.Script, keys []string, args ...interface{}) (interface{}, error) {
+	return c.doWithKeys(keys, func(conn redis.Conn) (interface{}, error) {
+		keysAndArgs := make(redis.Args, 0, len(keys)+len(args))
+		for _, k := range keys {
+			keysAndArgs = append(keysAndArgs, k)
+		}
+		return script.Do(conn, append(keysAndArgs, args...)...)
+	})
+}
+
+// ScriptLoad loads the Lua script on all master nodes with the SCRIPT
+// LOAD command, so that it can be executed with EvalSha on any slot. The
+// script is loaded concurrently on each node, and the SHA1 digest
+// returned by each node is verified against the digest of the script.
+// It returns that digest. If the script could not be loaded on some
+// nodes, the digest is still returned along with the error of the first
+// node (by address) that failed.
+//
+// Scripts are not replicated to the nodes that join the cluster
+// afterwards (e.g. after a reshard), and they are lost when a node
+// restarts, so EvalScript should be preferred when possible, as it
+// loads the script automatically on a NOSCRIPT error.
+func (c *Cluster) ScriptLoad(script string) (string, error) {
+	c.mu.Lock()
+	err := c.err
+	c.mu.Unlock()
+	if err != nil {
+		return "", err
+	}
+
+	masters := c.getNodeAddrs(false)
+	if len(masters) == 0 {
+		return "", errors.New("redisc: no master node to load the script")
+	}
+	sort.Strings(masters)
+
+	sum := sha1.Sum([]byte(script))
+	sha := hex.EncodeToString(sum[:])
+
+	errs := make([]error, len(masters))
+	var wg sync.WaitGroup
+	wg.Add(len(masters))
+	for i, addr := range masters {
+		go func(i int, addr string) {
+			defer wg.Done()
+
+			conn, err := c.getConnForAddr(context.Background(), addr, false)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			defer conn.Close()
+
+			nodeSha, err := redis.String(conn.Do("SCRIPT", "LOAD", script))
+			if err == nil && nodeSha != sha {
+				err = fmt.Errorf("redisc: unexpected SHA1 digest %s, want %s", nodeSha, sha)
+			}
+			errs[i] = err
+		}(i, addr)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return sha, fmt.Errorf("redisc: failed to load script on %s: %v", masters[i], err)
+		}
+	}
+	return sha, nil
+}
+
+func evalArgs(script string, keys []string, args []interface{}) redis.Args {
+	all := make(redis.Args, 0, 2+len(keys)+len(args))
+	all = append(all, script, len(keys))
+	for _, k := range keys {
+		all = append(all, k)
+	}
+	return append(all, args...)
+}
+
+// doWithKeys calls fn with a connection bound to the slot of the keys,
+// and closes the connection afterwards.
+func (c *Cluster) doWithKeys(keys []string, fn func(conn redis.Conn) (interface{}, error)) (interface{}, error) {
+	conn := c.Get()
+	defer conn.Close()
+
+	if err := BindConn(conn, keys...); err != nil {
+		return nil, err
+	}
+	return fn(conn)
+}
@@ -0,0 +1,117 @@
+package redisc
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/mna/redisc/redistest"
+	"github.com/mna/redisc/redistest/resp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClusterEval(t *testing.T) {
+	var s *redistest.MockServer
+	var loaded bool
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return mockClusterSlots(s.Addr)
+		case "EVAL":
+			loaded = true
+			return resp.Array{"eval", args[1], args[2]}
+		case "EVALSHA":
+			if !loaded {
+				return resp.Error("NOSCRIPT No matching script")
+			}
+			return resp.Array{"evalsha", args[1], args[2]}
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s.Addr},
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	_, err := c.Eval("return 1", []string{"a", "b"})
+	if assert.Error(t, err, "Eval with keys in different slots") {
+		assert.Contains(t, err.Error(), "keys do not belong to the same slot", "expected message")
+	}
+
+	_, err = c.EvalSha("abcd", []string{"{a}1", "{a}2"})
+	if assert.Error(t, err, "EvalSha not loaded") {
+		assert.Contains(t, err.Error(), "NOSCRIPT", "expected message")
+	}
+
+	script := redis.NewScript(1, "return 1")
+	v, err := redis.Strings(c.EvalScript(script, []string{"a"}, "x"))
+	if assert.NoError(t, err, "EvalScript") {
+		assert.Equal(t, []string{"eval", "1", "a"}, v, "EvalScript loads the script")
+	}
+	v, err = redis.Strings(c.EvalScript(script, []string{"a"}, "x"))
+	if assert.NoError(t, err, "EvalScript") {
+		assert.Equal(t, []string{"evalsha", "1", "a"}, v, "EvalScript uses the SHA")
+	}
+
+	v, err = redis.Strings(c.Eval("return 1", []string{"{a}1", "{a}2"}, "x"))
+	if assert.NoError(t, err, "Eval") {
+		assert.Equal(t, []string{"eval", "2", "{a}1"}, v, "Eval")
+	}
+}
+
+func TestClusterScriptLoad(t *testing.T) {
+	const script = "return 1"
+	const sha = "e0e1f9fabfc9d4800c877a703b823ac0578ff8db"
+
+	var s1, s2 *redistest.MockServer
+	var badSha int32
+	handler := func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return resp.Array{
+				0: resp.Array{0: int64(0), 1: int64(8000), 2: resp.Array{0: "127.0.0.1", 1: int64(mockPort(s1.Addr))}},
+				1: resp.Array{0: int64(8001), 1: int64(16383), 2: resp.Array{0: "127.0.0.1", 1: int64(mockPort(s2.Addr))}},
+			}
+		case "SCRIPT":
+			if args[0] == "LOAD" && args[1] == script {
+				if atomic.LoadInt32(&badSha) == 1 {
+					return "abcd"
+				}
+				return sha
+			}
+		}
+		return resp.Error("unexpected command " + cmd)
+	}
+	s1 = redistest.StartMockServer(t, handler)
+	defer s1.Close()
+	s2 = redistest.StartMockServer(t, handler)
+	defer s2.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s1.Addr},
+		CreatePool:   createPool,
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	got, err := c.ScriptLoad(script)
+	require.NoError(t, err, "ScriptLoad")
+	assert.Equal(t, sha, got, "SHA1 digest")
+	stats := c.Stats()
+	assert.Len(t, stats, 2, "script loaded on both masters")
+
+	_, err = c.ScriptLoad("return 2")
+	if assert.Error(t, err, "ScriptLoad failure") {
+		assert.Contains(t, err.Error(), "failed to load script", "expected message")
+	}
+
+	atomic.StoreInt32(&badSha, 1)
+	_, err = c.ScriptLoad(script)
+	if assert.Error(t, err, "ScriptLoad with unexpected digest") {
+		assert.Contains(t, err.Error(), "unexpected SHA1 digest abcd", "expected message")
+	}
+}
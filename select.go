@@ -0,0 +1,24 @@
+package redisc
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrSelect is the error returned by the connections of a cluster with
+// GuardSelect set when the SELECT command is executed with a database
+// other than 0, as a redis cluster only supports the database 0.
+var ErrSelect = errors.New("redisc: SELECT is not supported in cluster mode, only database 0 is available")
+
+// guardSelect handles the SELECT command if the cluster's GuardSelect field
+// is set. It returns true if the command was handled, along with its reply
+// and error, false if it must be executed.
+func (c *Cluster) guardSelect(cmd string, args []interface{}) (bool, interface{}, error) {
+	if !c.GuardSelect || !strings.EqualFold(cmd, "SELECT") {
+		return false, nil, nil
+	}
+	if len(args) == 1 && keyString(args[0]) == "0" {
+		return true, "OK", nil
+	}
+	return true, nil, ErrSelect
+}
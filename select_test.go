@@ -0,0 +1,61 @@
+package redisc
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/mna/redisc/redistest"
+	"github.com/mna/redisc/redistest/resp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClusterGuardSelect(t *testing.T) {
+	var s *redistest.MockServer
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return mockClusterSlots(s.Addr)
+		case "SELECT":
+			return resp.Error("ERR SELECT is not allowed in cluster mode")
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s.Addr},
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	conn := c.Get().(*Conn)
+	defer conn.Close()
+	_, err := conn.Do("SELECT", 0)
+	assert.EqualError(t, err, "ERR SELECT is not allowed in cluster mode", "SELECT without guard")
+
+	c.GuardSelect = true
+	conn = c.Get().(*Conn)
+	defer conn.Close()
+	v, err := redis.String(conn.Do("select", "0"))
+	if assert.NoError(t, err, "SELECT 0") {
+		assert.Equal(t, "OK", v, "SELECT 0 reply")
+	}
+	_, err = conn.DoContext(context.Background(), "SELECT", 0)
+	assert.NoError(t, err, "SELECT 0 with DoContext")
+	_, ok := conn.BoundAddr()
+	assert.False(t, ok, "not bound by SELECT")
+
+	_, err = conn.Do("SELECT", 1)
+	assert.True(t, errors.Is(err, ErrSelect), "SELECT 1")
+	_, err = conn.DoWithTimeout(0, "SELECT", 2)
+	assert.True(t, errors.Is(err, ErrSelect), "SELECT 2 with DoWithTimeout")
+
+	rc, err := RetryConn(c.Get(), 3, 0)
+	require.NoError(t, err, "RetryConn")
+	defer rc.Close()
+	_, err = rc.Do("SELECT", 0)
+	assert.NoError(t, err, "SELECT 0 with RetryConn")
+}
@@ -0,0 +1,133 @@
+package redisc
+
+import (
+	"context"
+	"strings"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// keySpec is the key positions of a command, as reported by COMMAND INFO.
+type keySpec struct {
+	known   bool // false if the command is unknown to the server
+	first   int  // position of the first key (the command is at 0), 0 if none
+	last    int  // position of the last key, negative if relative to the end
+	step    int  // step between the keys
+	movable bool // the key positions depend on the arguments
+}
+
+// keys returns the keys in args (the arguments without the command name)
+// at the positions of the spec.
+func (s *keySpec) keys(args []interface{}) []interface{} {
+	if s.first <= 0 || s.step <= 0 {
+		return nil
+	}
+	last := s.last
+	if last < 0 {
+		last += len(args) + 1
+	}
+	if last > len(args) {
+		last = len(args)
+	}
+	var keys []interface{}
+	for i := s.first; i <= last; i += s.step {
+		keys = append(keys, args[i-1])
+	}
+	return keys
+}
+
+// serverKeys returns the keys of the upper-case command name as reported
+// by the server, or false if they could not be obtained.
+func (c *Cluster) serverKeys(name string, args []interface{}) ([]interface{}, bool) {
+	spec, err := c.getKeySpec(name)
+	if err != nil {
+		c.logf("redisc: failed to get the keys of %s: %v", name, err)
+		return nil, false
+	}
+	if !spec.known {
+		return nil, false
+	}
+	if !spec.movable {
+		return spec.keys(args), true
+	}
+
+	if fn := commandKeys[name]; fn != nil {
+		return fn(args), true
+	}
+	keys, err := c.doCommand(func(conn redis.Conn) (interface{}, error) {
+		return conn.Do("COMMAND", append([]interface{}{"GETKEYS", name}, args...)...)
+	})
+	if err != nil {
+		c.logf("redisc: failed to get the keys of %s: %v", name, err)
+		return nil, false
+	}
+	vals, _ := keys.([]interface{})
+	return vals, true
+}
+
+// getKeySpec returns the key positions of the upper-case command name,
+// querying the server with COMMAND INFO the first time.
+func (c *Cluster) getKeySpec(name string) (*keySpec, error) {
+	c.keySpecsMu.Lock()
+	spec := c.keySpecs[name]
+	c.keySpecsMu.Unlock()
+	if spec != nil {
+		return spec, nil
+	}
+
+	v, err := c.doCommand(func(conn redis.Conn) (interface{}, error) {
+		return conn.Do("COMMAND", "INFO", name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	infos, err := redis.Values(v, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	spec = &keySpec{}
+	if len(infos) > 0 && infos[0] != nil {
+		// name, arity, flags, first key, last key, step, ...
+		info, err := redis.Values(infos[0], nil)
+		if err != nil {
+			return nil, err
+		}
+		var cmd string
+		var arity int
+		var rawFlags []interface{}
+		if _, err := redis.Scan(info, &cmd, &arity, &rawFlags, &spec.first, &spec.last, &spec.step); err != nil {
+			return nil, err
+		}
+		// the flags are simple strings, which redis.Scan doesn't convert
+		flags, err := redis.Strings(rawFlags, nil)
+		if err != nil {
+			return nil, err
+		}
+		spec.known = true
+		for _, f := range flags {
+			if strings.EqualFold(f, "movablekeys") {
+				spec.movable = true
+			}
+		}
+	}
+
+	c.keySpecsMu.Lock()
+	if c.keySpecs == nil {
+		c.keySpecs = make(map[string]*keySpec)
+	}
+	c.keySpecs[name] = spec
+	c.keySpecsMu.Unlock()
+	return spec, nil
+}
+
+// doCommand calls fn with a connection to a random node and closes the
+// connection afterwards.
+func (c *Cluster) doCommand(fn func(conn redis.Conn) (interface{}, error)) (interface{}, error) {
+	conn, _, err := c.getRandomConn(context.Background(), false, false)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return fn(conn)
+}
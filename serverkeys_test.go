@@ -0,0 +1,70 @@
+package redisc
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mna/redisc/redistest"
+	"github.com/mna/redisc/redistest/resp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClusterServerKeyExtraction(t *testing.T) {
+	var mu sync.Mutex
+	queries := make(map[string]int)
+	var s *redistest.MockServer
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return mockClusterSlots(s.Addr)
+		case "COMMAND":
+			mu.Lock()
+			queries[args[0]+" "+args[1]]++
+			mu.Unlock()
+
+			if args[0] == "GETKEYS" {
+				// MYMOD.MULTI numkeys key...
+				return resp.Array{args[3]}
+			}
+			switch strings.ToUpper(args[1]) {
+			case "MYMOD.GET":
+				return resp.Array{resp.Array{"mymod.get", int64(3), resp.Array{resp.SimpleString("readonly")}, int64(2), int64(2), int64(1)}}
+			case "MYMOD.MULTI":
+				return resp.Array{resp.Array{"mymod.multi", int64(-2), resp.Array{resp.SimpleString("movablekeys")}, int64(0), int64(0), int64(0)}}
+			case "EVAL":
+				return resp.Array{resp.Array{"eval", int64(-3), resp.Array{resp.SimpleString("movablekeys")}, int64(0), int64(0), int64(0)}}
+			}
+			return resp.Array{nil}
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	c := &Cluster{
+		StartupNodes:           []string{s.Addr},
+		UseServerKeyExtraction: true,
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	// "a" is in slot 15495, "b" in slot 3300
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, 15495, c.cmdSlot("mymod.get", []interface{}{"opt", "a"}), "fixed key position")
+		assert.Equal(t, 3300, c.cmdSlot("MYMOD.MULTI", []interface{}{1, "b"}), "movable keys")
+		assert.Equal(t, 15495, c.cmdSlot("EVAL", []interface{}{"return 1", 1, "a"}), "movable keys in table")
+		assert.Equal(t, 3300, c.cmdSlot("UNKNOWN", []interface{}{"b", "a"}), "unknown command")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := map[string]int{
+		"INFO MYMOD.GET":      1,
+		"INFO MYMOD.MULTI":    1,
+		"INFO EVAL":           1,
+		"INFO UNKNOWN":        1,
+		"GETKEYS MYMOD.MULTI": 2,
+	}
+	assert.Equal(t, want, queries, "queries")
+}
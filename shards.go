@@ -0,0 +1,146 @@
+package redisc
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// getClusterShards returns the mapping of slots to nodes as reported by
+// the CLUSTER SHARDS command (redis 7+). Replicas that are not online
+// are excluded from the mapping.
+func getClusterShards(conn redis.Conn, timeout time.Duration) ([]slotMapping, error) {
+	shards, err := redis.Values(redis.DoWithTimeout(conn, timeout, "CLUSTER", "SHARDS"))
+	if err != nil {
+		return nil, err
+	}
+
+	var m []slotMapping
+	for _, shard := range shards {
+		fields, err := redis.Values(shard, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(fields)%2 != 0 {
+			return nil, errors.New("redisc: invalid CLUSTER SHARDS reply")
+		}
+
+		var slots []int
+		var master shardNode
+		var replicas []shardNode
+		for i := 0; i < len(fields); i += 2 {
+			key, err := redis.String(fields[i], nil)
+			if err != nil {
+				return nil, err
+			}
+
+			switch key {
+			case "slots":
+				if slots, err = redis.Ints(fields[i+1], nil); err != nil {
+					return nil, err
+				}
+				if len(slots)%2 != 0 {
+					return nil, errors.New("redisc: invalid CLUSTER SHARDS slots")
+				}
+
+			case "nodes":
+				nodes, err := redis.Values(fields[i+1], nil)
+				if err != nil {
+					return nil, err
+				}
+				for _, node := range nodes {
+					sn, err := parseShardNode(node)
+					if err != nil {
+						return nil, err
+					}
+					if sn.role == "master" {
+						master = sn
+					} else if sn.health == "online" {
+						replicas = append(replicas, sn)
+					}
+				}
+			}
+		}
+
+		if master.addr == "" || len(slots) == 0 {
+			// shard without slots or without master, nothing to map
+			continue
+		}
+
+		nodes := make([]string, 0, len(replicas)+1)
+		infos := make([]nodeInfo, 0, len(replicas)+1)
+		for _, sn := range append([]shardNode{master}, replicas...) {
+			nodes = append(nodes, sn.addr)
+			infos = append(infos, sn.nodeInfo)
+		}
+		for i := 0; i < len(slots); i += 2 {
+			m = append(m, slotMapping{start: slots[i], end: slots[i+1], nodes: nodes, infos: infos})
+		}
+	}
+	return m, nil
+}
+
+type shardNode struct {
+	nodeInfo
+	addr string
+	role string
+}
+
+// parseShardNode parses a node's description in a CLUSTER SHARDS reply.
+func parseShardNode(node interface{}) (shardNode, error) {
+	var sn shardNode
+
+	fields, err := redis.Values(node, nil)
+	if err != nil {
+		return sn, err
+	}
+	if len(fields)%2 != 0 {
+		return sn, errors.New("redisc: invalid CLUSTER SHARDS node")
+	}
+
+	var ip, endpoint string
+	var port, tlsPort int
+	for i := 0; i < len(fields); i += 2 {
+		key, err := redis.String(fields[i], nil)
+		if err != nil {
+			return sn, err
+		}
+
+		switch key {
+		case "id":
+			sn.id, err = redis.String(fields[i+1], nil)
+		case "ip":
+			ip, err = redis.String(fields[i+1], nil)
+		case "endpoint":
+			endpoint, err = redis.String(fields[i+1], nil)
+		case "port":
+			port, err = redis.Int(fields[i+1], nil)
+		case "tls-port":
+			tlsPort, err = redis.Int(fields[i+1], nil)
+		case "hostname":
+			sn.hostname, err = redis.String(fields[i+1], nil)
+		case "role":
+			sn.role, err = redis.String(fields[i+1], nil)
+		case "health":
+			sn.health, err = redis.String(fields[i+1], nil)
+		}
+		if err != nil {
+			return sn, err
+		}
+	}
+
+	// the endpoint is the preferred address, as for CLUSTER SLOTS
+	host := endpoint
+	if host == "" || host == "?" {
+		host = ip
+	}
+	if port == 0 {
+		port = tlsPort
+	}
+	if host != "" && port != 0 {
+		sn.addr = host + ":" + strconv.Itoa(port)
+	}
+	return sn, nil
+}
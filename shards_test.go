@@ -0,0 +1,110 @@
+package redisc
+
+import (
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mna/redisc/redistest"
+	"github.com/mna/redisc/redistest/resp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mockShardNode(addr, id, role, health string) resp.Array {
+	host, port, _ := net.SplitHostPort(addr)
+	nPort, _ := strconv.Atoi(port)
+	return resp.Array{
+		"id", id,
+		"port", int64(nPort),
+		"ip", "127.0.0.1",
+		"endpoint", host,
+		"role", role,
+		"replication-offset", int64(72156),
+		"health", health,
+	}
+}
+
+func TestClusterRefreshShards(t *testing.T) {
+	var shards int32
+	var s *redistest.MockServer
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch {
+		case cmd == "CLUSTER" && args[0] == "SHARDS":
+			atomic.AddInt32(&shards, 1)
+			return resp.Array{
+				resp.Array{
+					"slots", resp.Array{int64(0), int64(99), int64(200), int64(hashSlots - 1)},
+					"nodes", resp.Array{
+						mockShardNode("localhost:7001", "r1", "replica", "online"),
+						mockShardNode(s.Addr, "m1", "master", "online"),
+						mockShardNode("localhost:7002", "r2", "replica", "loading"),
+					},
+				},
+				resp.Array{
+					"slots", resp.Array{int64(100), int64(199)},
+					"nodes", resp.Array{
+						mockShardNode("localhost:7003", "m2", "master", "online"),
+					},
+				},
+				resp.Array{
+					"slots", resp.Array{},
+					"nodes", resp.Array{
+						mockShardNode("localhost:7004", "m3", "master", "online"),
+					},
+				},
+			}
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	c := &Cluster{StartupNodes: []string{s.Addr}}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	// the mock server's address has no host, so the ip is used
+	master := "127.0.0.1" + s.Addr
+	assert.Equal(t, int32(1), atomic.LoadInt32(&shards), "CLUSTER SHARDS")
+
+	c.mu.Lock()
+	assert.Equal(t, []string{master, "localhost:7001"}, c.mapping[0], "slot 0")
+	assert.Equal(t, []string{"localhost:7003"}, c.mapping[150], "slot 150")
+	assert.Equal(t, []string{master, "localhost:7001"}, c.mapping[hashSlots-1], "last slot")
+	c.mu.Unlock()
+
+	want := []Node{
+		{Addr: master, ID: "m1", Health: "online", Slots: []SlotRange{{0, 99}, {200, hashSlots - 1}}},
+		{Addr: "localhost:7001", ID: "r1", Health: "online", Replica: true, Master: master, Slots: []SlotRange{{0, 99}, {200, hashSlots - 1}}},
+		{Addr: "localhost:7003", ID: "m2", Health: "online", Slots: []SlotRange{{100, 199}}},
+	}
+	assert.Equal(t, want, c.Nodes(), "nodes")
+}
+
+func TestClusterRefreshShardsFallback(t *testing.T) {
+	var slots int32
+	var s *redistest.MockServer
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch {
+		case cmd == "CLUSTER" && args[0] == "SHARDS":
+			return resp.Error("ERR unknown subcommand 'SHARDS'")
+		case cmd == "CLUSTER" && args[0] == "SLOTS":
+			atomic.AddInt32(&slots, 1)
+			return mockClusterSlots(s.Addr)
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	c := &Cluster{StartupNodes: []string{s.Addr}}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+	require.NoError(t, c.Refresh(), "Refresh")
+	assert.Equal(t, int32(2), atomic.LoadInt32(&slots), "CLUSTER SLOTS")
+
+	c.mu.Lock()
+	assert.True(t, c.noShards[s.Addr], "CLUSTER SHARDS not supported")
+	assert.Equal(t, []string{s.Addr}, c.mapping[0], "slot 0")
+	c.mu.Unlock()
+}
@@ -0,0 +1,32 @@
+package redisc
+
+import (
+	"github.com/garyburd/redigo/redis"
+)
+
+// CountKeysInSlot returns the number of keys in the hash slot, which must
+// be between 0 and 16383, by executing the CLUSTER COUNTKEYSINSLOT
+// command on the master node that serves that slot.
+func (c *Cluster) CountKeysInSlot(slot int) (int, error) {
+	return redis.Int(c.doInSlot(slot, "COUNTKEYSINSLOT", slot))
+}
+
+// KeysInSlot returns up to count keys of the hash slot, which must be
+// between 0 and 16383, by executing the CLUSTER GETKEYSINSLOT command on
+// the master node that serves that slot.
+func (c *Cluster) KeysInSlot(slot, count int) ([]string, error) {
+	return redis.Strings(c.doInSlot(slot, "GETKEYSINSLOT", slot, count))
+}
+
+// doInSlot executes the CLUSTER subcommand on a connection bound to the
+// master of the slot.
+func (c *Cluster) doInSlot(slot int, subcmd string, args ...interface{}) (interface{}, error) {
+	conn := c.Get()
+	defer conn.Close()
+
+	cc := conn.(*Conn)
+	if err := cc.BindSlot(slot); err != nil {
+		return nil, err
+	}
+	return cc.Do("CLUSTER", append([]interface{}{subcmd}, args...)...)
+}
@@ -0,0 +1,60 @@
+package redisc
+
+import (
+	"testing"
+
+	"github.com/mna/redisc/redistest"
+	"github.com/mna/redisc/redistest/resp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClusterKeysInSlot(t *testing.T) {
+	var s1, s2 *redistest.MockServer
+	handler := func(name string) func(string, ...string) interface{} {
+		return func(cmd string, args ...string) interface{} {
+			switch cmd {
+			case "CLUSTER":
+				switch args[0] {
+				case "SLOTS":
+					return resp.Array{
+						0: resp.Array{0: int64(0), 1: int64(8000), 2: resp.Array{0: "127.0.0.1", 1: int64(mockPort(s1.Addr))}},
+						1: resp.Array{0: int64(8001), 1: int64(16383), 2: resp.Array{0: "127.0.0.1", 1: int64(mockPort(s2.Addr))}},
+					}
+				case "COUNTKEYSINSLOT":
+					if name == "s1" {
+						return int64(1)
+					}
+					return int64(2)
+				case "GETKEYSINSLOT":
+					return resp.Array{name + ":" + args[1], args[2]}
+				}
+			}
+			return resp.Error("unexpected command " + cmd)
+		}
+	}
+	s1 = redistest.StartMockServer(t, handler("s1"))
+	defer s1.Close()
+	s2 = redistest.StartMockServer(t, handler("s2"))
+	defer s2.Close()
+
+	c := &Cluster{StartupNodes: []string{s1.Addr}}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	n, err := c.CountKeysInSlot(10)
+	require.NoError(t, err, "CountKeysInSlot")
+	assert.Equal(t, 1, n, "count on s1")
+	n, err = c.CountKeysInSlot(10000)
+	require.NoError(t, err, "CountKeysInSlot")
+	assert.Equal(t, 2, n, "count on s2")
+
+	keys, err := c.KeysInSlot(10000, 5)
+	require.NoError(t, err, "KeysInSlot")
+	assert.Equal(t, []string{"s2:10000", "5"}, keys, "keys from s2")
+
+	_, err = c.KeysInSlot(hashSlots, 1)
+	assert.Error(t, err, "invalid slot")
+	_, err = c.CountKeysInSlot(-1)
+	assert.Error(t, err, "invalid slot")
+}
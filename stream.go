@@ -0,0 +1,80 @@
+package redisc
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// XRead reads the entries of the streams after the IDs with the XREAD
+// command, using a BlockingConn bound to the node of the streams, which
+// must all belong to the same slot (otherwise an error that wraps
+// ErrCrossSlot is returned before anything is sent). The ids are the IDs
+// of each stream, in the same order. The connection is held until the
+// command returns, then released.
+//
+// If block is negative, the command does not block. Otherwise, the BLOCK
+// option is set with that duration, a block of 0 blocking indefinitely,
+// and the read timeout of the connection is set accordingly as for
+// BlockingConn.Do. If count is greater than 0, the COUNT option is set.
+// The reply is returned as-is, it is nil if no entry was read.
+func (c *Cluster) XRead(block time.Duration, count int, streams, ids []string) (interface{}, error) {
+	b, err := c.BlockingConn(streams...)
+	if err != nil {
+		return nil, err
+	}
+	defer b.Close()
+	return b.XRead(block, count, streams, ids)
+}
+
+// XReadGroup is like XRead, but it reads the entries as the consumer of
+// the consumer group with the XREADGROUP command.
+func (c *Cluster) XReadGroup(group, consumer string, block time.Duration, count int, streams, ids []string) (interface{}, error) {
+	b, err := c.BlockingConn(streams...)
+	if err != nil {
+		return nil, err
+	}
+	defer b.Close()
+	return b.XReadGroup(group, consumer, block, count, streams, ids)
+}
+
+// XRead is like Cluster.XRead, but it runs the XREAD command on the
+// connection, e.g. to read the streams in a loop with the same node
+// connection. The streams must belong to the slot of the keys of the
+// connection.
+func (b *BlockingConn) XRead(block time.Duration, count int, streams, ids []string) (interface{}, error) {
+	return b.xread("XREAD", nil, block, count, streams, ids)
+}
+
+// XReadGroup is like Cluster.XReadGroup, but it runs the XREADGROUP
+// command on the connection, as for XRead.
+func (b *BlockingConn) XReadGroup(group, consumer string, block time.Duration, count int, streams, ids []string) (interface{}, error) {
+	return b.xread("XREADGROUP", redis.Args{"GROUP", group, consumer}, block, count, streams, ids)
+}
+
+func (b *BlockingConn) xread(cmd string, args redis.Args, block time.Duration, count int, streams, ids []string) (interface{}, error) {
+	if len(streams) == 0 || len(streams) != len(ids) {
+		return nil, errors.New("redisc: streams and ids must be non-empty and of the same length")
+	}
+	if b.slot >= 0 {
+		cluster := b.conn.cluster
+		for _, s := range streams {
+			if slot := cluster.slot(s); slot != b.slot {
+				return nil, fmt.Errorf("%w: stream %q (slot %d) and connection (slot %d)", ErrCrossSlot, s, slot, b.slot)
+			}
+		}
+	}
+
+	if count > 0 {
+		args = args.Add("COUNT", count)
+	}
+	timeout := defaultTimeout
+	if block >= 0 {
+		args = args.Add("BLOCK", int64(block/time.Millisecond))
+		timeout = blockingReadTimeout(block)
+	}
+	args = args.Add("STREAMS").AddFlat(streams).AddFlat(ids)
+	return b.conn.DoWithTimeout(timeout, cmd, args...)
+}
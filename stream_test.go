@@ -0,0 +1,71 @@
+package redisc
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/mna/redisc/redistest"
+	"github.com/mna/redisc/redistest/resp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClusterXRead(t *testing.T) {
+	var s *redistest.MockServer
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return mockClusterSlots(s.Addr)
+		case "XREAD", "XREADGROUP":
+			for i, arg := range args {
+				if arg == "BLOCK" && args[i+1] != "0" {
+					// no entry, time out after the block delay
+					time.Sleep(50 * time.Millisecond)
+					return nil
+				}
+			}
+			return cmd + " " + strings.Join(args, " ")
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s.Addr},
+		DialOptions:  []redis.DialOption{redis.DialReadTimeout(20 * time.Millisecond)},
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	v, err := redis.String(c.XRead(-1, 10, []string{"{t1}a", "{t1}b"}, []string{"0", "$"}))
+	if assert.NoError(t, err, "XRead") {
+		assert.Equal(t, "XREAD COUNT 10 STREAMS {t1}a {t1}b 0 $", v, "XRead arguments")
+	}
+
+	// the read timeout is adjusted to the block delay
+	reply, err := c.XRead(50*time.Millisecond, 0, []string{"a"}, []string{"$"})
+	if assert.NoError(t, err, "XRead with block") {
+		assert.Nil(t, reply, "timed out")
+	}
+
+	v, err = redis.String(c.XReadGroup("g", "c1", 0, 0, []string{"a"}, []string{">"}))
+	if assert.NoError(t, err, "XReadGroup") {
+		assert.Equal(t, "XREADGROUP GROUP g c1 BLOCK 0 STREAMS a >", v, "XReadGroup arguments")
+	}
+
+	_, err = c.XRead(-1, 0, []string{"a", "b"}, []string{"0", "0"})
+	assert.True(t, errors.Is(err, ErrCrossSlot), "XRead with streams in different slots")
+	_, err = c.XRead(-1, 0, []string{"a"}, nil)
+	assert.Error(t, err, "XRead without ID")
+
+	bc, err := c.BlockingConn("{t1}a")
+	require.NoError(t, err, "BlockingConn")
+	defer bc.Close()
+	_, err = bc.XRead(-1, 0, []string{"{t1}b"}, []string{"0"})
+	assert.NoError(t, err, "XRead on the slot of the connection")
+	_, err = bc.XRead(-1, 0, []string{"b"}, []string{"0"})
+	assert.True(t, errors.Is(err, ErrCrossSlot), "XRead on another slot")
+}
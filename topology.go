@@ -0,0 +1,47 @@
+package redisc
+
+import "context"
+
+// TopologyProvider is the interface implemented by the external sources of
+// the cluster's topology, e.g. a configuration store updated by a
+// controller, so that the clients don't query the nodes for the mapping of
+// slots. See the Cluster.TopologyProvider field.
+type TopologyProvider interface {
+	// Topology returns the current mapping of slots to nodes. Each entry
+	// lists the address of the master node serving that slot, followed by
+	// the addresses of its replicas, as announced by the cluster (the
+	// AddressRewriter is applied to them). It receives the context of the
+	// refresh. The returned mapping is not modified nor retained by the
+	// cluster.
+	Topology(ctx context.Context) (*Mapping, error)
+}
+
+// getProviderSlots returns the mapping of slots to nodes reported by the
+// TopologyProvider. It returns ErrClusterNotReady if the mapping has no
+// slot.
+func (c *Cluster) getProviderSlots(ctx context.Context) ([]slotMapping, error) {
+	m, err := c.TopologyProvider.Topology(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return nil, ErrClusterNotReady
+	}
+
+	// group the consecutive slots served by the same nodes
+	var sms []slotMapping
+	for ix, nodes := range m {
+		if len(nodes) == 0 || nodes[0] == "" {
+			continue
+		}
+		if n := len(sms); n > 0 && sms[n-1].end == ix-1 && sameNodes(sms[n-1].nodes, nodes) {
+			sms[n-1].end = ix
+			continue
+		}
+		sms = append(sms, slotMapping{start: ix, end: ix, nodes: append([]string(nil), nodes...)})
+	}
+	if len(sms) == 0 {
+		return nil, ErrClusterNotReady
+	}
+	return sms, nil
+}
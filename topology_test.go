@@ -0,0 +1,77 @@
+package redisc
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/mna/redisc/redistest"
+	"github.com/mna/redisc/redistest/resp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type topologyFunc func(ctx context.Context) (*Mapping, error)
+
+func (fn topologyFunc) Topology(ctx context.Context) (*Mapping, error) {
+	return fn(ctx)
+}
+
+func TestClusterTopologyProvider(t *testing.T) {
+	var s1, s2 *redistest.MockServer
+	handler := func(name string) func(string, ...string) interface{} {
+		return func(cmd string, args ...string) interface{} {
+			switch cmd {
+			case "CLUSTER":
+				return resp.Error("unexpected CLUSTER command")
+			case "GET":
+				return name
+			}
+			return resp.Error("unexpected command " + cmd)
+		}
+	}
+	s1 = redistest.StartMockServer(t, handler("s1"))
+	defer s1.Close()
+	s2 = redistest.StartMockServer(t, handler("s2"))
+	defer s2.Close()
+
+	var m Mapping
+	for i := 0; i < 8192; i++ {
+		m[i] = []string{s1.Addr, s2.Addr}
+	}
+	var fail error
+	c := &Cluster{
+		TopologyProvider: topologyFunc(func(ctx context.Context) (*Mapping, error) {
+			if fail != nil {
+				return nil, fail
+			}
+			return &m, nil
+		}),
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	got := c.Mapping()
+	assert.Empty(t, got.ChangedSlots(&m), "mapping")
+	assert.Equal(t, []string{s1.Addr}, c.getNodeAddrs(false), "masters")
+	assert.Equal(t, []string{s2.Addr}, c.getNodeAddrs(true), "replicas")
+	assert.Len(t, c.UnassignedSlots(), 8192, "unassigned slots")
+
+	conn := c.Get()
+	defer conn.Close()
+	v, err := redis.String(conn.Do("GET", "b"))
+	if assert.NoError(t, err, "GET b") {
+		assert.Equal(t, "s1", v, "node of b")
+	}
+
+	// a failure of the provider keeps the current mapping
+	fail = errors.New("unavailable")
+	assert.Equal(t, fail, c.Refresh(), "Refresh with failure")
+	got = c.Mapping()
+	assert.Empty(t, got.ChangedSlots(&m), "mapping after failure")
+
+	fail = nil
+	m = Mapping{}
+	assert.Equal(t, ErrClusterNotReady, c.Refresh(), "Refresh with empty mapping")
+}
@@ -0,0 +1,84 @@
+package redisc
+
+import (
+	"errors"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Transaction executes a MULTI/EXEC transaction on the node that serves
+// the slot of the keys, which must all belong to the same slot (otherwise
+// an error is returned before anything is sent). It calls fn with a
+// connection bound to that node, after MULTI was sent: fn queues the
+// commands of the transaction, typically with conn.Send. If fn returns an
+// error, the transaction is discarded with DISCARD and that error is
+// returned, otherwise EXEC is sent and its replies are returned, one per
+// queued command.
+func (c *Cluster) Transaction(keys []string, fn func(conn redis.Conn) error) ([]interface{}, error) {
+	conn := c.Get()
+	defer conn.Close()
+
+	if err := BindConn(conn, keys...); err != nil {
+		return nil, err
+	}
+	return transaction(conn, fn)
+}
+
+// transaction runs the MULTI/EXEC transaction on conn, queuing the
+// commands with fn.
+func transaction(conn redis.Conn, fn func(conn redis.Conn) error) ([]interface{}, error) {
+	if err := conn.Send("MULTI"); err != nil {
+		return nil, err
+	}
+	if err := fn(conn); err != nil {
+		conn.Do("DISCARD")
+		return nil, err
+	}
+	return redis.Values(conn.Do("EXEC"))
+}
+
+// ErrWatchAborted is returned by Watch when the transaction was aborted
+// on each attempt because a watched key was modified.
+var ErrWatchAborted = errors.New("redisc: transaction aborted, watched keys were modified")
+
+// Watch executes a check-and-set transaction on the node that serves the
+// slot of the keys, which must all belong to the same slot (otherwise an
+// error is returned before anything is sent). The keys are watched with
+// WATCH, then read is called to read the current values and decide what
+// to do, and queue is called to queue the commands of the MULTI/EXEC
+// transaction, as for Transaction. Both functions are called with the
+// same connection, bound to the node of the keys.
+//
+// If a watched key is modified before EXEC, the transaction is aborted
+// and the whole sequence is attempted again, up to maxAttempts times
+// (which must be at least 1), after which ErrWatchAborted is returned.
+// If read or queue returns an error, the keys are unwatched and that
+// error is returned. Otherwise, the replies of EXEC are returned.
+func (c *Cluster) Watch(keys []string, maxAttempts int, read, queue func(conn redis.Conn) error) ([]interface{}, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("redisc: no key to watch")
+	}
+
+	conn := c.Get()
+	defer conn.Close()
+
+	if err := BindConn(conn, keys...); err != nil {
+		return nil, err
+	}
+
+	args := redis.Args{}.AddFlat(keys)
+	for att := 0; att < maxAttempts; att++ {
+		if _, err := conn.Do("WATCH", args...); err != nil {
+			return nil, err
+		}
+		if err := read(conn); err != nil {
+			conn.Do("UNWATCH")
+			return nil, err
+		}
+		vals, err := transaction(conn, queue)
+		if err != redis.ErrNil {
+			return vals, err
+		}
+	}
+	return nil, ErrWatchAborted
+}
@@ -0,0 +1,167 @@
+package redisc
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/mna/redisc/redistest"
+	"github.com/mna/redisc/redistest/resp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClusterTransaction(t *testing.T) {
+	var s *redistest.MockServer
+	var mu sync.Mutex
+	var cmds []string
+	var queued int
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		if cmd == "CLUSTER" {
+			return mockClusterSlots(s.Addr)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		cmds = append(cmds, cmd)
+		switch cmd {
+		case "MULTI", "DISCARD":
+			queued = 0
+			return "OK"
+		case "SET", "INCR":
+			queued++
+			return "QUEUED"
+		case "EXEC":
+			res := make(resp.Array, queued)
+			for i := range res {
+				res[i] = int64(i + 1)
+			}
+			return res
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s.Addr},
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	_, err := c.Transaction([]string{"a", "b"}, func(conn redis.Conn) error {
+		t.Fatal("unexpected call")
+		return nil
+	})
+	assert.Error(t, err, "keys in different slots")
+
+	res, err := redis.Ints(c.Transaction([]string{"{a}1", "{a}2"}, func(conn redis.Conn) error {
+		if err := conn.Send("SET", "{a}1", "x"); err != nil {
+			return err
+		}
+		return conn.Send("INCR", "{a}2")
+	}))
+	if assert.NoError(t, err, "Transaction") {
+		assert.Equal(t, []int{1, 2}, res, "EXEC replies")
+	}
+
+	fnErr := errors.New("abort")
+	_, err = c.Transaction([]string{"a"}, func(conn redis.Conn) error {
+		if err := conn.Send("SET", "a", "x"); err != nil {
+			return err
+		}
+		return fnErr
+	})
+	assert.Equal(t, fnErr, err, "Transaction error")
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"MULTI", "SET", "INCR", "EXEC", "MULTI", "SET", "DISCARD"}, cmds, "commands")
+}
+
+func TestClusterWatch(t *testing.T) {
+	var s *redistest.MockServer
+	var mu sync.Mutex
+	var cmds []string
+	var conflicts, queued int
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		if cmd == "CLUSTER" {
+			return mockClusterSlots(s.Addr)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		cmds = append(cmds, cmd)
+		switch cmd {
+		case "WATCH", "UNWATCH", "MULTI":
+			queued = 0
+			return "OK"
+		case "GET":
+			return "1"
+		case "SET":
+			queued++
+			return "QUEUED"
+		case "EXEC":
+			if conflicts > 0 {
+				conflicts--
+				return nil
+			}
+			return resp.Array{"OK"}
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s.Addr},
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	reset := func(n int) {
+		mu.Lock()
+		defer mu.Unlock()
+		cmds, conflicts = nil, n
+	}
+	calls := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return cmds
+	}
+
+	var v int
+	read := func(conn redis.Conn) (err error) {
+		v, err = redis.Int(conn.Do("GET", "{a}1"))
+		return err
+	}
+	queue := func(conn redis.Conn) error {
+		return conn.Send("SET", "{a}1", v+1)
+	}
+
+	_, err := c.Watch([]string{"a", "b"}, 3, read, queue)
+	assert.Error(t, err, "keys in different slots")
+
+	reset(2)
+	res, err := redis.Strings(c.Watch([]string{"{a}1", "{a}2"}, 3, read, queue))
+	if assert.NoError(t, err, "Watch") {
+		assert.Equal(t, []string{"OK"}, res, "EXEC replies")
+	}
+	attempt := []string{"WATCH", "GET", "MULTI", "SET", "EXEC"}
+	var want []string
+	for i := 0; i < 3; i++ {
+		want = append(want, attempt...)
+	}
+	assert.Equal(t, want, calls(), "commands with conflicts")
+
+	reset(3)
+	_, err = c.Watch([]string{"{a}1"}, 3, read, queue)
+	assert.Equal(t, ErrWatchAborted, err, "too many conflicts")
+
+	reset(0)
+	readErr := errors.New("abort")
+	_, err = c.Watch([]string{"{a}1"}, 3, func(conn redis.Conn) error {
+		return readErr
+	}, queue)
+	assert.Equal(t, readErr, err, "read error")
+	assert.Equal(t, []string{"WATCH", "UNWATCH"}, calls(), "commands with read error")
+}
@@ -0,0 +1,32 @@
+package redisc
+
+import (
+	"fmt"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// verifySlot compares the slot computed for key with the slot returned
+// by CLUSTER KEYSLOT on rc, for a VerifySlotsRate fraction of the calls.
+// It returns an error if they are different.
+func (c *Cluster) verifySlot(rc redis.Conn, key interface{}) error {
+	rnd.Lock()
+	skip := rnd.Float64() >= c.VerifySlotsRate
+	rnd.Unlock()
+	if skip {
+		return nil
+	}
+
+	k := fmt.Sprintf("%s", key)
+	want, err := redis.Int(rc.Do("CLUSTER", "KEYSLOT", k))
+	if err != nil {
+		// the verification is best-effort
+		c.logf("redisc: failed to verify slot of key %q: %v", k, err)
+		return nil
+	}
+	if got := c.slot(k); got != want {
+		c.logf("redisc: slot mismatch for key %q: computed %d, server %d", k, got, want)
+		return fmt.Errorf("redisc: slot mismatch for key %q: computed %d, server %d", k, got, want)
+	}
+	return nil
+}
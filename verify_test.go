@@ -0,0 +1,58 @@
+package redisc
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/mna/redisc/redistest"
+	"github.com/mna/redisc/redistest/resp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClusterVerifySlots(t *testing.T) {
+	var s *redistest.MockServer
+	var keyslots int32
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			if args[0] == "KEYSLOT" {
+				atomic.AddInt32(&keyslots, 1)
+				return int64(Slot(args[1]))
+			}
+			return mockClusterSlots(s.Addr)
+		case "GET":
+			return args[0]
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s.Addr},
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	// disabled by default
+	_, err := c.Do("GET", "a")
+	require.NoError(t, err, "GET")
+	assert.Equal(t, int32(0), atomic.LoadInt32(&keyslots), "not verified")
+
+	c.VerifySlotsRate = 1
+	v, err := redis.String(c.Do("GET", "{a}b"))
+	if assert.NoError(t, err, "GET") {
+		assert.Equal(t, "{a}b", v, "GET result")
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&keyslots), "verified")
+
+	// a key to slot function that doesn't match the server
+	c.KeyToSlot = func(key []byte) int {
+		return 0
+	}
+	_, err = c.Do("GET", "a")
+	if assert.Error(t, err, "GET with mismatch") {
+		assert.Contains(t, err.Error(), "slot mismatch", "expected message")
+	}
+}
@@ -0,0 +1,47 @@
+package redisc
+
+import (
+	"context"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// warmupPools creates up to n connections in the pool of each node at
+// addrs, and returns them to their pool so that they are available as
+// idle connections. It never waits for a connection, so it doesn't
+// compete with the application for a pool that is at its MaxActive limit.
+func (c *Cluster) warmupPools(addrs []string, n int) {
+	for _, addr := range addrs {
+		c.warmupPool(addr, n)
+	}
+}
+
+func (c *Cluster) warmupPool(addr string, n int) {
+	var conns []redis.Conn
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+
+	for len(conns) < n {
+		c.mu.RLock()
+		err, p := c.err, c.pools[addr]
+		c.mu.RUnlock()
+		if err != nil {
+			return
+		}
+		if p != nil {
+			st := p.Stats()
+			if p.MaxIdle <= len(conns) || (p.MaxActive > 0 && st.ActiveCount >= p.MaxActive) {
+				return
+			}
+		}
+
+		conn, err := c.getConnForAddr(context.Background(), addr, false)
+		if err != nil {
+			return
+		}
+		conns = append(conns, conn)
+	}
+}
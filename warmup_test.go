@@ -0,0 +1,101 @@
+package redisc
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/mna/redisc/redistest"
+	"github.com/mna/redisc/redistest/resp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClusterWarmupPools(t *testing.T) {
+	var s1, s2 *redistest.MockServer
+	var failover int32
+	handler := func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			if atomic.LoadInt32(&failover) == 1 {
+				return mockClusterSlots(s2.Addr)
+			}
+			return mockClusterSlots(s1.Addr)
+		case "GET":
+			return args[0]
+		}
+		return resp.Error("unexpected command " + cmd)
+	}
+	s1 = redistest.StartMockServer(t, handler)
+	defer s1.Close()
+	s2 = redistest.StartMockServer(t, handler)
+	defer s2.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s1.Addr},
+		CreatePool: func(addr string, opts ...redis.DialOption) (*redis.Pool, error) {
+			return &redis.Pool{
+				MaxIdle: 2,
+				Dial: func() (redis.Conn, error) {
+					return redis.Dial("tcp", addr, opts...)
+				},
+			}, nil
+		},
+		WarmupPools: 5,
+	}
+	defer c.Close()
+
+	idle := func(addr string) int {
+		deadline := time.Now().Add(time.Second)
+		for time.Now().Before(deadline) {
+			if n := c.Stats()[addr].IdleCount; n > 0 {
+				// give the warmup a chance to complete
+				time.Sleep(10 * time.Millisecond)
+				return c.Stats()[addr].IdleCount
+			}
+			time.Sleep(time.Millisecond)
+		}
+		return 0
+	}
+
+	require.NoError(t, c.Refresh(), "Refresh")
+	assert.Equal(t, 2, idle(s1.Addr), "warmed up connections limited by MaxIdle")
+
+	atomic.StoreInt32(&failover, 1)
+	require.NoError(t, c.Refresh(), "Refresh")
+	assert.Equal(t, 2, idle(s2.Addr), "new node warmed up")
+
+	// the first request uses a warmed up connection
+	conn := c.Get()
+	defer conn.Close()
+	v, err := redis.String(conn.Do("GET", "a"))
+	require.NoError(t, err, "GET")
+	assert.Equal(t, "a", v, "GET result")
+	// the pool's active count includes the idle connections, no new
+	// connection was dialed
+	st := c.Stats()[s2.Addr]
+	assert.Equal(t, 2, st.ActiveCount, "active connections")
+	assert.Equal(t, 1, st.IdleCount, "idle connections")
+}
+
+func TestClusterWarmupPoolsDisabled(t *testing.T) {
+	var s *redistest.MockServer
+	s = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		return mockClusterSlots(s.Addr)
+	})
+	defer s.Close()
+
+	c := &Cluster{
+		StartupNodes: []string{s.Addr},
+		CreatePool:   createPool,
+	}
+	defer c.Close()
+
+	require.NoError(t, c.Refresh(), "Refresh")
+	time.Sleep(50 * time.Millisecond)
+	// the pool is created by the refresh, but no connection is dialed
+	st := c.Stats()[s.Addr]
+	assert.Equal(t, 0, st.ActiveCount, "active connections")
+	assert.Equal(t, 0, st.IdleCount, "idle connections")
+}
@@ -0,0 +1,53 @@
+package redisc
+
+import (
+	"errors"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// WithKey calls fn with a connection bound to the node that serves the
+// slot of key, so that all the commands executed by fn (e.g. to read,
+// modify and write that key) are sent to the same node without binding
+// the connection again. The connection is released when fn returns, and
+// the error returned by fn is returned.
+func (c *Cluster) WithKey(key string, fn func(conn redis.Conn) error) error {
+	return c.WithKeyRetry(key, 1, fn)
+}
+
+// WithKeyRetry is like WithKey, but if fn returns a MOVED redirection
+// (possibly wrapped), e.g. because the slot moved to another node while
+// fn was executing, fn is called again with a new connection bound to the
+// new node of the slot, up to maxAttempts calls (which must be at least
+// 1). The commands executed by fn before the redirection are not undone,
+// so fn must be safe to call again. The redirections are not retried if
+// the cluster's DisableAutoRefreshOnMoved field is set.
+func (c *Cluster) WithKeyRetry(key string, maxAttempts int, fn func(conn redis.Conn) error) error {
+	if maxAttempts < 1 {
+		return errors.New("redisc: maxAttempts must be at least 1")
+	}
+
+	var err error
+	for att := 0; att < maxAttempts; att++ {
+		if err = c.withKey(key, fn); err == nil {
+			return nil
+		}
+		// the MOVED reply updated the mapping, the next attempt binds to
+		// the new node of the slot
+		re := ParseRedir(err)
+		if re == nil || re.Type != "MOVED" || c.DisableAutoRefreshOnMoved {
+			return err
+		}
+	}
+	return err
+}
+
+func (c *Cluster) withKey(key string, fn func(conn redis.Conn) error) error {
+	conn := c.Get()
+	defer conn.Close()
+
+	if err := BindConn(conn, key); err != nil {
+		return err
+	}
+	return fn(conn)
+}
@@ -0,0 +1,85 @@
+package redisc
+
+import (
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/mna/redisc/redistest"
+	"github.com/mna/redisc/redistest/resp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClusterWithKey(t *testing.T) {
+	var s1, s2 *redistest.MockServer
+	var moved int32
+	s1 = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "CLUSTER":
+			return mockClusterSlots(s1.Addr)
+		case "GET":
+			if atomic.LoadInt32(&moved) == 1 {
+				return resp.Error("MOVED " + strconv.Itoa(Slot(args[0])) + " " + s2.Addr)
+			}
+			return "1"
+		case "SET":
+			return resp.OK{}
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s1.Close()
+	s2 = redistest.StartMockServer(t, func(cmd string, args ...string) interface{} {
+		switch cmd {
+		case "GET":
+			return "2"
+		case "SET":
+			return resp.OK{}
+		}
+		return resp.Error("unexpected command " + cmd)
+	})
+	defer s2.Close()
+
+	c := &Cluster{
+		StartupNodes:    []string{s1.Addr},
+		RefreshCooldown: time.Minute,
+	}
+	defer c.Close()
+	require.NoError(t, c.Refresh(), "Refresh")
+
+	var addrs []string
+	incr := func(conn redis.Conn) error {
+		addr, _ := conn.(*Conn).BoundAddr()
+		addrs = append(addrs, addr)
+		n, err := redis.Int(conn.Do("GET", "a"))
+		if err != nil {
+			return fmt.Errorf("get: %w", err)
+		}
+		_, err = conn.Do("SET", "a", n+1)
+		return err
+	}
+
+	require.NoError(t, c.WithKey("a", incr), "WithKey")
+	assert.Equal(t, []string{s1.Addr}, addrs, "bound addresses")
+
+	// the slot moves, WithKey returns the redirection
+	atomic.StoreInt32(&moved, 1)
+	addrs = nil
+	err := c.WithKey("a", incr)
+	if re := ParseRedir(err); assert.NotNil(t, re, "MOVED") {
+		assert.Equal(t, s2.Addr, re.Addr, "redirection address")
+	}
+
+	// WithKeyRetry calls fn again on the new node
+	c.mu.Lock()
+	c.mapping[Slot("a")] = []string{s1.Addr}
+	c.mu.Unlock()
+	addrs = nil
+	require.NoError(t, c.WithKeyRetry("a", 2, incr), "WithKeyRetry")
+	assert.Equal(t, []string{s1.Addr, s2.Addr}, addrs, "bound addresses")
+
+	assert.Error(t, c.WithKeyRetry("a", 0, incr), "invalid attempts")
+}